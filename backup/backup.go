@@ -0,0 +1,160 @@
+// Package backup backs up MongoDB databases to the Storj network and restores them, for embedding in Go programs.
+//
+// Its functions take a context, cancelling the transfers in flight once it is done, and report their progress
+// through an optional Progress callback. They return errors of the Error type, classified by kind,
+// such as ErrNoBackup or ErrAuth, to be tested with errors.Is.
+package backup
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Version is the released version of the connector, recorded in the manifest of every back-up.
+const Version = "1.0.5"
+
+// BackupOptions defines how a back-up is taken.
+type BackupOptions struct {
+	// UploadOptions defines how the back-up is uploaded, along with the callback receiving its progress.
+	UploadOptions UploadOptions
+	// Oplog captures the oplog written during the back-up, making it consistent as of its end.
+	Oplog bool
+	// Incremental only uploads the changes made since the latest back-up of the database.
+	Incremental bool
+	// Checkpoint is the file recording the progress of full back-ups, which upload their collections in parts if it is set.
+	Checkpoint string
+	// Resume continues the interrupted back-up of the database recorded in the checkpoint, if any.
+	Resume bool
+}
+
+// Store takes a back-up of the database described by configMongoDB and uploads it under the configured bucket and upload path,
+// completing it with its manifest. The uploads are aborted once ctx is cancelled.
+// It returns the manifest of the back-up and any error, if occurred.
+func Store(ctx context.Context, storage Storage, storjConfig ConfigStorj, configMongoDB ConfigMongoDB, backupOptions BackupOptions) (*BackupManifest, error) {
+
+	if backupOptions.Oplog && backupOptions.Incremental {
+		return nil, errorf(ErrConfig, "the oplog cannot be captured with incremental back-ups")
+	}
+	if backupOptions.Resume && (backupOptions.Checkpoint == "" || backupOptions.Incremental) {
+		return nil, errorf(ErrConfig, "only full back-ups with a checkpoint file can be resumed")
+	}
+	if _, ok := storage.(MultipartStorage); !ok && backupOptions.Checkpoint != "" && !backupOptions.Incremental {
+		return nil, errorf(ErrConfig, "the storage does not support the multipart uploads of back-ups with a checkpoint file")
+	}
+	progress := backupOptions.UploadOptions.Progress
+
+	// Establish connection with MongoDB and create the customized reader to implement streaming
+	reader, err := OpenDatabase(ctx, configMongoDB)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	// Load the progress of the interrupted back-up of the database, if any.
+	var checkpoint *Checkpoint
+	var backup *BackupCheckpoint
+	checkpointKey := storjConfig.Bucket + "/" + storjConfig.UploadPath + configMongoDB.Database
+	if backupOptions.Checkpoint != "" && !backupOptions.Incremental {
+		if checkpoint, err = LoadCheckpoint(backupOptions.Checkpoint); err != nil {
+			return nil, newError(ErrConfig, err)
+		}
+		backup = checkpoint.Backup(checkpointKey)
+		if backup != nil && !backupOptions.Resume {
+			// Start over, discarding the parts uploaded by the interrupted back-up.
+			if err = backup.Abort(ctx, storage, storjConfig); err != nil {
+				progress.warnf("%s", err)
+			}
+			backup = nil
+		} else if backup != nil {
+			if err = backup.Matches(backupOptions.UploadOptions); err != nil {
+				return nil, newError(ErrConfig, err)
+			}
+			progress.infof("Resuming back-up %s started at %s.", backup.UploadFileName, backup.Manifest.StartedAt.Local().Format(time.RFC3339))
+		} else if backupOptions.Resume {
+			progress.infof("No interrupted back-up of %s to resume, starting a new one.", configMongoDB.Database)
+		}
+	}
+
+	var uploadFileName string
+	var manifest BackupManifest
+	if backup != nil {
+		uploadFileName, manifest = backup.UploadFileName, backup.Manifest
+		manifest.Collections = []CollectionManifest{}
+	} else {
+		startedAt := time.Now()
+		// Record the oplog position, from which the oplog is replayed on point-in-time recovery.
+		var oplogStart *primitive.Timestamp
+		if timestamp, err := reader.OplogTimestamp(ctx); err == nil {
+			oplogStart = &timestamp
+		} else if backupOptions.Oplog {
+			return nil, newError(ErrUpload, err)
+		}
+		uploadFileName = path.Join(configMongoDB.Database, configMongoDB.Database+startedAt.Format(BackupTimeFormat))
+
+		// Record what the back-up contains, marking it as complete.
+		manifest = BackupManifest{
+			ConnectorVersion: Version,
+			FormatVersion:    bsonFormatVersion,
+			Host:             configMongoDB.Host(),
+			Database:         configMongoDB.Database,
+			StartedAt:        startedAt.UTC(),
+			OplogStart:       oplogStart,
+			Collections:      []CollectionManifest{},
+		}
+		if !backupOptions.Incremental {
+			// The changes made from now on are read by the next incremental back-up.
+			if resumeToken, err := reader.ResumeToken(ctx); err == nil {
+				manifest.ResumeToken = resumeToken
+			}
+		}
+		if checkpoint != nil {
+			if backup, err = checkpoint.Start(checkpointKey, uploadFileName, manifest, backupOptions.UploadOptions); err != nil {
+				return nil, newError(ErrConfig, err)
+			}
+		}
+	}
+	if backupOptions.Oplog && manifest.OplogStart == nil {
+		return nil, errorf(ErrUpload, "the interrupted back-up has no oplog position to capture the oplog from")
+	}
+	backupOptions.UploadOptions.checkpoint = backup
+
+	if backupOptions.Incremental {
+		// Upload the changes made since the latest back-up, chaining to it.
+		previousBackup, previous, err := latestBackupManifest(ctx, storage, storjConfig.Bucket, storjConfig.UploadPath+configMongoDB.Database+"/")
+		if err != nil {
+			return nil, newError(ErrDownload, err)
+		}
+		if previous == nil {
+			return nil, errorf(ErrNoBackup, "no complete back-up of the database to base the incremental back-up on, a full back-up is required first")
+		}
+		changes, resumeToken, err := UploadChanges(ctx, storage, storjConfig, uploadFileName, reader, previous, backupOptions.UploadOptions)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Parent, manifest.Changes, manifest.ResumeToken = previousBackup.Prefix, &changes, resumeToken
+	} else {
+		if manifest.Collections, err = Upload(ctx, storage, storjConfig, uploadFileName, reader, backupOptions.UploadOptions); err != nil {
+			return nil, err
+		}
+	}
+	if backupOptions.Oplog {
+		oplog, oplogEnd, err := UploadBackupOplog(ctx, storage, storjConfig, uploadFileName, reader, *manifest.OplogStart, backupOptions.UploadOptions)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Oplog, manifest.OplogEnd = &oplog, &oplogEnd
+	}
+	manifest.FinishedAt = time.Now().UTC()
+	if err = UploadManifest(ctx, storage, storjConfig, uploadFileName, manifest, progress); err != nil {
+		return nil, err
+	}
+	if backup != nil {
+		if err = backup.Finish(); err != nil {
+			return nil, newError(ErrConfig, err)
+		}
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthChunk is the most read at once through a bandwidth limiter, so that the bandwidth stays even.
+const bandwidthChunk = 65536
+
+// byteUnits maps the suffixes of bandwidths to their number of bytes.
+var byteUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseBandwidth parses a bandwidth in bytes per second, such as 500KB, 10MB or 1GiB, an optional /s suffix being allowed.
+func ParseBandwidth(value string) (int64, error) {
+
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "/S")
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	bandwidth, err := strconv.ParseFloat(number, 64)
+	if err != nil || bandwidth <= 0 {
+		return 0, errorf(ErrConfig, "invalid bandwidth %q, expected a positive size such as 500KB or 10MB", value)
+	}
+	return int64(bandwidth * float64(multiplier)), nil
+}
+
+// bandwidthLimiter limits the total bandwidth of the readers it wraps.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+	mu             sync.Mutex
+	// next is the time at which the bytes read so far are paid for.
+	next time.Time
+}
+
+// newBandwidthLimiter creates a limiter of the given bandwidth, in bytes per second.
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until the bandwidth allows n more bytes to be read.
+func (limiter *bandwidthLimiter) wait(n int) {
+
+	limiter.mu.Lock()
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+	limiter.next = limiter.next.Add(time.Duration(int64(n) * int64(time.Second) / limiter.bytesPerSecond))
+	delay := limiter.next.Sub(now)
+	limiter.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// NewReader returns a reader reading from reader within the bandwidth of the limiter.
+func (limiter *bandwidthLimiter) NewReader(reader io.Reader) io.Reader {
+
+	return &limitedReader{limiter: limiter, reader: reader}
+}
+
+// limitedReader reads through a bandwidth limiter.
+type limitedReader struct {
+	limiter *bandwidthLimiter
+	reader  io.Reader
+}
+
+// Read reads at most a chunk, then waits for the bandwidth to allow it.
+func (limitedReader *limitedReader) Read(buf []byte) (int, error) {
+
+	if len(buf) > bandwidthChunk {
+		buf = buf[:bandwidthChunk]
+	}
+	n, err := limitedReader.reader.Read(buf)
+	if n > 0 {
+		limitedReader.limiter.wait(n)
+	}
+	return n, err
+}
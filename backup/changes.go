@@ -0,0 +1,265 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changesFileName is the name of the object holding the change events of an incremental back-up.
+const changesFileName = "changes.bson"
+
+// ResumeToken returns the extended JSON resume token of a change stream opened on the database now,
+// from which the changes made after this point can be read by the next incremental back-up.
+func (mongoReader *MongoReader) ResumeToken(ctx context.Context) (json.RawMessage, error) {
+
+	stream, err := mongoReader.database.Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a change stream: %w", err)
+	}
+	defer func() { _ = stream.Close(ctx) }()
+
+	token := stream.ResumeToken()
+	if token == nil {
+		return nil, errors.New("the server returned no resume token")
+	}
+	return bson.MarshalExtJSON(token, true, false)
+}
+
+// ChangeStreamReader implements an io.Reader interface over a change stream,
+// copying the change events as concatenated BSON until no more events are available
+// or, if until is set, until the events pass it, so that the read ends on a busy database.
+type ChangeStreamReader struct {
+	// ctx bounds the reads of the stream.
+	ctx    context.Context
+	stream *mongo.ChangeStream
+	// collections selects the collections whose events are copied.
+	collections collectionFilter
+	// until is the cluster time of the last event read, none if it is zero.
+	until primitive.Timestamp
+	// resumeToken is the resume token after the last event read, from which the events left unread are read again.
+	resumeToken bson.Raw
+	done        bool
+	// pending holds the part of the current event not yet copied to the caller.
+	pending []byte
+}
+
+// Read copies the change events as concatenated BSON as per the buffer capacity.
+// It returns number of bytes (int) read and any error, if occurred.
+// EOF error is returned once the stream has caught up with the database or passed the end of the read.
+func (changeStreamReader *ChangeStreamReader) Read(buf []byte) (int, error) {
+
+	var numOfBytesRead int
+	for numOfBytesRead < len(buf) {
+		if len(changeStreamReader.pending) == 0 {
+			if changeStreamReader.done {
+				return numOfBytesRead, io.EOF
+			}
+			if !changeStreamReader.stream.TryNext(changeStreamReader.ctx) {
+				err := changeStreamReader.stream.Err()
+				if err == nil {
+					changeStreamReader.resumeToken = changeStreamReader.stream.ResumeToken()
+					changeStreamReader.done, err = true, io.EOF
+				}
+				return numOfBytesRead, err
+			}
+			t, i, ok := changeStreamReader.stream.Current.Lookup("clusterTime").TimestampOK()
+			if ok && !changeStreamReader.until.IsZero() && compareTimestamps(primitive.Timestamp{T: t, I: i}, changeStreamReader.until) > 0 {
+				// The event is left to the next read, resumed before it.
+				changeStreamReader.done = true
+				return numOfBytesRead, io.EOF
+			}
+			changeStreamReader.resumeToken = changeStreamReader.stream.ResumeToken()
+			if collection, ok := changeStreamReader.stream.Current.Lookup("ns", "coll").StringValueOK(); ok && !changeStreamReader.collections.selects(collection) {
+				continue
+			}
+			changeStreamReader.pending = changeStreamReader.stream.Current
+		}
+		copied := copy(buf[numOfBytesRead:], changeStreamReader.pending)
+		changeStreamReader.pending = changeStreamReader.pending[copied:]
+		numOfBytesRead += copied
+	}
+
+	return numOfBytesRead, nil
+}
+
+// UploadChanges uploads the changes made to the database since the previous back-up
+// as the changes.bson object of the back-up stored under the uploadFileName prefix.
+// The changes are read from a change stream resumed from the token of the previous back-up or, lacking one, from its oplog start.
+// It returns the manifest entry of the uploaded changes, the resume token of the next incremental back-up and any error, if occurred.
+func UploadChanges(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, mongoReader *MongoReader, previous *BackupManifest, uploadOptions UploadOptions) (CollectionManifest, json.RawMessage, error) {
+
+	streamOptions := options.ChangeStream()
+	switch {
+	case previous.ResumeToken != nil:
+		var token bson.D
+		if err := bson.UnmarshalExtJSON(previous.ResumeToken, true, &token); err != nil {
+			return CollectionManifest{}, nil, errorf(ErrUpload, "invalid resume token of the previous back-up: %w", err)
+		}
+		streamOptions.SetResumeAfter(token)
+	case previous.OplogStart != nil:
+		streamOptions.SetStartAtOperationTime(previous.OplogStart)
+	default:
+		return CollectionManifest{}, nil, errorf(ErrNoBackup, "the previous back-up records no change stream position, a full back-up is required first")
+	}
+
+	stream, err := mongoReader.database.Watch(ctx, mongo.Pipeline{}, streamOptions)
+	if err != nil {
+		return CollectionManifest{}, nil, errorf(ErrUpload, "could not resume the change stream of the previous back-up: %w", err)
+	}
+	defer func() { _ = stream.Close(ctx) }()
+	// The changes are read up to the latest operation as the stream opens, the later ones being left to the next back-up.
+	until, err := mongoReader.OplogTimestamp(ctx)
+	if err != nil {
+		return CollectionManifest{}, nil, errorf(ErrUpload, "could not read the time of the latest operation: %w", err)
+	}
+	reader := &ChangeStreamReader{ctx: ctx, stream: stream, collections: mongoReader.collections, until: until, resumeToken: stream.ResumeToken()}
+
+	entry := CollectionManifest{Name: "changes", Object: changesFileName + uploadOptions.Compression.Extension(), Compression: uploadOptions.Compression.Codec}
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + entry.Object
+	uploadOptions.Progress.report(Event{Kind: EventUploading, Bucket: configStorj.Bucket, Object: objectKey, Message: fmt.Sprintf("Uploading %s to %s...", objectKey, configStorj.Bucket)})
+	changes := &contextReader{ctx: ctx, reader: reader}
+	if err = uploadBSON(ctx, storage, configStorj.Bucket, objectKey, changes, make([]byte, 1048576), uploadOptions, &entry); err != nil {
+		return entry, nil, errorf(ErrUpload, "could not upload the changes: %w", err)
+	}
+
+	resumeToken, err := bson.MarshalExtJSON(reader.resumeToken, true, false)
+	return entry, resumeToken, newError(ErrUpload, err)
+}
+
+// latestBackupManifest returns the latest complete back-up stored under the databasePrefix of the bucket, along with its manifest.
+// The back-ups which failed or are still running are passed over. It returns a nil back-up if there is none.
+func latestBackupManifest(ctx context.Context, storage Storage, bucket string, databasePrefix string) (*BackupInfo, *BackupManifest, error) {
+
+	backups, err := ListBackups(ctx, storage, bucket, databasePrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return latestCompleteBackup(ctx, storage, backups)
+}
+
+// changeEvent holds the fields of a change stream event needed to apply it.
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	To struct {
+		Collection string `bson:"coll"`
+	} `bson:"to"`
+	DocumentKey       bson.Raw `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// ApplyChanges applies the change events of an incremental back-up of the given database read from reader.
+// Consecutive document changes of a collection are written in ordered batches of at most 1000 operations.
+// It returns the number of events applied and any error, if occurred.
+func (mongoWriter *MongoWriter) ApplyChanges(ctx context.Context, database string, reader io.Reader) (int, error) {
+
+	if mongoWriter.database != "" {
+		database = mongoWriter.database
+	}
+	targetDatabase := mongoWriter.client.Database(database)
+
+	var applied int
+	var batchCollection string
+	var batch []mongo.WriteModel
+	// Write the pending document changes of the current collection.
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := targetDatabase.Collection(batchCollection).BulkWrite(ctx, batch); err != nil {
+			return fmt.Errorf("failed to apply the changes of %s collection: %w", batchCollection, err)
+		}
+		batch = nil
+		return nil
+	}
+
+	documents := bufio.NewReader(reader)
+	for {
+		document, err := readDocument(documents, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+		var event changeEvent
+		if err = bson.Unmarshal(document, &event); err != nil {
+			return applied, fmt.Errorf("invalid change event: %w", err)
+		}
+
+		var model mongo.WriteModel
+		switch event.OperationType {
+		case "insert", "replace":
+			model = mongo.NewReplaceOneModel().SetFilter(event.DocumentKey).SetReplacement(event.FullDocument).SetUpsert(true)
+		case "update":
+			update := bson.D{}
+			if len(event.UpdateDescription.UpdatedFields) > 0 {
+				update = append(update, bson.E{Key: "$set", Value: event.UpdateDescription.UpdatedFields})
+			}
+			if len(event.UpdateDescription.RemovedFields) > 0 {
+				removed := bson.D{}
+				for _, field := range event.UpdateDescription.RemovedFields {
+					removed = append(removed, bson.E{Key: field, Value: ""})
+				}
+				update = append(update, bson.E{Key: "$unset", Value: removed})
+			}
+			if len(update) > 0 {
+				model = mongo.NewUpdateOneModel().SetFilter(event.DocumentKey).SetUpdate(update)
+			}
+		case "delete":
+			model = mongo.NewDeleteOneModel().SetFilter(event.DocumentKey)
+		}
+
+		if model != nil {
+			if batchCollection != event.Namespace.Collection || len(batch) == 1000 {
+				if err = flush(); err != nil {
+					return applied, err
+				}
+				batchCollection = event.Namespace.Collection
+			}
+			batch = append(batch, model)
+			applied++
+			continue
+		}
+
+		// Other events change the collections themselves and are applied in order with the document changes.
+		if err = flush(); err != nil {
+			return applied, err
+		}
+		switch event.OperationType {
+		case "drop":
+			err = targetDatabase.Collection(event.Namespace.Collection).Drop(ctx)
+		case "rename":
+			err = mongoWriter.client.Database("admin").RunCommand(ctx, bson.D{
+				{Key: "renameCollection", Value: database + "." + event.Namespace.Collection},
+				{Key: "to", Value: database + "." + event.To.Collection},
+				{Key: "dropTarget", Value: true},
+			}).Err()
+		case "dropDatabase":
+			err = targetDatabase.Drop(ctx)
+		case "invalidate":
+			err = errors.New("the change stream was invalidated")
+		}
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply %s change: %w", event.OperationType, err)
+		}
+		applied++
+	}
+
+	return applied, flush()
+}
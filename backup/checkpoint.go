@@ -0,0 +1,465 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// uploadPartSize is the size of each part of a multipart upload as uploaded, compressed and encrypted, after which its progress is recorded.
+// Every part but the last one is at least that size, above the minimum size of the parts of S3.
+const uploadPartSize = 67108864
+
+// Checkpoint records the progress of the back-ups being taken, so that a back-up interrupted by a failure
+// is resumed by running the store command again with the `resume` flag instead of starting over.
+// The data of encrypted back-ups which is not uploaded yet is recorded sealed with their data key, never in clear,
+// and the file is only readable by its owner.
+type Checkpoint struct {
+	file string
+	mu   sync.Mutex
+	// Backups maps the bucket and prefix of the database of each back-up in progress to its progress.
+	Backups map[string]*BackupCheckpoint `json:"backups"`
+}
+
+// BackupCheckpoint records the progress of a back-up.
+type BackupCheckpoint struct {
+	checkpoint *Checkpoint
+	key        string
+	// UploadFileName is the prefix of the back-up under the upload path.
+	UploadFileName string `json:"uploadFileName"`
+	// Manifest holds the start of the back-up, which is kept when it is resumed.
+	Manifest BackupManifest `json:"manifest"`
+	// Compression and Encryption are the codec and key IDs of the back-up, which must not change when it is resumed.
+	Compression string                           `json:"compression,omitempty"`
+	Encryption  string                           `json:"encryption,omitempty"`
+	Collections map[string]*CollectionCheckpoint `json:"collections"`
+}
+
+// CollectionCheckpoint records the progress of the multipart upload of a collection.
+type CollectionCheckpoint struct {
+	UploadID string `json:"uploadId"`
+	// Parts is the number of parts uploaded, the last of them ending with the document of LastID.
+	Parts int `json:"parts"`
+	// LastID is the _id of the last document uploaded in extended JSON, as {"_id": ...}.
+	LastID    json.RawMessage `json:"lastId,omitempty"`
+	Documents int64           `json:"documents"`
+	Size      int64           `json:"size"`
+	// Hash is the state of the checksum of the documents uploaded.
+	Hash []byte `json:"hash,omitempty"`
+	// Encryption is the state of the encryption stream at the end of the last part, if the back-up is encrypted.
+	Encryption *encryptState `json:"encryption,omitempty"`
+	// Uploaded is the manifest entry of the collection once its upload is committed.
+	Uploaded *CollectionManifest `json:"uploaded,omitempty"`
+}
+
+// LoadCheckpoint reads the checkpoint file, which is empty if the file does not exist.
+func LoadCheckpoint(fullFileName string) (*Checkpoint, error) {
+
+	checkpoint := &Checkpoint{file: fullFileName, Backups: make(map[string]*BackupCheckpoint)}
+	contents, err := ioutil.ReadFile(filepath.Clean(fullFileName))
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the checkpoint: %w", err)
+	}
+	if err = json.Unmarshal(contents, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", fullFileName, err)
+	}
+	for key, backup := range checkpoint.Backups {
+		backup.checkpoint, backup.key = checkpoint, key
+		if backup.Collections == nil {
+			backup.Collections = make(map[string]*CollectionCheckpoint)
+		}
+	}
+	return checkpoint, nil
+}
+
+// Backup returns the progress of the back-up of the database under the given bucket and prefix, if any.
+func (checkpoint *Checkpoint) Backup(key string) *BackupCheckpoint {
+
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+	return checkpoint.Backups[key]
+}
+
+// Start records the start of a back-up of the database under the given bucket and prefix, replacing any previous one.
+func (checkpoint *Checkpoint) Start(key string, uploadFileName string, manifest BackupManifest, uploadOptions UploadOptions) (*BackupCheckpoint, error) {
+
+	backup := &BackupCheckpoint{
+		checkpoint:     checkpoint,
+		key:            key,
+		UploadFileName: uploadFileName,
+		Manifest:       manifest,
+		Compression:    uploadOptions.Compression.Codec,
+		Collections:    make(map[string]*CollectionCheckpoint),
+	}
+	if uploadOptions.Encryption != nil {
+		backup.Encryption = uploadOptions.Encryption.metadata()[encryptionKeysMetadataKey]
+	}
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+	checkpoint.Backups[key] = backup
+	return backup, checkpoint.save()
+}
+
+// save writes the checkpoint file, replacing it at once so that it is never left half written,
+// and removes it once no back-up is in progress.
+func (checkpoint *Checkpoint) save() error {
+
+	if len(checkpoint.Backups) == 0 {
+		if err := os.Remove(checkpoint.file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove the checkpoint: %w", err)
+		}
+		return nil
+	}
+	contents, err := json.MarshalIndent(checkpoint, "", "\t")
+	if err != nil {
+		return err
+	}
+	temporary := checkpoint.file + ".tmp"
+	if err = ioutil.WriteFile(temporary, contents, 0600); err != nil {
+		return fmt.Errorf("could not write the checkpoint: %w", err)
+	}
+	if err = os.Rename(temporary, checkpoint.file); err != nil {
+		return fmt.Errorf("could not write the checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Matches returns an error if the back-up cannot be resumed with uploadOptions, its objects being encoded differently.
+func (backup *BackupCheckpoint) Matches(uploadOptions UploadOptions) error {
+
+	if backup.Compression != uploadOptions.Compression.Codec {
+		return fmt.Errorf("the interrupted back-up %s is compressed with %q, it must be resumed with the same compression", backup.UploadFileName, backup.Compression)
+	}
+	encryption := ""
+	if uploadOptions.Encryption != nil {
+		encryption = uploadOptions.Encryption.metadata()[encryptionKeysMetadataKey]
+	}
+	if backup.Encryption != encryption {
+		return fmt.Errorf("the interrupted back-up %s is encrypted for %q, it must be resumed with the same encryption", backup.UploadFileName, backup.Encryption)
+	}
+	return nil
+}
+
+// collection returns a copy of the progress of the upload of the collection.
+func (backup *BackupCheckpoint) collection(name string) CollectionCheckpoint {
+
+	backup.checkpoint.mu.Lock()
+	defer backup.checkpoint.mu.Unlock()
+	if progress, ok := backup.Collections[name]; ok {
+		return *progress
+	}
+	return CollectionCheckpoint{}
+}
+
+// record records the progress of the upload of the collection.
+func (backup *BackupCheckpoint) record(name string, progress CollectionCheckpoint) error {
+
+	backup.checkpoint.mu.Lock()
+	defer backup.checkpoint.mu.Unlock()
+	backup.Collections[name] = &progress
+	return backup.checkpoint.save()
+}
+
+// Finish removes the back-up from the checkpoint once it is complete.
+func (backup *BackupCheckpoint) Finish() error {
+
+	backup.checkpoint.mu.Lock()
+	defer backup.checkpoint.mu.Unlock()
+	delete(backup.checkpoint.Backups, backup.key)
+	return backup.checkpoint.save()
+}
+
+// Abort aborts the multipart uploads of the back-up which were not committed, before it is replaced by a new back-up.
+// Every upload is aborted even if some fail, the first error being returned.
+func (backup *BackupCheckpoint) Abort(ctx context.Context, storage Storage, configStorj ConfigStorj) error {
+
+	multipart, ok := storage.(MultipartStorage)
+	if !ok {
+		return errorf(ErrConfig, "the storage does not support multipart uploads")
+	}
+	var failure error
+	for name, progress := range backup.Collections {
+		if progress.UploadID == "" || progress.Uploaded != nil {
+			continue
+		}
+		objectKey := configStorj.UploadPath + backup.UploadFileName + "/" + name + ".bson" + compressionExtensions[backup.Compression]
+		if err := multipart.AbortUpload(ctx, configStorj.Bucket, objectKey, progress.UploadID); err != nil && failure == nil {
+			failure = fmt.Errorf("could not abort the interrupted upload of %s: %w", objectKey, err)
+		}
+	}
+	return failure
+}
+
+// uploadResumableCollection uploads the collection as uploadCollection does, through a multipart upload of its documents
+// in the order of their _id whose progress is recorded in the checkpoint after each part.
+// If a previous run was interrupted while uploading the collection, its upload is continued after the last document recorded,
+// unless the stream cannot be continued, such as for documents without _id, in which case the upload starts over.
+// A part committed but not recorded is uploaded again under the same number, replacing it.
+func uploadResumableCollection(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, opener ResumableOpener, collectionName string, exporter MetadataExporter, buf []byte, uploadOptions UploadOptions) (CollectionManifest, error) {
+
+	multipart, ok := storage.(MultipartStorage)
+	if !ok {
+		return CollectionManifest{}, errorf(ErrConfig, "the storage does not support multipart uploads")
+	}
+	backup := uploadOptions.checkpoint
+	progress := backup.collection(collectionName)
+	if progress.Uploaded != nil {
+		uploadOptions.Progress.infof("Collection %s was uploaded before the interruption.", collectionName)
+		return *progress.Uploaded, nil
+	}
+
+	uploadedCollection := CollectionManifest{Name: collectionName, Object: collectionName + ".bson" + uploadOptions.Compression.Extension(), Compression: uploadOptions.Compression.Codec}
+	var err error
+	if uploadedCollection.Metadata, err = uploadMetadata(ctx, storage, configStorj, uploadFileName, collectionName, exporter, buf, uploadOptions); err != nil {
+		return uploadedCollection, err
+	}
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + uploadedCollection.Object
+
+	// Restore the state of the interrupted upload, if any.
+	var after bson.RawValue
+	checksum := sha256.New()
+	var encryptor *encryptWriter
+	if progress.Parts > 0 {
+		if err = resumeProgress(progress, checksum, &after, &encryptor, uploadOptions); err != nil {
+			uploadOptions.Progress.infof("Restarting upload of %s collection: %s", collectionName, err)
+			_ = multipart.AbortUpload(ctx, configStorj.Bucket, objectKey, progress.UploadID)
+			progress, after, encryptor = CollectionCheckpoint{}, bson.RawValue{}, nil
+			checksum.Reset()
+		} else {
+			uploadOptions.Progress.infof("Resuming upload of %s collection after %d documents.", collectionName, progress.Documents)
+		}
+	}
+	if progress.UploadID == "" {
+		uploadID, err := multipart.BeginUpload(ctx, configStorj.Bucket, objectKey, objectMetadata(uploadOptions))
+		if err != nil {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+		}
+		progress.UploadID = uploadID
+		if err = backup.record(collectionName, progress); err != nil {
+			return uploadedCollection, err
+		}
+	}
+
+	reader, err := opener.OpenCollectionAfter(collectionName, after)
+	if err != nil {
+		return uploadedCollection, err
+	}
+	defer func() { _ = reader.Close() }()
+	documents := bufio.NewReader(&contextReader{ctx: ctx, reader: reader})
+
+	for last := false; !last; {
+		if last, err = uploadPart(ctx, multipart, configStorj.Bucket, objectKey, documents, buf, checksum, &encryptor, &progress, uploadOptions); err != nil {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+		}
+		if err = backup.record(collectionName, progress); err != nil {
+			return uploadedCollection, err
+		}
+	}
+
+	if progress.Parts == 0 {
+		// An empty collection leaves no part to assemble, its empty object being put on its own.
+		upload, err := multipart.Put(ctx, configStorj.Bucket, objectKey, objectMetadata(uploadOptions))
+		if err == nil {
+			_, err = upload.Commit()
+		}
+		if err != nil {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+		}
+	} else {
+		// Check that no part was left behind before assembling them.
+		parts, err := multipart.ListParts(ctx, configStorj.Bucket, objectKey, progress.UploadID)
+		if err != nil {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+		}
+		if len(parts) != progress.Parts {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %d parts uploaded instead of %d", collectionName, len(parts), progress.Parts)
+		}
+		if err = multipart.CommitUpload(ctx, configStorj.Bucket, objectKey, progress.UploadID, objectMetadata(uploadOptions)); err != nil {
+			return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+		}
+	}
+
+	uploadedCollection.Documents = progress.Documents
+	uploadedCollection.Size = progress.Size
+	uploadedCollection.SHA256 = hex.EncodeToString(checksum.Sum(nil))
+	progress.Uploaded = &uploadedCollection
+	if err = backup.record(collectionName, progress); err != nil {
+		return uploadedCollection, err
+	}
+	if progress.Parts == 0 {
+		// The upload left without part is discarded once the collection is recorded, so that an interruption can still resume it.
+		_ = multipart.AbortUpload(ctx, configStorj.Bucket, objectKey, progress.UploadID)
+	}
+	return uploadedCollection, nil
+}
+
+// resumeProgress restores the checksum, the position and the encryption stream of an interrupted upload.
+func resumeProgress(progress CollectionCheckpoint, checksum hash.Hash, after *bson.RawValue, encryptor **encryptWriter, uploadOptions UploadOptions) error {
+
+	if len(progress.LastID) == 0 {
+		return errors.New("the documents uploaded have no _id")
+	}
+	var lastID bson.Raw
+	if err := bson.UnmarshalExtJSON(progress.LastID, true, &lastID); err != nil {
+		return fmt.Errorf("invalid _id: %w", err)
+	}
+	*after = lastID.Lookup("_id")
+	if err := checksum.(encoding.BinaryUnmarshaler).UnmarshalBinary(progress.Hash); err != nil {
+		return fmt.Errorf("invalid checksum: %w", err)
+	}
+	if uploadOptions.Encryption != nil {
+		if progress.Encryption == nil {
+			return errors.New("no encryption state")
+		}
+		var err error
+		if *encryptor, err = uploadOptions.Encryption.resumeWriter(nil, *progress.Encryption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadPart uploads the next part of the collection, made of the documents read until the part uploaded reaches the part size,
+// compressed as a stream of its own then encrypted as part of the stream of the whole object, and records it in progress.
+// It returns whether the part is the last one, all the documents having been read.
+func uploadPart(ctx context.Context, storage MultipartStorage, bucket string, objectKey string, reader *bufio.Reader, buf []byte, checksum hash.Hash, encryptor **encryptWriter, progress *CollectionCheckpoint, uploadOptions UploadOptions) (bool, error) {
+
+	part, err := storage.PutPart(ctx, bucket, objectKey, progress.UploadID, progress.Parts+1)
+	if err != nil {
+		return false, err
+	}
+	sink := &sizeWriter{w: part}
+	var compressed io.Writer = sink
+	if uploadOptions.Encryption != nil {
+		if *encryptor == nil {
+			writer, err := uploadOptions.Encryption.NewWriter(sink)
+			if err != nil {
+				_ = part.Abort()
+				return false, err
+			}
+			*encryptor = writer.(*encryptWriter)
+		}
+		(*encryptor).w = sink
+		compressed = *encryptor
+	}
+	compressor, err := uploadOptions.Compression.NewWriter(nopWriteCloser{compressed})
+	if err != nil {
+		_ = part.Abort()
+		return false, err
+	}
+
+	last := false
+	var lastID bson.RawValue
+	var documents int
+	for sink.size < uploadPartSize {
+		document, err := readDocument(reader, buf)
+		if err == io.EOF {
+			last = true
+			break
+		}
+		if err == nil {
+			_, err = compressor.Write(document)
+		}
+		if err != nil {
+			_ = part.Abort()
+			return false, err
+		}
+		_, _ = checksum.Write(document)
+		documents++
+		progress.Documents++
+		lastID, _ = document.LookupErr("_id")
+	}
+	if err = compressor.Close(); err == nil && last && *encryptor != nil {
+		err = (*encryptor).Close()
+	}
+	if err != nil {
+		_ = part.Abort()
+		return false, err
+	}
+	if sink.size == 0 {
+		// Nothing is left of the collection, not even an empty stream.
+		_ = part.Abort()
+		return true, nil
+	}
+	if err = part.Commit(); err != nil {
+		return false, err
+	}
+
+	progress.Parts++
+	progress.Size += sink.size
+	if lastID.Type != 0 {
+		if progress.LastID, err = bson.MarshalExtJSON(bson.D{{Key: "_id", Value: lastID}}, true, false); err != nil {
+			return false, err
+		}
+	} else if documents > 0 {
+		// The last document has no _id to resume after.
+		progress.LastID = nil
+	}
+	if progress.Hash, err = checksum.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return false, err
+	}
+	if *encryptor != nil {
+		state, err := (*encryptor).state()
+		if err != nil {
+			return false, err
+		}
+		progress.Encryption = &state
+	}
+	return last, nil
+}
+
+// readDocument reads the next BSON document of the stream into buf, or a buffer of its own if it does not fit.
+// io.EOF is returned at the end of the stream.
+func readDocument(reader *bufio.Reader, buf []byte) (bson.Raw, error) {
+
+	header, err := reader.Peek(4)
+	if err == io.EOF && len(header) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated BSON document: %w", err)
+	}
+	length := int(int32(binary.LittleEndian.Uint32(header)))
+	if length < 5 {
+		return nil, fmt.Errorf("invalid BSON document length %d", length)
+	}
+	document := buf
+	if length > len(buf) {
+		document = make([]byte, length)
+	}
+	if _, err = io.ReadFull(reader, document[:length]); err != nil {
+		return nil, fmt.Errorf("truncated BSON document: %w", err)
+	}
+	return document[:length], nil
+}
+
+// sizeWriter counts the bytes written through it.
+type sizeWriter struct {
+	w    io.Writer
+	size int64
+}
+
+// Write writes p, counting its bytes.
+func (sizeWriter *sizeWriter) Write(p []byte) (int, error) {
+
+	n, err := sizeWriter.w.Write(p)
+	sizeWriter.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sortedCollections holds collections of documents sorted by their integer _id, opened as a ResumableOpener.
+type sortedCollections struct {
+	names     []string
+	documents map[string][]bson.Raw
+	next      int
+}
+
+func (collections *sortedCollections) NextCollection() (string, io.Reader, error) {
+	if collections.next == len(collections.names) {
+		return "", nil, io.EOF
+	}
+	name := collections.names[collections.next]
+	collections.next++
+	reader, err := collections.OpenCollection(name)
+	return name, reader, err
+}
+
+func (collections *sortedCollections) CollectionNames() []string {
+	return collections.names
+}
+
+func (collections *sortedCollections) OpenCollection(name string) (io.ReadCloser, error) {
+	return collections.OpenCollectionAfter(name, bson.RawValue{})
+}
+
+func (collections *sortedCollections) OpenCollectionAfter(name string, after bson.RawValue) (io.ReadCloser, error) {
+	var stream []byte
+	for _, document := range collections.documents[name] {
+		if after.Type == 0 || document.Lookup("_id").Int32() > after.Int32() {
+			stream = append(stream, document...)
+		}
+	}
+	return ioutil.NopCloser(bytes.NewReader(stream)), nil
+}
+
+// newSortedCollections returns the collections with the given numbers of documents, each padded to about size bytes.
+func newSortedCollections(t *testing.T, size int, counts map[string]int) *sortedCollections {
+
+	collections := &sortedCollections{documents: make(map[string][]bson.Raw)}
+	padding := string(bytes.Repeat([]byte("x"), size))
+	for name, count := range counts {
+		collections.names = append(collections.names, name)
+		for i := 0; i < count; i++ {
+			document, err := bson.Marshal(bson.D{{Key: "_id", Value: i}, {Key: "padding", Value: padding}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			collections.documents[name] = append(collections.documents[name], document)
+		}
+	}
+	return collections
+}
+
+// checkpointedUpload uploads the collections to a local storage in a temporary directory, removed by the returned function,
+// with a checkpoint recording their progress.
+func checkpointedUpload(t *testing.T, collections *sortedCollections, uploadOptions UploadOptions) (MultipartStorage, ConfigStorj, []CollectionManifest, func()) {
+
+	ctx := context.Background()
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() { _ = os.RemoveAll(directory) }
+	configStorj := ConfigStorj{Backend: BackendLocal, Directory: filepath.Join(directory, "storage"), Bucket: "bucket"}
+	storage, err := NewLocalStorage(configStorj.Directory)
+	if err == nil {
+		err = storage.EnsureBucket(ctx, configStorj.Bucket)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	checkpoint, err := LoadCheckpoint(filepath.Join(directory, "checkpoint.json"))
+	if err == nil {
+		uploadOptions.checkpoint, err = checkpoint.Start("bucket/db", "db/db2020-01-01_00_00_00", BackupManifest{Database: "db"}, uploadOptions)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	uploaded, err := Upload(ctx, storage, configStorj, "db/db2020-01-01_00_00_00", collections, uploadOptions)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return storage, configStorj, uploaded, cleanup
+}
+
+// checkUploaded checks the objects uploaded against the documents of the collections and their manifest entries.
+func checkUploaded(t *testing.T, storage Storage, configStorj ConfigStorj, collections *sortedCollections, uploaded []CollectionManifest, decryption *Decryption) {
+
+	if len(uploaded) != len(collections.names) {
+		t.Fatalf("uploaded %d collections instead of %d", len(uploaded), len(collections.names))
+	}
+	for _, entry := range uploaded {
+		var expected []byte
+		for _, document := range collections.documents[entry.Name] {
+			expected = append(expected, document...)
+		}
+		download, object, err := storage.Get(context.Background(), configStorj.Bucket, "db/db2020-01-01_00_00_00/"+entry.Object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeObject(download, object, decryption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents, err := ioutil.ReadAll(decoded)
+		_, _ = decoded.Close(), download.Close()
+		if err != nil || !bytes.Equal(documents, expected) {
+			t.Errorf("%s: downloaded %d bytes instead of %d: %v", entry.Name, len(documents), len(expected), err)
+		}
+		checksum := sha256.Sum256(expected)
+		if entry.Documents != int64(len(collections.documents[entry.Name])) || entry.SHA256 != hex.EncodeToString(checksum[:]) || entry.Size != object.Size {
+			t.Errorf("%s: recorded %+v", entry.Name, entry)
+		}
+	}
+}
+
+func TestCheckpointedUploadSequential(t *testing.T) {
+
+	// Without Parallel, the collections are uploaded one at a time.
+	collections := newSortedCollections(t, 100, map[string]int{"items": 1000})
+	storage, configStorj, uploaded, cleanup := checkpointedUpload(t, collections, UploadOptions{Parallel: 0})
+	defer cleanup()
+	checkUploaded(t, storage, configStorj, collections, uploaded, nil)
+}
+
+func TestCheckpointedUploadEmptyCollection(t *testing.T) {
+
+	// An empty collection is uploaded as an empty object, the multipart upload it began being discarded.
+	collections := newSortedCollections(t, 100, map[string]int{"empty": 0})
+	storage, configStorj, uploaded, cleanup := checkpointedUpload(t, collections, UploadOptions{})
+	defer cleanup()
+	checkUploaded(t, storage, configStorj, collections, uploaded, nil)
+	uploads, err := ioutil.ReadDir(filepath.Join(configStorj.Directory, configStorj.Bucket, localUploadsDirectory))
+	if err != nil || len(uploads) != 0 {
+		t.Errorf("left %d uploads: %v", len(uploads), err)
+	}
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionQuery restricts the documents of a collection which are backed up, for partial back-ups of large collections.
+type CollectionQuery struct {
+	// Filter is a query filter in extended JSON, such as {"level": {"$ne": "debug"}}.
+	Filter json.RawMessage `json:"filter"`
+	// Projection is a projection in extended JSON, such as {"payload": 0}.
+	Projection json.RawMessage `json:"projection"`
+	// DateField and MaxAgeDays only back up the documents whose DateField is at most MaxAgeDays days old.
+	DateField  string `json:"dateField"`
+	MaxAgeDays int    `json:"maxAgeDays"`
+}
+
+// parsedCollectionQuery is a CollectionQuery with its filter and projection parsed.
+type parsedCollectionQuery struct {
+	filter     bson.D
+	projection bson.D
+	dateField  string
+	maxAgeDays int
+}
+
+// parse parses the filter and projection of the query.
+func (query CollectionQuery) parse() (parsedCollectionQuery, error) {
+
+	parsed := parsedCollectionQuery{dateField: query.DateField, maxAgeDays: query.MaxAgeDays}
+	if len(query.Filter) > 0 {
+		if err := bson.UnmarshalExtJSON(query.Filter, false, &parsed.filter); err != nil {
+			return parsed, fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+	if len(query.Projection) > 0 {
+		if err := bson.UnmarshalExtJSON(query.Projection, false, &parsed.projection); err != nil {
+			return parsed, fmt.Errorf("invalid projection: %w", err)
+		}
+	}
+	if (query.DateField == "") != (query.MaxAgeDays == 0) || query.MaxAgeDays < 0 {
+		return parsed, fmt.Errorf("dateField and a positive maxAgeDays must be set together")
+	}
+	return parsed, nil
+}
+
+// find returns the filter and the options of the query of the collection's documents, as of now.
+func (query parsedCollectionQuery) find(now time.Time) (bson.D, *options.FindOptions) {
+
+	filter := query.filter
+	if query.dateField != "" {
+		recent := bson.D{{Key: query.dateField, Value: bson.D{{Key: "$gte", Value: now.AddDate(0, 0, -query.maxAgeDays)}}}}
+		if len(filter) == 0 {
+			filter = recent
+		} else {
+			filter = bson.D{{Key: "$and", Value: bson.A{filter, recent}}}
+		}
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	findOptions := options.Find()
+	if len(query.projection) > 0 {
+		findOptions.SetProjection(query.projection)
+	}
+	return filter, findOptions
+}
+
+// collectionPattern matches collection names against a glob, such as audit_*, or a regular expression enclosed in slashes, such as /^audit_/.
+type collectionPattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+// newCollectionPattern parses a glob or a regular expression enclosed in slashes.
+func newCollectionPattern(pattern string) (collectionPattern, error) {
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return collectionPattern{}, fmt.Errorf("invalid collection pattern %q: %w", pattern, err)
+		}
+		return collectionPattern{regexp: expression}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return collectionPattern{}, fmt.Errorf("invalid collection pattern %q: %w", pattern, err)
+	}
+	return collectionPattern{glob: pattern}, nil
+}
+
+// matches reports whether the collection name matches the pattern.
+func (pattern collectionPattern) matches(name string) bool {
+
+	if pattern.regexp != nil {
+		return pattern.regexp.MatchString(name)
+	}
+	matched, _ := path.Match(pattern.glob, name)
+	return matched
+}
+
+// collectionFilter selects the collections of a database to back up.
+type collectionFilter struct {
+	include, exclude []collectionPattern
+}
+
+// newCollectionFilter parses the include and exclude patterns of the collections.
+func newCollectionFilter(include []string, exclude []string) (collectionFilter, error) {
+
+	var filter collectionFilter
+	for _, pattern := range include {
+		parsed, err := newCollectionPattern(pattern)
+		if err != nil {
+			return filter, err
+		}
+		filter.include = append(filter.include, parsed)
+	}
+	for _, pattern := range exclude {
+		parsed, err := newCollectionPattern(pattern)
+		if err != nil {
+			return filter, err
+		}
+		filter.exclude = append(filter.exclude, parsed)
+	}
+	return filter, nil
+}
+
+// selects reports whether the collection matches an include pattern, if any, and no exclude pattern.
+func (filter collectionFilter) selects(name string) bool {
+
+	included := len(filter.include) == 0
+	for _, pattern := range filter.include {
+		if pattern.matches(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range filter.exclude {
+		if pattern.matches(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateCollections checks the collection patterns and queries of the MongoDB configuration.
+func (configMongoDB ConfigMongoDB) ValidateCollections() error {
+
+	_, _, err := configMongoDB.collectionSelection()
+	return newError(ErrConfig, err)
+}
+
+// collectionSelection parses the collection patterns and queries of the MongoDB configuration.
+func (configMongoDB ConfigMongoDB) collectionSelection() (collectionFilter, map[string]parsedCollectionQuery, error) {
+
+	filter, err := newCollectionFilter(configMongoDB.IncludeCollections, configMongoDB.ExcludeCollections)
+	if err != nil {
+		return filter, nil, err
+	}
+	queries := make(map[string]parsedCollectionQuery, len(configMongoDB.Collections))
+	for name, query := range configMongoDB.Collections {
+		if queries[name], err = query.parse(); err != nil {
+			return filter, nil, fmt.Errorf("query of %s collection: %w", name, err)
+		}
+	}
+	return filter, queries, nil
+}
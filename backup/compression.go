@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionMetadataKey is the custom metadata key recording the codec of a compressed object.
+const compressionMetadataKey = "compression"
+
+// compressionExtensions maps each supported codec to the extension appended to the name of its objects.
+var compressionExtensions = map[string]string{
+	"gzip":   ".gz",
+	"zstd":   ".zst",
+	"snappy": ".sz",
+}
+
+// Compression describes how the back-up objects are compressed.
+type Compression struct {
+	// Codec is gzip, zstd or snappy, or empty to store the objects uncompressed.
+	Codec string
+	// Level is the codec-specific compression level, or 0 for the codec's default.
+	Level int
+}
+
+// Validate checks that the codec is supported along with its level.
+func (compression Compression) Validate() error {
+
+	switch compression.Codec {
+	case "":
+		return nil
+	case "gzip":
+		if compression.Level < gzip.HuffmanOnly || compression.Level > gzip.BestCompression {
+			return errorf(ErrConfig, "invalid gzip compression level %d, expected -2 to 9", compression.Level)
+		}
+	case "zstd":
+		if compression.Level < 0 || compression.Level > 22 {
+			return errorf(ErrConfig, "invalid zstd compression level %d, expected 1 to 22", compression.Level)
+		}
+	case "snappy":
+		if compression.Level != 0 {
+			return errorf(ErrConfig, "snappy has no compression level")
+		}
+	default:
+		return errorf(ErrConfig, "unsupported compression %q, expected gzip, zstd or snappy", compression.Codec)
+	}
+	return nil
+}
+
+// Extension returns the extension appended to the name of the compressed objects.
+func (compression Compression) Extension() string {
+
+	return compressionExtensions[compression.Codec]
+}
+
+// NewWriter returns a writer compressing to w, which must be closed to flush the compressed stream.
+func (compression Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+
+	switch compression.Codec {
+	case "gzip":
+		level := compression.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		var options []zstd.EOption
+		if compression.Level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compression.Level)))
+		}
+		return zstd.NewWriter(w, options...)
+	case "snappy":
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// nopWriteCloser adds a no-op Close method to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+
+	return nil
+}
+
+// decompressReader returns a reader decompressing r with the given codec, as recorded in the object's custom metadata.
+func decompressReader(r io.Reader, codec string) (io.ReadCloser, error) {
+
+	switch codec {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case "snappy":
+		return ioutil.NopCloser(snappy.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", codec)
+}
+
+// trimCompressionExtension returns the object name without the extension of a supported codec.
+func trimCompressionExtension(objectName string) string {
+
+	for _, extension := range compressionExtensions {
+		if strings.HasSuffix(objectName, extension) {
+			return strings.TrimSuffix(objectName, extension)
+		}
+	}
+	return objectName
+}
@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+
+	// Documents compressing well, followed by random bytes which do not.
+	data := bytes.Repeat([]byte(`{"_id": 1, "name": "document"}`), 10000)
+	random := make([]byte, 100000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, random...)
+
+	tests := []struct {
+		compression Compression
+		extension   string
+	}{
+		{Compression{}, ""},
+		{Compression{Codec: "gzip"}, ".gz"},
+		{Compression{Codec: "gzip", Level: 1}, ".gz"},
+		{Compression{Codec: "gzip", Level: 9}, ".gz"},
+		{Compression{Codec: "gzip", Level: -2}, ".gz"},
+		{Compression{Codec: "zstd"}, ".zst"},
+		{Compression{Codec: "zstd", Level: 1}, ".zst"},
+		{Compression{Codec: "zstd", Level: 22}, ".zst"},
+		{Compression{Codec: "snappy"}, ".sz"},
+	}
+
+	for _, test := range tests {
+		if err := test.compression.Validate(); err != nil {
+			t.Errorf("%+v: %v", test.compression, err)
+			continue
+		}
+		if extension := test.compression.Extension(); extension != test.extension {
+			t.Errorf("%+v: extension %q instead of %q", test.compression, extension, test.extension)
+		}
+		var compressed bytes.Buffer
+		writer, err := test.compression.NewWriter(&compressed)
+		if err != nil {
+			t.Errorf("%+v: %v", test.compression, err)
+			continue
+		}
+		// The data is written in pieces, as uploads stream it.
+		for offset := 0; offset < len(data) && err == nil; offset += 4096 {
+			end := offset + 4096
+			if end > len(data) {
+				end = len(data)
+			}
+			_, err = writer.Write(data[offset:end])
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test.compression, err)
+			continue
+		}
+		if test.compression.Codec != "" && compressed.Len() >= len(data) {
+			t.Errorf("%+v: compressed %d bytes to %d", test.compression, len(data), compressed.Len())
+		}
+
+		reader, err := decompressReader(&compressed, test.compression.Codec)
+		if err != nil {
+			t.Errorf("%+v: %v", test.compression, err)
+			continue
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Errorf("%+v: decompressed %d bytes instead of %d: %v", test.compression, len(decompressed), len(data), err)
+		}
+	}
+}
+
+func TestCompressionValidate(t *testing.T) {
+
+	tests := []struct {
+		compression Compression
+		valid       bool
+	}{
+		{Compression{Codec: "gzip", Level: 10}, false},
+		{Compression{Codec: "gzip", Level: -3}, false},
+		{Compression{Codec: "zstd", Level: 23}, false},
+		{Compression{Codec: "snappy", Level: 1}, false},
+		{Compression{Codec: "lz4"}, false},
+		{Compression{Codec: "zstd", Level: 3}, true},
+	}
+
+	for _, test := range tests {
+		err := test.compression.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%+v: %v", test.compression, err)
+		}
+		if !test.valid && !errors.Is(err, ErrConfig) {
+			t.Errorf("%+v: accepted as %v", test.compression, err)
+		}
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+
+	for _, codec := range []string{"gzip", "zstd", "snappy"} {
+		var compressed bytes.Buffer
+		writer, err := Compression{Codec: codec}.NewWriter(&compressed)
+		if err == nil {
+			_, err = writer.Write(bytes.Repeat([]byte("document"), 10000))
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The stream is cut in its middle.
+		reader, err := decompressReader(bytes.NewReader(compressed.Bytes()[:compressed.Len()/2]), codec)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+			_ = reader.Close()
+		}
+		if err == nil {
+			t.Errorf("%s: decompressed a truncated stream", codec)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// authMechanismAliases maps the usual names of the authentication mechanisms to their MongoDB names.
+var authMechanismAliases = map[string]string{"X509": "MONGODB-X509", "X.509": "MONGODB-X509", "LDAP": "PLAIN"}
+
+// Authentication mechanisms whose users are defined outside of MongoDB, in the $external database.
+var externalAuthMechanisms = map[string]bool{"MONGODB-X509": true, "PLAIN": true, "GSSAPI": true}
+
+// clientOptions returns the options of a client connecting to the MongoDB instance, built from the connection string,
+// if any, and the structured fields of the configuration, which take precedence.
+func (configMongoDB ConfigMongoDB) clientOptions() (*options.ClientOptions, error) {
+
+	clientOptions := options.Client()
+	if configMongoDB.URI != "" {
+		clientOptions.ApplyURI(configMongoDB.URI)
+		if err := clientOptions.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid MongoDB connection string: %w", err)
+		}
+	}
+	if hosts := configMongoDB.configuredHosts(); len(hosts) > 0 {
+		clientOptions.SetHosts(hosts)
+	} else if configMongoDB.URI == "" {
+		return nil, errors.New("no MongoDB host configured, set uri, hosts or hostname")
+	}
+	if configMongoDB.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(configMongoDB.ReplicaSet)
+	}
+
+	if configMongoDB.Username != "" || configMongoDB.AuthMechanism != "" || configMongoDB.AuthSource != "" {
+		// Complete the credential of the connection string, if any.
+		var credential options.Credential
+		if clientOptions.Auth != nil {
+			credential = *clientOptions.Auth
+		}
+		if configMongoDB.Username != "" {
+			credential.Username, credential.Password = configMongoDB.Username, configMongoDB.Password
+		}
+		if configMongoDB.AuthMechanism != "" {
+			credential.AuthMechanism = strings.ToUpper(configMongoDB.AuthMechanism)
+			if alias, ok := authMechanismAliases[credential.AuthMechanism]; ok {
+				credential.AuthMechanism = alias
+			}
+		}
+		if configMongoDB.AuthSource != "" {
+			credential.AuthSource = configMongoDB.AuthSource
+		} else if externalAuthMechanisms[credential.AuthMechanism] {
+			credential.AuthSource = "$external"
+		} else if credential.AuthSource == "" {
+			credential.AuthSource = configMongoDB.Database
+		}
+		clientOptions.SetAuth(credential)
+	}
+
+	if configMongoDB.TLS || configMongoDB.TLSCAFile != "" || configMongoDB.TLSCertificateKeyFile != "" || configMongoDB.TLSInsecure {
+		tlsConfig, err := configMongoDB.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if configMongoDB.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(configMongoDB.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read preference %q: %w", configMongoDB.ReadPreference, err)
+		}
+		readPreference, err := readpref.New(mode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read preference %q: %w", configMongoDB.ReadPreference, err)
+		}
+		clientOptions.SetReadPreference(readPreference)
+	}
+
+	timeouts := []struct {
+		name  string
+		value string
+		set   func(time.Duration) *options.ClientOptions
+	}{
+		{"connectTimeout", configMongoDB.ConnectTimeout, clientOptions.SetConnectTimeout},
+		{"serverSelectionTimeout", configMongoDB.ServerSelectionTimeout, clientOptions.SetServerSelectionTimeout},
+		{"socketTimeout", configMongoDB.SocketTimeout, clientOptions.SetSocketTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", timeout.name, err)
+		}
+		timeout.set(duration)
+	}
+	return clientOptions, nil
+}
+
+// tlsConfig returns the TLS configuration of the connection, trusting the CA certificates of the CA file, if any,
+// and presenting the client certificate of the certificate key file, if any.
+func (configMongoDB ConfigMongoDB) tlsConfig() (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: configMongoDB.TLSInsecure}
+	if configMongoDB.TLSCAFile != "" {
+		certificates, err := ioutil.ReadFile(filepath.Clean(configMongoDB.TLSCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("could not read the TLS CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(certificates) {
+			return nil, fmt.Errorf("no PEM certificate in the TLS CA file %s", configMongoDB.TLSCAFile)
+		}
+	}
+	if configMongoDB.TLSCertificateKeyFile != "" {
+		// The file holds both the client certificate and its private key, as for mongod and the mongo shell.
+		certificate, err := tls.LoadX509KeyPair(configMongoDB.TLSCertificateKeyFile, configMongoDB.TLSCertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the TLS certificate key file: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// configuredHosts returns the host:port addresses of the structured fields of the configuration.
+func (configMongoDB ConfigMongoDB) configuredHosts() []string {
+
+	if len(configMongoDB.Hosts) > 0 {
+		return configMongoDB.Hosts
+	}
+	if configMongoDB.Hostname == "" {
+		return nil
+	}
+	port := configMongoDB.Portnumber
+	if port == "" {
+		port = "27017"
+	}
+	return []string{net.JoinHostPort(configMongoDB.Hostname, port)}
+}
+
+// Host describes the MongoDB instance for the back-up manifest, without credentials.
+func (configMongoDB ConfigMongoDB) Host() string {
+
+	if hosts := configMongoDB.configuredHosts(); len(hosts) > 0 {
+		return strings.Join(hosts, ",")
+	}
+	if connString, err := connstring.Parse(configMongoDB.URI); err == nil {
+		return strings.Join(connString.Hosts, ",")
+	}
+	return ""
+}
+
+// URIDatabase returns the database of the connection string, if any.
+func (configMongoDB ConfigMongoDB) URIDatabase() string {
+
+	if configMongoDB.URI == "" {
+		return ""
+	}
+	connString, err := connstring.Parse(configMongoDB.URI)
+	if err != nil {
+		return ""
+	}
+	return connString.Database
+}
@@ -0,0 +1,30 @@
+package backup
+
+import "testing"
+
+func TestClientOptionsTLS(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		config   ConfigMongoDB
+		tls      bool
+		insecure bool
+	}{
+		{"no TLS", ConfigMongoDB{Hostname: "localhost"}, false, false},
+		{"TLS", ConfigMongoDB{Hostname: "localhost", TLS: true}, true, false},
+		{"insecure TLS", ConfigMongoDB{Hostname: "localhost", TLS: true, TLSInsecure: true}, true, true},
+		{"insecure alone", ConfigMongoDB{Hostname: "localhost", TLSInsecure: true}, true, true},
+	}
+
+	for _, test := range tests {
+		clientOptions, err := test.config.clientOptions()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		tlsConfig := clientOptions.TLSConfig
+		if (tlsConfig != nil) != test.tls || tlsConfig != nil && tlsConfig.InsecureSkipVerify != test.insecure {
+			t.Errorf("%s: TLS configured as %+v", test.name, tlsConfig)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// CopyOptions defines how back-ups are copied.
+type CopyOptions struct {
+	// Decryption decrypts the objects encrypted with keys of our own, to verify their checksums.
+	// The checksums of encrypted objects are not verified without it, only their sizes.
+	Decryption *Decryption
+	// Force copies back-ups whose manifest reports them as incomplete.
+	Force bool
+	// Progress receives the events of the copy, if set.
+	Progress Progress
+}
+
+// CopyStats counts what a copy transferred.
+type CopyStats struct {
+	Backups int
+	// Copied and Skipped are the numbers of objects copied and already present.
+	Copied  int
+	Skipped int
+	// Bytes is the total size of the objects copied.
+	Bytes int64
+}
+
+// Copy copies the back-up at backupPath, in the format bucket/uploadPath/db/dbYYYY-MM-DD_HH_MM_SS,
+// or the latest back-up of the database at backupPath, in the format bucket/uploadPath/db, if latest is set,
+// from the source storage to the bucket and under the upload path of destinationConfig in the destination storage.
+// The objects are streamed from one storage to the other, without touching the local disk, and checked against
+// the manifest of the back-up. Objects already present with the same size and checksum are skipped, so that an interrupted copy
+// can be run again, and the back-ups an incremental back-up depends on are copied along with it.
+func Copy(ctx context.Context, source Storage, backupPath string, latest bool, destination Storage, destinationConfig ConfigStorj, copyOptions CopyOptions) (CopyStats, error) {
+
+	bucket, prefix, err := resolveBackup(ctx, source, backupPath, latest)
+	if err != nil {
+		return CopyStats{}, err
+	}
+	copier := newCopier(source, destination, destinationConfig, copyOptions)
+	err = copier.copyBackup(ctx, bucket, prefix)
+	return copier.stats, err
+}
+
+// CopyMatching copies the back-ups of the databases backed up at backupPath, in the format bucket or bucket/uploadPath,
+// whose name matches the pattern, as Copy does. Every back-up of each database is copied, or only the latest one if latest is set.
+func CopyMatching(ctx context.Context, source Storage, matchPattern string, backupPath string, latest bool, destination Storage, destinationConfig ConfigStorj, copyOptions CopyOptions) (CopyStats, error) {
+
+	matching, err := matchDatabases(ctx, source, matchPattern, backupPath)
+	if err != nil {
+		return CopyStats{}, err
+	}
+	copier := newCopier(source, destination, destinationConfig, copyOptions)
+	for _, databasePath := range matching {
+		copyOptions.Progress.infof("Matching database:  %s", databasePath)
+		keys := strings.SplitN(databasePath, "/", 2)
+		if latest {
+			prefix, err := findLatestBackup(ctx, source, strings.TrimSuffix(databasePath, "/"))
+			if errors.Is(err, ErrNoBackup) {
+				continue
+			}
+			if err != nil {
+				return copier.stats, err
+			}
+			if err = copier.copyBackup(ctx, keys[0], prefix); err != nil {
+				return copier.stats, err
+			}
+			continue
+		}
+		backups, err := ListBackups(ctx, source, keys[0], keys[1])
+		if err != nil {
+			return copier.stats, err
+		}
+		for _, backup := range backups {
+			if err = copier.copyBackup(ctx, keys[0], backup.Prefix); err != nil {
+				return copier.stats, err
+			}
+		}
+	}
+	return copier.stats, nil
+}
+
+// copier copies back-ups from a storage to another one.
+type copier struct {
+	source            Storage
+	destination       Storage
+	destinationConfig ConfigStorj
+	copyOptions       CopyOptions
+	stats             CopyStats
+	// copied holds the prefixes of the back-ups already copied, so that a back-up several incremental ones depend on is copied once.
+	copied map[string]bool
+	buf    []byte
+}
+
+// newCopier returns the copier of back-ups from the source to the bucket and under the upload path of destinationConfig in the destination.
+func newCopier(source Storage, destination Storage, destinationConfig ConfigStorj, copyOptions CopyOptions) *copier {
+
+	return &copier{source: source, destination: destination, destinationConfig: destinationConfig, copyOptions: copyOptions,
+		copied: make(map[string]bool), buf: make([]byte, collectionBufferSize)}
+}
+
+// copyBackup copies the back-up stored under prefix of the bucket to the destination, under the same database and name,
+// after the back-up it depends on, if incremental. Its manifest is copied last, so that an interrupted copy is incomplete.
+func (copier *copier) copyBackup(ctx context.Context, bucket string, prefix string) error {
+
+	if copier.copied[bucket+"/"+prefix] {
+		return nil
+	}
+	source, destinationConfig, copyOptions := copier.source, copier.destinationConfig, copier.copyOptions
+	backupName := path.Base(strings.TrimSuffix(prefix, "/"))
+	database := path.Base(path.Dir(strings.TrimSuffix(prefix, "/")))
+	uploadFileName := database + "/" + backupName
+	destinationPrefix := destinationConfig.UploadPath + uploadFileName + "/"
+
+	// Collect the objects of the back-up and check them against its manifest.
+	listed, err := source.List(ctx, bucket, prefix, false)
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	var objects []*ObjectInfo
+	var manifestObject *ObjectInfo
+	for _, item := range listed {
+		switch {
+		case item.IsPrefix:
+		case path.Base(item.Key) == manifestFileName:
+			manifestObject = item
+		default:
+			objects = append(objects, item)
+		}
+	}
+	if len(objects) == 0 {
+		return errorf(ErrNoBackup, "nothing to copy at %s/%s", bucket, prefix)
+	}
+	manifest, err := checkBackup(ctx, source, bucket, prefix, listed, copyOptions.Force, copyOptions.Progress)
+	if err != nil {
+		return err
+	}
+
+	// Copy the chain of an incremental back-up first, the parent being rebased on the upload path of the destination.
+	parentRebased := false
+	if manifest != nil && manifest.Parent != "" {
+		if err = copier.copyBackup(ctx, bucket, manifest.Parent); err != nil {
+			return err
+		}
+		parentName := path.Base(strings.TrimSuffix(manifest.Parent, "/"))
+		if rebased := destinationConfig.UploadPath + database + "/" + parentName + "/"; rebased != manifest.Parent {
+			manifest.Parent, parentRebased = rebased, true
+		}
+	}
+
+	copyOptions.Progress.infof("Copying %s/%s to %s/%s...", bucket, prefix, destinationConfig.Bucket, destinationPrefix)
+	entries := make(map[string]CollectionManifest)
+	if manifest != nil {
+		for _, entry := range manifest.Collections {
+			entries[entry.Object] = entry
+		}
+		for _, entry := range []*CollectionManifest{manifest.Oplog, manifest.Changes} {
+			if entry != nil {
+				entries[entry.Object] = *entry
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	for _, object := range objects {
+		var entry *CollectionManifest
+		if collection, ok := entries[path.Base(object.Key)]; ok {
+			entry = &collection
+		}
+		destinationKey := destinationPrefix + path.Base(object.Key)
+		if err = copier.copyObject(ctx, bucket, object, destinationKey, entry); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case parentRebased:
+		// The manifest is rewritten, its parent being under another upload path, unless already copied.
+		_, err = copier.destination.Stat(ctx, destinationConfig.Bucket, destinationPrefix+manifestFileName)
+		if err == nil {
+			copier.stats.Skipped++
+			break
+		}
+		if !errors.Is(err, ErrObjectNotFound) {
+			return newError(ErrUpload, err)
+		}
+		if err = UploadManifest(ctx, copier.destination, destinationConfig, uploadFileName, *manifest, copyOptions.Progress); err != nil {
+			return err
+		}
+		copier.stats.Copied++
+	case manifestObject != nil:
+		if err = copier.copyObject(ctx, bucket, manifestObject, destinationPrefix+manifestFileName, nil); err != nil {
+			return err
+		}
+	}
+	copier.copied[bucket+"/"+prefix] = true
+	copier.stats.Backups++
+	copyOptions.Progress.infof("Copied %s/%s.", bucket, prefix)
+	return nil
+}
+
+// copyObject streams the object from the source to the destination key, along with its custom metadata,
+// unless an object of the same size, holding the documents of its manifest entry, if set, is already stored under that key.
+// The documents of the object are checked against its manifest entry, if set, before the copy is committed.
+func (copier *copier) copyObject(ctx context.Context, bucket string, object *ObjectInfo, destinationKey string, entry *CollectionManifest) error {
+
+	source, destination, destinationBucket := copier.source, copier.destination, copier.destinationConfig.Bucket
+	copyOptions, stats := copier.copyOptions, &copier.stats
+	existing, err := destination.Stat(ctx, destinationBucket, destinationKey)
+	if err == nil && existing.Size == object.Size {
+		if copier.matchesEntry(ctx, existing, destinationKey, entry) {
+			stats.Skipped++
+			copyOptions.Progress.report(Event{Kind: EventSkipped, Bucket: destinationBucket, Object: destinationKey, Total: object.Size,
+				Message: fmt.Sprintf("Skipped %s, already present.", destinationKey)})
+			return nil
+		}
+		copyOptions.Progress.warnf("%s/%s does not match the manifest, copying it again.", destinationBucket, destinationKey)
+	}
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return newError(ErrUpload, err)
+	}
+
+	download, info, err := source.Get(ctx, bucket, object.Key)
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	defer func() { _ = download.Close() }()
+	if entry != nil && info.Metadata[encryptionMetadataKey] != "" && copyOptions.Decryption == nil {
+		copyOptions.Progress.warnf("the checksum of %s is not verified without the key decrypting it.", object.Key)
+		entry = nil
+	}
+
+	upload, err := destination.Put(ctx, destinationBucket, destinationKey, info.Metadata)
+	if err != nil {
+		return newError(ErrUpload, err)
+	}
+	var reader io.Reader = &contextReader{ctx: ctx, reader: download}
+	var checked chan error
+	var documents *io.PipeWriter
+	if entry != nil {
+		// Decode and check the documents as they are streamed, the copy being committed only if they match.
+		var pipeReader *io.PipeReader
+		pipeReader, documents = io.Pipe()
+		reader = io.TeeReader(reader, documents)
+		checked = make(chan error, 1)
+		go func() { checked <- checkEntry(pipeReader, info, *entry, copyOptions.Decryption) }()
+	}
+
+	_, err = io.CopyBuffer(upload, reader, copier.buf)
+	if documents != nil {
+		_ = documents.CloseWithError(err)
+		if checkErr := <-checked; err == nil && checkErr != nil {
+			err = errorf(ErrIncomplete, "%s does not match the manifest: %w", object.Key, checkErr)
+		}
+	}
+	if err != nil {
+		_ = upload.Abort()
+		copyOptions.Progress.report(Event{Kind: EventFailed, Bucket: bucket, Object: object.Key, Err: err, Message: fmt.Sprintf("Failed copy of %s: %s", object.Key, err)})
+		return newError(ErrUpload, err)
+	}
+	size, err := upload.Commit()
+	if err != nil {
+		return newError(ErrUpload, err)
+	}
+	if size != info.Size {
+		return errorf(ErrUpload, "%s was copied with %d bytes instead of %d", object.Key, size, info.Size)
+	}
+	stats.Copied++
+	stats.Bytes += size
+	copyOptions.Progress.report(Event{Kind: EventCopied, Bucket: destinationBucket, Object: destinationKey, Bytes: size, Total: size,
+		Message: fmt.Sprintf("Copied %s to %s/%s (%d bytes).", object.Key, destinationBucket, destinationKey, size)})
+	return nil
+}
+
+// matchesEntry reports whether the object stored under the destination key holds the documents of the manifest entry.
+// Objects without an entry, or encrypted without the key decrypting them, cannot be checked and are taken as matching.
+func (copier *copier) matchesEntry(ctx context.Context, existing *ObjectInfo, destinationKey string, entry *CollectionManifest) bool {
+
+	if entry == nil || existing.Metadata[encryptionMetadataKey] != "" && copier.copyOptions.Decryption == nil {
+		return true
+	}
+	download, info, err := copier.destination.Get(ctx, copier.destinationConfig.Bucket, destinationKey)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = download.Close() }()
+	return checkEntry(&contextReader{ctx: ctx, reader: download}, info, *entry, copier.copyOptions.Decryption) == nil
+}
+
+// checkEntry decodes the object read from reader and checks the checksum and number of its documents against the manifest entry.
+// The reader is read to its end whatever the outcome.
+func checkEntry(reader io.Reader, object *ObjectInfo, entry CollectionManifest, decryption *Decryption) error {
+
+	defer func() { _, _ = io.Copy(ioutil.Discard, reader) }()
+	decoded, err := decodeObject(reader, object, decryption)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = decoded.Close() }()
+
+	hash := sha256.New()
+	counter := &documentCounter{}
+	if _, err = io.Copy(io.MultiWriter(hash, counter), decoded); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != entry.SHA256 {
+		return fmt.Errorf("checksum %s instead of %s", checksum, entry.SHA256)
+	}
+	if counter.count != entry.Documents {
+		return fmt.Errorf("%d documents instead of %d", counter.count, entry.Documents)
+	}
+	return nil
+}
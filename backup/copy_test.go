@@ -0,0 +1,204 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// readManifest downloads the manifest of the back-up stored under prefix.
+func readManifest(t *testing.T, storage backup.Storage, bucket string, prefix string) backup.BackupManifest {
+
+	download, _, err := storage.Get(context.Background(), bucket, prefix+"manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = download.Close() }()
+	var manifest backup.BackupManifest
+	if err = json.NewDecoder(download).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestCopySkipsPresentObjects(t *testing.T) {
+
+	ctx := context.Background()
+	source, sourceConfig, cleanupSource := openLocalStorage(t, "source", "backups/")
+	defer cleanupSource()
+	destination, destinationConfig, cleanupDestination := openLocalStorage(t, "destination", "")
+	defer cleanupDestination()
+
+	documents := bsonDocuments(t, 100)
+	backupTime := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	name := storeBackup(t, source, sourceConfig, "inventory", backupTime, documents, backup.UploadOptions{Compression: backup.Compression{Codec: "gzip"}}, "")
+
+	stats, err := backup.Copy(ctx, source, "source/backups/"+name, false, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats != (backup.CopyStats{Backups: 1, Copied: 2, Bytes: stats.Bytes}) || stats.Bytes == 0 {
+		t.Fatalf("copied %+v: %v", stats, err)
+	}
+	// Copied again, every object is already present.
+	stats, err = backup.Copy(ctx, source, "source/backups/inventory", true, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats != (backup.CopyStats{Backups: 1, Skipped: 2}) {
+		t.Errorf("copied %+v again: %v", stats, err)
+	}
+
+	verification, err := backup.Verify(ctx, destination, "destination/"+name, false, nil, nil)
+	if err != nil || !verification.Passed() {
+		t.Errorf("verified the copy %+v: %v", verification, err)
+	}
+}
+
+func TestCopyReplacesDifferentObjects(t *testing.T) {
+
+	ctx := context.Background()
+	source, sourceConfig, cleanupSource := openLocalStorage(t, "source", "backups/")
+	defer cleanupSource()
+	destination, destinationConfig, cleanupDestination := openLocalStorage(t, "destination", "")
+	defer cleanupDestination()
+
+	documents := bsonDocuments(t, 100)
+	name := storeBackup(t, source, sourceConfig, "inventory", time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local), documents, backup.UploadOptions{}, "")
+	if _, err := backup.Copy(ctx, source, "source/backups/"+name, false, destination, destinationConfig, backup.CopyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// The copied collection is corrupted, keeping its size.
+	upload, err := destination.Put(ctx, "destination", name+"/items.bson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Write(make([]byte, len(documents))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := backup.Copy(ctx, source, "source/backups/"+name, false, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats != (backup.CopyStats{Backups: 1, Copied: 1, Skipped: 1, Bytes: int64(len(documents))}) {
+		t.Errorf("copied %+v again: %v", stats, err)
+	}
+	verification, err := backup.Verify(ctx, destination, "destination/"+name, false, nil, nil)
+	if err != nil || !verification.Passed() {
+		t.Errorf("verified the copy %+v: %v", verification, err)
+	}
+}
+
+func TestCopyRebasesIncrementalParent(t *testing.T) {
+
+	ctx := context.Background()
+	source, sourceConfig, cleanupSource := openLocalStorage(t, "source", "backups/")
+	defer cleanupSource()
+	destination, destinationConfig, cleanupDestination := openLocalStorage(t, "destination", "replica/")
+	defer cleanupDestination()
+
+	documents := bsonDocuments(t, 10)
+	full := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	parent := storeBackup(t, source, sourceConfig, "inventory", full, documents, backup.UploadOptions{}, "")
+	incremental := storeBackup(t, source, sourceConfig, "inventory", full.Add(time.Hour), documents, backup.UploadOptions{}, "backups/"+parent+"/")
+
+	// The back-up the incremental one depends on is copied along with it, its manifest pointing to the copy.
+	stats, err := backup.Copy(ctx, source, "source/backups/"+incremental, false, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats.Backups != 2 || stats.Copied != 4 {
+		t.Fatalf("copied %+v: %v", stats, err)
+	}
+	manifest := readManifest(t, destination, "destination", "replica/"+incremental+"/")
+	if manifest.Parent != "replica/"+parent+"/" {
+		t.Errorf("copied with the parent %q", manifest.Parent)
+	}
+	if manifest = readManifest(t, source, "source", "backups/"+incremental+"/"); manifest.Parent != "backups/"+parent+"/" {
+		t.Errorf("left the parent %q in the source", manifest.Parent)
+	}
+
+	// The rewritten manifest is skipped once present.
+	stats, err = backup.Copy(ctx, source, "source/backups/"+incremental, false, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats.Copied != 0 || stats.Skipped != 4 {
+		t.Errorf("copied %+v again: %v", stats, err)
+	}
+}
+
+func TestCopyChecksDocuments(t *testing.T) {
+
+	ctx := context.Background()
+	source, sourceConfig, cleanupSource := openLocalStorage(t, "source", "")
+	defer cleanupSource()
+	destination, destinationConfig, cleanupDestination := openLocalStorage(t, "destination", "")
+	defer cleanupDestination()
+
+	documents := bsonDocuments(t, 10)
+	name := storeBackup(t, source, sourceConfig, "inventory", time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local), documents, backup.UploadOptions{}, "")
+
+	// The documents are altered without changing their size, which only their checksum reveals.
+	altered := bytes.Replace(documents, []byte("document"), []byte("tampered"), 1)
+	upload, err := source.Put(ctx, "source", name+"/items.bson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Write(altered); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backup.Copy(ctx, source, "source/"+name, false, destination, destinationConfig, backup.CopyOptions{})
+	if !errors.Is(err, backup.ErrIncomplete) || !strings.Contains(err.Error(), "does not match the manifest") {
+		t.Fatalf("copied altered documents: %v", err)
+	}
+	if objects, err := destination.List(ctx, "destination", "", true); err != nil || len(objects) != 0 {
+		t.Errorf("left %v in the destination: %v", objects, err)
+	}
+}
+
+func TestCopyMatching(t *testing.T) {
+
+	ctx := context.Background()
+	source, sourceConfig, cleanupSource := openLocalStorage(t, "source", "backups/")
+	defer cleanupSource()
+	destination, destinationConfig, cleanupDestination := openLocalStorage(t, "destination", "")
+	defer cleanupDestination()
+
+	documents := bsonDocuments(t, 10)
+	first := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	for _, database := range []string{"inventory", "invoices", "orders"} {
+		storeBackup(t, source, sourceConfig, database, first, documents, backup.UploadOptions{}, "")
+		storeBackup(t, source, sourceConfig, database, first.Add(time.Hour), documents, backup.UploadOptions{}, "")
+	}
+
+	// Only the latest back-up of each matching database is copied.
+	stats, err := backup.CopyMatching(ctx, source, "^inv", "source/backups", true, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats.Backups != 2 {
+		t.Fatalf("copied %+v: %v", stats, err)
+	}
+	databases, err := backup.ListDatabases(ctx, destination, "destination", "")
+	if err != nil || !reflect.DeepEqual(databases, []string{"inventory/", "invoices/"}) {
+		t.Errorf("copied the databases %v: %v", databases, err)
+	}
+	backups, err := backup.ListBackups(ctx, destination, "destination", "inventory/")
+	if err != nil || len(backups) != 1 || !backups[0].Time.Equal(first.Add(time.Hour)) {
+		t.Errorf("copied the back-ups %+v: %v", backups, err)
+	}
+
+	// Without latest, every back-up is copied.
+	stats, err = backup.CopyMatching(ctx, source, "^orders$", "source/backups/", false, destination, destinationConfig, backup.CopyOptions{})
+	if err != nil || stats.Backups != 2 || stats.Copied != 4 {
+		t.Errorf("copied %+v: %v", stats, err)
+	}
+	download, _, err := destination.Get(ctx, "destination", "orders/orders"+first.Format(backup.BackupTimeFormat)+"/items.bson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := ioutil.ReadAll(download)
+	_ = download.Close()
+	if err != nil || !bytes.Equal(copied, documents) {
+		t.Errorf("copied %d bytes instead of %d: %v", len(copied), len(documents), err)
+	}
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// skippedSystemDatabases are the databases of an instance which are not backed up unless asked for.
+var skippedSystemDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// DatabaseSelection selects the databases of a MongoDB instance to back up in a single run.
+// An empty selection backs up the database of the MongoDB configuration only.
+type DatabaseSelection struct {
+	// Databases lists the databases to back up.
+	Databases []string
+	// All backs up every database of the instance, except the admin, config and local ones.
+	All bool
+	// Include and Exclude filter the databases of the instance by name.
+	Include, Exclude *regexp.Regexp
+	// System also backs up the admin and config databases. The local database, specific to each member of a replica set, is never backed up.
+	System bool
+}
+
+// IsEmpty reports whether the selection only backs up the database of the MongoDB configuration.
+func (selection DatabaseSelection) IsEmpty() bool {
+
+	return len(selection.Databases) == 0 && !selection.All && selection.Include == nil && selection.Exclude == nil
+}
+
+// NewDatabaseSelection creates a selection from its databases, include and exclude regular expressions.
+func NewDatabaseSelection(databases []string, all bool, include string, exclude string, system bool) (DatabaseSelection, error) {
+
+	selection := DatabaseSelection{Databases: databases, All: all, System: system}
+	var err error
+	if include != "" {
+		if selection.Include, err = regexp.Compile(include); err != nil {
+			return selection, errorf(ErrConfig, "invalid include expression: %w", err)
+		}
+	}
+	if exclude != "" {
+		if selection.Exclude, err = regexp.Compile(exclude); err != nil {
+			return selection, errorf(ErrConfig, "invalid exclude expression: %w", err)
+		}
+	}
+	if len(databases) > 0 && (all || selection.Include != nil || selection.Exclude != nil) {
+		return selection, errorf(ErrConfig, "a list of databases cannot be combined with all databases, include or exclude")
+	}
+	return selection, nil
+}
+
+// SelectDatabases returns the names of the databases of the instance described by configMongoDB selected for back-up, in order.
+func SelectDatabases(ctx context.Context, configMongoDB ConfigMongoDB, selection DatabaseSelection) ([]string, error) {
+
+	if selection.IsEmpty() {
+		return []string{configMongoDB.Database}, nil
+	}
+	if len(selection.Databases) > 0 {
+		return selection.Databases, nil
+	}
+
+	client, err := dialMongo(ctx, configMongoDB)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = client.Disconnect(context.Background()) }()
+	names, err := client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errorf(ErrConnect, "failed to list databases: %w", err)
+	}
+
+	var databases []string
+	for _, name := range names {
+		if skippedSystemDatabases[name] && (!selection.System || name == "local") {
+			continue
+		}
+		if selection.Include != nil && !selection.Include.MatchString(name) {
+			continue
+		}
+		if selection.Exclude != nil && selection.Exclude.MatchString(name) {
+			continue
+		}
+		databases = append(databases, name)
+	}
+	sort.Strings(databases)
+	return databases, nil
+}
+
+// DatabaseConfig returns the configuration of the given database of the instance described by configMongoDB.
+// Users are still authenticated against the database they are authenticated against by the configuration,
+// the admin database if it names none, as with a connection string.
+func DatabaseConfig(configMongoDB ConfigMongoDB, database string) ConfigMongoDB {
+
+	if configMongoDB.AuthSource == "" {
+		if clientOptions, err := configMongoDB.clientOptions(); err == nil && clientOptions.Auth != nil {
+			configMongoDB.AuthSource = clientOptions.Auth.AuthSource
+			if configMongoDB.AuthSource == "" {
+				configMongoDB.AuthSource = "admin"
+			}
+		}
+	}
+	configMongoDB.Database = database
+	return configMongoDB
+}
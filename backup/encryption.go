@@ -0,0 +1,442 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	// encryptionMetadataKey is the custom metadata key recording the encryption scheme of an encrypted object.
+	encryptionMetadataKey = "encryption"
+	// encryptionKeysMetadataKey is the custom metadata key listing the IDs of the keys able to decrypt an object.
+	encryptionKeysMetadataKey = "encryption-keys"
+	// encryptionScheme encrypts the object with a random data key using AES-256-GCM in chunks.
+	encryptionScheme = "aes-256-gcm-stream"
+	// encryptionChunkSize is the size of the plaintext chunks, each sealed separately.
+	encryptionChunkSize = 65536
+	// keyIDPrefix marks the IDs of keys read from a keyfile, while age recipients are identified by their public key.
+	keyIDPrefix = "key:"
+)
+
+// Encryption wraps the data key of each back-up object with a key read from a keyfile or for a list of age recipients.
+type Encryption struct {
+	key        []byte
+	recipients []age.Recipient
+	keyIDs     []string
+}
+
+// Decryption holds the keys able to unwrap the data keys of encrypted back-up objects.
+type Decryption struct {
+	// keys maps the IDs of the keys read from keyfiles to the keys.
+	keys       map[string][]byte
+	identities []age.Identity
+}
+
+// readKeyFile reads a 32-byte AES-256 key, stored as hex, base64 or raw bytes, and returns it along with its ID.
+func readKeyFile(keyFile string) ([]byte, string, error) {
+
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	encoded := strings.TrimSpace(string(contents))
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != 32 {
+		key = contents
+	}
+	if len(key) != 32 {
+		return nil, "", fmt.Errorf("keyfile %s holds no 32-byte key in hex, base64 or raw form", keyFile)
+	}
+	// The ID is derived from the key, so that the key matching an object can be found among several.
+	sum := sha256.Sum256(key)
+	return key, keyIDPrefix + hex.EncodeToString(sum[:8]), nil
+}
+
+// LoadEncryptionKey returns an encryption wrapping the data keys with the key of the keyfile.
+func LoadEncryptionKey(keyFile string) (*Encryption, error) {
+
+	key, keyID, err := readKeyFile(keyFile)
+	if err != nil {
+		return nil, newError(ErrConfig, err)
+	}
+	return &Encryption{key: key, keyIDs: []string{keyID}}, nil
+}
+
+// LoadEncryptionRecipients returns an encryption wrapping the data keys for the age recipients listed in the file, one per line.
+func LoadEncryptionRecipients(recipientsFile string) (*Encryption, error) {
+
+	file, err := os.Open(recipientsFile)
+	if err != nil {
+		return nil, errorf(ErrConfig, "failed to read recipients: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	recipients, err := age.ParseRecipients(file)
+	if err != nil {
+		return nil, errorf(ErrConfig, "invalid recipients: %w", err)
+	}
+	encryption := &Encryption{recipients: recipients}
+	for _, recipient := range recipients {
+		encryption.keyIDs = append(encryption.keyIDs, fmt.Sprint(recipient))
+	}
+	return encryption, nil
+}
+
+// LoadEncryption returns the encryption with the key of the keyfile or for the recipients of the file, whichever is given,
+// or nil if neither is.
+func LoadEncryption(keyFile string, recipientsFile string) (*Encryption, error) {
+
+	switch {
+	case keyFile != "" && recipientsFile != "":
+		return nil, errorf(ErrConfig, "an encryption key and recipients cannot be used together")
+	case keyFile != "":
+		return LoadEncryptionKey(keyFile)
+	case recipientsFile != "":
+		return LoadEncryptionRecipients(recipientsFile)
+	}
+	return nil, nil
+}
+
+// LoadDecryption returns the decryption keys read from the keyfiles and the age identity file, if any.
+func LoadDecryption(keyFiles []string, identityFile string) (*Decryption, error) {
+
+	decryption := &Decryption{keys: make(map[string][]byte)}
+	for _, keyFile := range keyFiles {
+		key, keyID, err := readKeyFile(keyFile)
+		if err != nil {
+			return nil, newError(ErrConfig, err)
+		}
+		decryption.keys[keyID] = key
+	}
+	if identityFile != "" {
+		file, err := os.Open(identityFile)
+		if err != nil {
+			return nil, errorf(ErrConfig, "failed to read identities: %w", err)
+		}
+		defer func() { _ = file.Close() }()
+		if decryption.identities, err = age.ParseIdentities(file); err != nil {
+			return nil, errorf(ErrConfig, "invalid identities: %w", err)
+		}
+	}
+	return decryption, nil
+}
+
+// metadata returns the custom metadata recording the encryption of an object.
+func (encryption *Encryption) metadata() map[string]string {
+
+	return map[string]string{
+		encryptionMetadataKey:     encryptionScheme,
+		encryptionKeysMetadataKey: strings.Join(encryption.keyIDs, ","),
+	}
+}
+
+// NewWriter returns a writer encrypting to w with a new data key, which must be closed to seal the last chunk.
+// The wrapped data key is written first, prefixed by its length.
+func (encryption *Encryption) NewWriter(w io.Writer) (io.WriteCloser, error) {
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	var wrapped []byte
+	if encryption.key != nil {
+		keyAEAD, err := newAEAD(encryption.key)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, keyAEAD.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		wrapped = keyAEAD.Seal(nonce, nonce, dataKey, []byte(encryption.keyIDs[0]))
+	} else {
+		var buffer bytes.Buffer
+		ageWriter, err := age.Encrypt(&buffer, encryption.recipients...)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = ageWriter.Write(dataKey); err != nil {
+			return nil, err
+		}
+		if err = ageWriter.Close(); err != nil {
+			return nil, err
+		}
+		wrapped = buffer.Bytes()
+	}
+
+	header := make([]byte, 4, 4+len(wrapped))
+	binary.BigEndian.PutUint32(header, uint32(len(wrapped)))
+	header = append(header, wrapped...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: dataAEAD, buf: make([]byte, 0, encryptionChunkSize), header: header}, nil
+}
+
+// encryptState is the state of an encryptWriter between two chunks, recorded to continue its stream after an interruption.
+type encryptState struct {
+	// Header is the wrapped data key written at the start of the stream, prefixed by its length.
+	Header  []byte `json:"header"`
+	Counter uint64 `json:"counter"`
+	// SealedPending is the data written after the last sealed chunk, sealed with the data key under the nonce prefixing it,
+	// so that the state holds no data in clear.
+	SealedPending []byte `json:"sealedPending"`
+}
+
+// resumeWriter returns a writer continuing the stream of state to w.
+// Only the data keys wrapped by a keyfile can be unwrapped to continue, those wrapped for age recipients requiring an identity.
+func (encryption *Encryption) resumeWriter(w io.Writer, state encryptState) (*encryptWriter, error) {
+
+	if encryption.key == nil {
+		return nil, errors.New("the data keys wrapped for age recipients cannot be unwrapped to continue a stream")
+	}
+	if len(state.Header) < 4 || int(binary.BigEndian.Uint32(state.Header)) != len(state.Header)-4 {
+		return nil, errors.New("invalid encryption state")
+	}
+	dataKey, err := unwrapDataKey(encryption.key, encryption.keyIDs[0], state.Header[4:])
+	if err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := dataAEAD.NonceSize()
+	if len(state.SealedPending) < nonceSize {
+		return nil, errors.New("invalid encryption state")
+	}
+	buf := make([]byte, 0, encryptionChunkSize+dataAEAD.Overhead())
+	buf, err = dataAEAD.Open(buf, state.SealedPending[:nonceSize], state.SealedPending[nonceSize:], state.Header)
+	if err != nil || len(buf) > encryptionChunkSize {
+		return nil, errors.New("invalid encryption state, the data pending encryption could not be opened")
+	}
+	return &encryptWriter{w: w, aead: dataAEAD, buf: buf, counter: state.Counter, header: state.Header}, nil
+}
+
+// unwrapDataKey decrypts a data key wrapped by the key of a keyfile.
+func unwrapDataKey(key []byte, keyID string, wrapped []byte) ([]byte, error) {
+
+	keyAEAD, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := keyAEAD.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, errors.New("invalid data key")
+	}
+	dataKey, err := keyAEAD.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// decryptReader returns a reader decrypting r, whose scheme and key IDs are recorded in the object's custom metadata.
+func (decryption *Decryption) decryptReader(r io.Reader, scheme string, keyIDs string) (io.Reader, error) {
+
+	if scheme != encryptionScheme {
+		return nil, fmt.Errorf("unsupported encryption %q", scheme)
+	}
+	if decryption == nil {
+		return nil, fmt.Errorf("the object is encrypted for %s, an encryption key or identity is required", keyIDs)
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read the data key: %w", err)
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, fmt.Errorf("failed to read the data key: %w", err)
+	}
+
+	var dataKey []byte
+	for _, keyID := range strings.Split(keyIDs, ",") {
+		key, ok := decryption.keys[keyID]
+		if !ok {
+			continue
+		}
+		var err error
+		if dataKey, err = unwrapDataKey(key, keyID, wrapped); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if dataKey == nil && !strings.HasPrefix(keyIDs, keyIDPrefix) && len(decryption.identities) > 0 {
+		ageReader, err := age.Decrypt(bytes.NewReader(wrapped), decryption.identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+		}
+		if dataKey, err = ioutil.ReadAll(ageReader); err != nil {
+			return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+		}
+	}
+	if dataKey == nil {
+		return nil, fmt.Errorf("none of the given keys matches %s", keyIDs)
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: bufio.NewReader(r), aead: dataAEAD}, nil
+}
+
+// newAEAD returns AES-256-GCM with the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk, made of its index and a flag marking the last chunk,
+// so that chunks can neither be reordered nor dropped from the end of the stream.
+func chunkNonce(counter uint64, last bool) []byte {
+
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter seals the data written to it in chunks of encryptionChunkSize bytes.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	header  []byte
+}
+
+// Write buffers p, sealing every full chunk once more data follows it.
+func (encryptWriter *encryptWriter) Write(p []byte) (int, error) {
+
+	var written int
+	for len(p) > 0 {
+		if len(encryptWriter.buf) == encryptionChunkSize {
+			if err := encryptWriter.seal(false); err != nil {
+				return written, err
+			}
+		}
+		copied := copy(encryptWriter.buf[len(encryptWriter.buf):encryptionChunkSize], p)
+		encryptWriter.buf = encryptWriter.buf[:len(encryptWriter.buf)+copied]
+		p = p[copied:]
+		written += copied
+	}
+	return written, nil
+}
+
+// Close seals the last chunk.
+func (encryptWriter *encryptWriter) Close() error {
+
+	return encryptWriter.seal(true)
+}
+
+// state returns the state of the stream, whose chunks sealed so far have all been written.
+// The data pending encryption is sealed with the data key under a random nonce, whose first bit is set
+// so that it never matches the nonce of a chunk, and bound to the header of the stream.
+func (encryptWriter *encryptWriter) state() (encryptState, error) {
+
+	nonce := make([]byte, encryptWriter.aead.NonceSize(), encryptWriter.aead.NonceSize()+len(encryptWriter.buf)+encryptWriter.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return encryptState{}, err
+	}
+	nonce[0] |= 0x80
+	sealed := encryptWriter.aead.Seal(nonce, nonce, encryptWriter.buf, encryptWriter.header)
+	return encryptState{Header: encryptWriter.header, Counter: encryptWriter.counter, SealedPending: sealed}, nil
+}
+
+// seal encrypts the buffered chunk and writes it.
+func (encryptWriter *encryptWriter) seal(last bool) error {
+
+	sealed := encryptWriter.aead.Seal(nil, chunkNonce(encryptWriter.counter, last), encryptWriter.buf, nil)
+	encryptWriter.counter++
+	encryptWriter.buf = encryptWriter.buf[:0]
+	_, err := encryptWriter.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks sealed by encryptWriter.
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// Read copies the decrypted data, returning an error if any chunk was altered or the stream was truncated.
+func (decryptReader *decryptReader) Read(p []byte) (int, error) {
+
+	for len(decryptReader.plain) == 0 {
+		if decryptReader.done {
+			return 0, io.EOF
+		}
+		if err := decryptReader.open(); err != nil {
+			return 0, err
+		}
+	}
+	copied := copy(p, decryptReader.plain)
+	decryptReader.plain = decryptReader.plain[copied:]
+	return copied, nil
+}
+
+// open reads and decrypts the next chunk.
+func (decryptReader *decryptReader) open() error {
+
+	if decryptReader.chunk == nil {
+		decryptReader.chunk = make([]byte, encryptionChunkSize+decryptReader.aead.Overhead())
+	}
+	read, err := io.ReadFull(decryptReader.r, decryptReader.chunk)
+	last := false
+	switch err {
+	case nil:
+		// A full chunk is the last one if nothing follows it.
+		if _, err = decryptReader.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	plain, err := decryptReader.aead.Open(decryptReader.chunk[:0], chunkNonce(decryptReader.counter, last), decryptReader.chunk[:read], nil)
+	if err != nil {
+		return errors.New("decryption failed, the object is corrupted or truncated")
+	}
+	decryptReader.counter++
+	decryptReader.plain = plain
+	decryptReader.done = last
+	return nil
+}
@@ -0,0 +1,285 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// encryptionKeys holds the keyfiles and age files of a test, in a temporary directory.
+type encryptionKeys struct {
+	directory string
+	// keyFiles are two keyfiles of different keys.
+	keyFiles []string
+	// recipientsFile and identityFile hold an age recipient and its identity.
+	recipientsFile string
+	identityFile   string
+}
+
+// newEncryptionKeys writes the keys of a test to a temporary directory, removed by the returned function.
+func newEncryptionKeys(t *testing.T) (encryptionKeys, func()) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := encryptionKeys{directory: directory}
+	write := func(name string, contents string) string {
+		fileName := filepath.Join(directory, name)
+		if err := ioutil.WriteFile(fileName, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return fileName
+	}
+	for _, name := range []string{"old.key", "new.key"} {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys.keyFiles = append(keys.keyFiles, write(name, hex.EncodeToString(key)+"\n"))
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.recipientsFile = write("recipients.txt", identity.Recipient().String()+"\n")
+	keys.identityFile = write("identity.txt", identity.String()+"\n")
+	return keys, func() { _ = os.RemoveAll(directory) }
+}
+
+// encrypt encrypts the data, written in pieces as uploads stream it, and returns it along with the metadata of its object.
+func encrypt(t *testing.T, encryption *Encryption, data []byte) ([]byte, map[string]string) {
+
+	var sealed bytes.Buffer
+	writer, err := encryption.NewWriter(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := 0; offset < len(data); offset += 10000 {
+		end := offset + 10000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err = writer.Write(data[offset:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes(), encryption.metadata()
+}
+
+// decrypt decrypts the data of an object encrypted as recorded in its metadata.
+func decrypt(decryption *Decryption, sealed []byte, metadata map[string]string) ([]byte, error) {
+
+	reader, err := decryption.decryptReader(bytes.NewReader(sealed), metadata[encryptionMetadataKey], metadata[encryptionKeysMetadataKey])
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+
+	keys, cleanup := newEncryptionKeys(t)
+	defer cleanup()
+	withKey, err := LoadEncryption(keys.keyFiles[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forRecipients, err := LoadEncryption("", keys.recipientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey, err := LoadDecryption(keys.keyFiles[:1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byIdentity, err := LoadDecryption(nil, keys.identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		encryption *Encryption
+		decryption *Decryption
+	}{
+		{"keyfile", withKey, byKey},
+		{"age recipients", forRecipients, byIdentity},
+	}
+	// The sizes cover an empty stream and the boundaries of the chunks.
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 5}
+
+	for _, test := range tests {
+		for _, size := range sizes {
+			data := make([]byte, size)
+			if _, err = rand.Read(data); err != nil {
+				t.Fatal(err)
+			}
+			sealed, metadata := encrypt(t, test.encryption, data)
+			if size > 16 && bytes.Contains(sealed, data) {
+				t.Errorf("%s, %d bytes: the data is stored in clear", test.name, size)
+			}
+			decrypted, err := decrypt(test.decryption, sealed, metadata)
+			if err != nil || !bytes.Equal(decrypted, data) {
+				t.Errorf("%s, %d bytes: decrypted %d bytes: %v", test.name, size, len(decrypted), err)
+			}
+		}
+	}
+}
+
+func TestDecryptionFailures(t *testing.T) {
+
+	keys, cleanup := newEncryptionKeys(t)
+	defer cleanup()
+	encryption, err := LoadEncryption(keys.keyFiles[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*encryptionChunkSize+5)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	sealed, metadata := encrypt(t, encryption, data)
+	// The stream is made of the wrapped data key, prefixed by its length, then of four sealed chunks.
+	chunkSize := encryptionChunkSize + 16
+	lastChunk := len(sealed) - (5 + 16)
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-chunkSize] ^= 1
+
+	wrongKey, err := LoadDecryption(keys.keyFiles[1:], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := LoadDecryption(nil, keys.identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rightKey, err := LoadDecryption(keys.keyFiles[:1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		decryption *Decryption
+		sealed     []byte
+	}{
+		{"no key", nil, sealed},
+		{"wrong key", wrongKey, sealed},
+		{"age identity", identity, sealed},
+		{"truncated key", rightKey, sealed[:10]},
+		{"truncated chunk", rightKey, sealed[:len(sealed)-1]},
+		{"last chunk dropped", rightKey, sealed[:lastChunk]},
+		{"two chunks dropped", rightKey, sealed[:lastChunk-chunkSize]},
+		{"tampered chunk", rightKey, tampered},
+	}
+
+	for _, test := range tests {
+		if decrypted, err := decrypt(test.decryption, test.sealed, metadata); err == nil {
+			t.Errorf("%s: decrypted %d bytes", test.name, len(decrypted))
+		}
+	}
+}
+
+func TestDecryptionKeyRotation(t *testing.T) {
+
+	keys, cleanup := newEncryptionKeys(t)
+	defer cleanup()
+
+	// The back-ups encrypted before and after the key is replaced are decrypted with both keys at hand.
+	decryption, err := LoadDecryption(keys.keyFiles, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyFile := range keys.keyFiles {
+		encryption, err := LoadEncryptionKey(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte("documents encrypted with " + filepath.Base(keyFile))
+		sealed, metadata := encrypt(t, encryption, data)
+		if decrypted, err := decrypt(decryption, sealed, metadata); err != nil || !bytes.Equal(decrypted, data) {
+			t.Errorf("%s: decrypted %q: %v", filepath.Base(keyFile), decrypted, err)
+		}
+	}
+
+	if _, err = LoadEncryption(keys.keyFiles[0], keys.recipientsFile); err == nil {
+		t.Error("encrypted with a key and recipients together")
+	}
+}
+
+func TestEncryptionResume(t *testing.T) {
+
+	keys, cleanup := newEncryptionKeys(t)
+	defer cleanup()
+	encryption, err := LoadEncryption(keys.keyFiles[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryption, err := LoadDecryption(keys.keyFiles[:1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*encryptionChunkSize+5)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream is interrupted with half a chunk pending, then continued from its recorded state.
+	var sealed bytes.Buffer
+	writer, err := encryption.NewWriter(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted := encryptionChunkSize + encryptionChunkSize/2
+	if _, err = writer.Write(data[:interrupted]); err != nil {
+		t.Fatal(err)
+	}
+	state, err := writer.(*encryptWriter).state()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(recorded, []byte(base64.StdEncoding.EncodeToString(data[encryptionChunkSize:interrupted])[:32])) || bytes.Contains(state.SealedPending, data[encryptionChunkSize:encryptionChunkSize+32]) {
+		t.Error("the data pending encryption is recorded in clear")
+	}
+
+	resumed, err := encryption.resumeWriter(&sealed, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resumed.Write(data[interrupted:]); err == nil {
+		err = resumed.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decrypt(decryption, sealed.Bytes(), encryption.metadata())
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Errorf("decrypted %d bytes instead of %d: %v", len(decrypted), len(data), err)
+	}
+
+	// The pending data is bound to the stream it belongs to.
+	other, err := encryption.NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Header = other.(*encryptWriter).header
+	if _, err = encryption.resumeWriter(ioutil.Discard, state); err == nil {
+		t.Error("continued a stream with the state of another one")
+	}
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v6"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/auth"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"storj.io/uplink"
+)
+
+// Kinds of the errors returned by the package, to be tested with errors.Is.
+var (
+	// ErrConfig reports an invalid configuration, option or back-up path.
+	ErrConfig = errors.New("invalid configuration")
+	// ErrConnect reports a failure to connect to MongoDB or to the Storj network.
+	ErrConnect = errors.New("connection failed")
+	// ErrAuth reports credentials or permissions refused by MongoDB or the Storj network.
+	ErrAuth = errors.New("authentication failed")
+	// ErrNoBackup reports that no back-up matches the requested path or time.
+	ErrNoBackup = errors.New("no back-up found")
+	// ErrIncomplete reports a back-up whose objects do not match its manifest.
+	ErrIncomplete = errors.New("incomplete back-up")
+	// ErrUpload reports a failure to read a database or to upload its back-up.
+	ErrUpload = errors.New("upload failed")
+	// ErrDownload reports a failure to list or download a back-up.
+	ErrDownload = errors.New("download failed")
+	// ErrRestore reports a failure to restore a back-up into MongoDB or the ./dump folder.
+	ErrRestore = errors.New("restore failed")
+	// ErrDelete reports a failure to delete a back-up.
+	ErrDelete = errors.New("delete failed")
+)
+
+// Error is the error returned by the functions of the package, classified by its kind.
+// Its message is the one of the underlying error.
+type Error struct {
+	// Kind is one of the Err* errors of the package.
+	Kind error
+	Err  error
+}
+
+// Error returns the message of the underlying error.
+func (err *Error) Error() string {
+
+	return err.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (err *Error) Unwrap() error {
+
+	return err.Err
+}
+
+// Is reports whether the error is of the target kind.
+func (err *Error) Is(target error) bool {
+
+	return err.Kind == target
+}
+
+// newError classifies err as of the given kind, unless it is already classified or is an authentication failure.
+// It returns nil if err is nil.
+func newError(kind error, err error) error {
+
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	if isAuthError(err) {
+		kind = ErrAuth
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// errorf returns an error of the given kind with the formatted message, wrapping any %w argument.
+func errorf(kind error, format string, args ...interface{}) error {
+
+	return newError(kind, fmt.Errorf(format, args...))
+}
+
+// isAuthError reports whether err is a refusal of the credentials or permissions by MongoDB or the Storj network.
+func isAuthError(err error) bool {
+
+	for err != nil {
+		if errors.Is(err, uplink.ErrPermissionDenied) {
+			return true
+		}
+		switch typed := err.(type) {
+		case *auth.Error:
+			return true
+		case mongo.CommandError:
+			// AuthenticationFailed and Unauthorized.
+			return typed.Code == 18 || typed.Code == 13
+		case minio.ErrorResponse:
+			// Missing or invalid credentials, or access denied.
+			return typed.StatusCode == 403
+		case topology.ConnectionError:
+			// Connection errors do not unwrap the handshake error of this version of the driver.
+			err = typed.Wrapped
+		default:
+			err = errors.Unwrap(err)
+		}
+	}
+	return false
+}
@@ -0,0 +1,507 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The directories of every bucket of a local storage which hold its internal files, rather than objects.
+const (
+	localMetadataDirectory = ".metadata"
+	localUploadsDirectory  = ".uploads"
+)
+
+// localStorage stores the objects in a local directory, holding a directory per bucket
+// and a file per object, whose path is its key.
+// The custom metadata of the objects are stored as JSON in the .metadata directory of the bucket,
+// and the pending uploads are written to its .uploads directory, then renamed as they are committed.
+type localStorage struct {
+	directory string
+}
+
+// NewLocalStorage returns the storage of the back-ups in the local directory.
+func NewLocalStorage(directory string) (MultipartStorage, error) {
+
+	if directory == "" {
+		return nil, errorf(ErrConfig, "the directory of the local backend is not set")
+	}
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, newError(ErrConfig, err)
+	}
+	return &localStorage{directory: directory}, nil
+}
+
+// bucketPath returns the path of the directory of the bucket.
+func (storage *localStorage) bucketPath(bucket string) (string, error) {
+
+	if bucket == "" || bucket == "." || bucket == ".." || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	return filepath.Join(storage.directory, bucket), nil
+}
+
+// objectPaths returns the paths of the file of the object and of its metadata,
+// refusing the keys which would escape the bucket or clash with its internal directories.
+func (storage *localStorage) objectPaths(bucket string, key string) (string, string, error) {
+
+	bucketPath, err := storage.bucketPath(bucket)
+	if err != nil {
+		return "", "", err
+	}
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") || isLocalInternal(key) {
+		return "", "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(bucketPath, filepath.FromSlash(key)),
+		filepath.Join(bucketPath, localMetadataDirectory, filepath.FromSlash(key)), nil
+}
+
+// isLocalInternal reports whether the key lies in an internal directory of the bucket.
+func isLocalInternal(key string) bool {
+
+	top := strings.SplitN(key, "/", 2)[0]
+	return top == localMetadataDirectory || top == localUploadsDirectory
+}
+
+// localError wraps the error of a missing file with ErrObjectNotFound.
+func localError(key string, err error) error {
+
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return err
+}
+
+// Put starts the upload of an object to a temporary file, renamed as it is committed.
+func (storage *localStorage) Put(ctx context.Context, bucket string, key string, metadata map[string]string) (ObjectWriter, error) {
+
+	objectPath, metadataPath, err := storage.objectPaths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := storage.createTemporary(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &localUpload{file: file, objectPath: objectPath, metadataPath: metadataPath, metadata: metadata}, nil
+}
+
+// createTemporary creates a temporary file in the uploads directory of the bucket.
+func (storage *localStorage) createTemporary(bucket string) (*os.File, error) {
+
+	bucketPath, err := storage.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	uploadsPath := filepath.Join(bucketPath, localUploadsDirectory)
+	if err = os.MkdirAll(uploadsPath, 0700); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(uploadsPath, "object-")
+}
+
+// localUpload writes an object to a temporary file.
+type localUpload struct {
+	file         *os.File
+	objectPath   string
+	metadataPath string
+	metadata     map[string]string
+}
+
+// Write writes the data to the temporary file.
+func (upload *localUpload) Write(data []byte) (int, error) {
+
+	return upload.file.Write(data)
+}
+
+// Commit stores the custom metadata, then moves the temporary file to the path of the object.
+func (upload *localUpload) Commit() (int64, error) {
+
+	size, err := upload.file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = upload.file.Sync()
+	}
+	if closeErr := upload.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = commitLocalFile(upload.file.Name(), upload.objectPath, upload.metadataPath, upload.metadata)
+	}
+	if err != nil {
+		_ = os.Remove(upload.file.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+// Abort removes the temporary file.
+func (upload *localUpload) Abort() error {
+
+	_ = upload.file.Close()
+	return os.Remove(upload.file.Name())
+}
+
+// commitLocalFile stores the custom metadata of an object, or removes any former one,
+// then moves the written file to the path of the object.
+func commitLocalFile(filePath string, objectPath string, metadataPath string, metadata map[string]string) error {
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0700); err != nil {
+		return err
+	}
+	if len(metadata) == 0 {
+		if err := os.Remove(metadataPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(metadataPath), 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(metadataPath, data, 0600); err != nil {
+			return err
+		}
+	}
+	return os.Rename(filePath, objectPath)
+}
+
+// readMetadata reads the custom metadata stored for an object, if any.
+func readMetadata(metadataPath string) (map[string]string, error) {
+
+	data, err := ioutil.ReadFile(metadataPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	if err = json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata %s: %w", metadataPath, err)
+	}
+	return metadata, nil
+}
+
+// Get opens the file of the object.
+func (storage *localStorage) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, *ObjectInfo, error) {
+
+	object, err := storage.Stat(ctx, bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	objectPath, _, _ := storage.objectPaths(bucket, key)
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, nil, localError(key, err)
+	}
+	return file, object, nil
+}
+
+// Stat describes the file of the object, along with its custom metadata.
+func (storage *localStorage) Stat(ctx context.Context, bucket string, key string) (*ObjectInfo, error) {
+
+	objectPath, metadataPath, err := storage.objectPaths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := os.Stat(objectPath)
+	if err != nil {
+		return nil, localError(key, err)
+	}
+	if fileInfo.IsDir() {
+		return nil, localError(key, os.ErrNotExist)
+	}
+	metadata, err := readMetadata(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: fileInfo.Size(), Modified: fileInfo.ModTime(), Metadata: metadata}, nil
+}
+
+// List lists the files under the directory of the prefix, skipping the internal directories of the bucket.
+func (storage *localStorage) List(ctx context.Context, bucket string, prefix string, recursive bool) ([]*ObjectInfo, error) {
+
+	bucketPath, err := storage.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && (!strings.HasSuffix(prefix, "/") || strings.HasPrefix(prefix, "/") || path.Clean(prefix)+"/" != prefix) {
+		return nil, fmt.Errorf("invalid prefix %q", prefix)
+	}
+
+	var objects []*ObjectInfo
+	var list func(prefix string) error
+	list = func(prefix string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(bucketPath, filepath.FromSlash(prefix)))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key := prefix + entry.Name()
+			if isLocalInternal(key) {
+				continue
+			}
+			if entry.IsDir() {
+				if recursive {
+					if err = list(key + "/"); err != nil {
+						return err
+					}
+				} else {
+					objects = append(objects, &ObjectInfo{Key: key + "/", IsPrefix: true})
+				}
+				continue
+			}
+			metadata, err := readMetadata(filepath.Join(bucketPath, localMetadataDirectory, filepath.FromSlash(key)))
+			if err != nil {
+				return err
+			}
+			objects = append(objects, &ObjectInfo{Key: key, Size: entry.Size(), Modified: entry.ModTime(), Metadata: metadata})
+		}
+		return nil
+	}
+	if err = list(prefix); err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// Delete removes the file of the object and its metadata,
+// along with the directories of its key left empty.
+func (storage *localStorage) Delete(ctx context.Context, bucket string, key string) error {
+
+	objectPath, metadataPath, err := storage.objectPaths(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(metadataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	bucketPath, _ := storage.bucketPath(bucket)
+	removeEmptyDirectories(filepath.Dir(objectPath), bucketPath)
+	removeEmptyDirectories(filepath.Dir(metadataPath), bucketPath)
+	return nil
+}
+
+// removeEmptyDirectories removes the directory and its parents, up to the root excluded, as long as they are empty.
+func removeEmptyDirectories(directory string, root string) {
+
+	for directory != root && strings.HasPrefix(directory, root) {
+		if os.Remove(directory) != nil {
+			return
+		}
+		directory = filepath.Dir(directory)
+	}
+}
+
+// EnsureBucket creates the directory of the bucket, unless it exists.
+func (storage *localStorage) EnsureBucket(ctx context.Context, bucket string) error {
+
+	bucketPath, err := storage.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(bucketPath, 0700)
+}
+
+// Close does nothing, the local storage holding no resources.
+func (storage *localStorage) Close() error {
+
+	return nil
+}
+
+// uploadPath returns the directory holding the parts of the multipart upload.
+func (storage *localStorage) uploadPath(bucket string, uploadID string) (string, error) {
+
+	bucketPath, err := storage.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	if _, err = hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("invalid upload ID %q", uploadID)
+	}
+	return filepath.Join(bucketPath, localUploadsDirectory, uploadID), nil
+}
+
+// BeginUpload creates the directory of a multipart upload, named after its random ID.
+// The custom metadata is only stored once the upload is committed.
+func (storage *localStorage) BeginUpload(ctx context.Context, bucket string, key string, metadata map[string]string) (string, error) {
+
+	if _, _, err := storage.objectPaths(bucket, key); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	uploadPath, err := storage.uploadPath(bucket, uploadID)
+	if err != nil {
+		return "", err
+	}
+	return uploadID, os.MkdirAll(uploadPath, 0700)
+}
+
+// PutPart starts the upload of a part to a temporary file of the upload directory,
+// renamed after the number of the part as it is committed.
+func (storage *localStorage) PutPart(ctx context.Context, bucket string, key string, uploadID string, number int) (PartWriter, error) {
+
+	uploadPath, err := storage.uploadPath(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 {
+		return nil, fmt.Errorf("invalid part number %d", number)
+	}
+	file, err := ioutil.TempFile(uploadPath, "part-")
+	if err != nil {
+		return nil, localError(key, err)
+	}
+	return &localPart{file: file, partPath: filepath.Join(uploadPath, strconv.Itoa(number))}, nil
+}
+
+// localPart writes a part of a multipart upload to a temporary file.
+type localPart struct {
+	file     *os.File
+	partPath string
+}
+
+// Write writes the data to the temporary file.
+func (part *localPart) Write(data []byte) (int, error) {
+
+	return part.file.Write(data)
+}
+
+// Commit moves the temporary file to the path of the part.
+func (part *localPart) Commit() error {
+
+	err := part.file.Sync()
+	if closeErr := part.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part.file.Name(), part.partPath)
+	}
+	if err != nil {
+		_ = os.Remove(part.file.Name())
+	}
+	return err
+}
+
+// Abort removes the temporary file.
+func (part *localPart) Abort() error {
+
+	_ = part.file.Close()
+	return os.Remove(part.file.Name())
+}
+
+// ListParts lists the files of the upload directory named after a part number.
+func (storage *localStorage) ListParts(ctx context.Context, bucket string, key string, uploadID string) ([]int, error) {
+
+	uploadPath, err := storage.uploadPath(bucket, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(uploadPath)
+	if err != nil {
+		return nil, localError(key, err)
+	}
+	var numbers []int
+	for _, entry := range entries {
+		if number, err := strconv.Atoi(entry.Name()); err == nil && !entry.IsDir() {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// CommitUpload concatenates the parts into a temporary file, moved to the path of the object,
+// then removes the upload directory.
+func (storage *localStorage) CommitUpload(ctx context.Context, bucket string, key string, uploadID string, metadata map[string]string) error {
+
+	objectPath, metadataPath, err := storage.objectPaths(bucket, key)
+	if err != nil {
+		return err
+	}
+	uploadPath, err := storage.uploadPath(bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	numbers, err := storage.ListParts(ctx, bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(numbers) == 0 {
+		// As on S3, an upload is made of one part at least.
+		return fmt.Errorf("%s: no part uploaded", key)
+	}
+	file, err := storage.createTemporary(bucket)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if err = appendFile(file, filepath.Join(uploadPath, strconv.Itoa(number))); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = commitLocalFile(file.Name(), objectPath, metadataPath, metadata)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return os.RemoveAll(uploadPath)
+}
+
+// appendFile appends the content of the file at the path to the file.
+func appendFile(file *os.File, filePath string) error {
+
+	source, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = source.Close() }()
+	_, err = io.Copy(file, source)
+	return err
+}
+
+// AbortUpload removes the upload directory along with its parts.
+func (storage *localStorage) AbortUpload(ctx context.Context, bucket string, key string, uploadID string) error {
+
+	uploadPath, err := storage.uploadPath(bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(uploadPath)
+}
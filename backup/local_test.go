@@ -0,0 +1,352 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// openLocalStorage opens a local storage in a temporary directory, removed by the returned function,
+// along with its configuration.
+func openLocalStorage(t *testing.T, bucket string, uploadPath string) (backup.MultipartStorage, backup.ConfigStorj, func()) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storjConfig := backup.ConfigStorj{Backend: backup.BackendLocal, Directory: directory, Bucket: bucket, UploadPath: uploadPath}
+	storage, err := backup.NewLocalStorage(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.EnsureBucket(context.Background(), bucket); err != nil {
+		t.Fatal(err)
+	}
+	return storage, storjConfig, func() { _ = os.RemoveAll(directory) }
+}
+
+// bsonDocuments returns the concatenated BSON documents of a collection.
+func bsonDocuments(t *testing.T, count int) []byte {
+
+	var documents []byte
+	for i := 0; i < count; i++ {
+		document, err := bson.Marshal(bson.M{"_id": i, "name": "document"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, document...)
+	}
+	return documents
+}
+
+// storeBackup uploads a back-up of a single collection of documents along with its manifest,
+// incremental if parent is set, and returns the name of the back-up.
+func storeBackup(t *testing.T, storage backup.Storage, storjConfig backup.ConfigStorj, database string, backupTime time.Time, documents []byte, uploadOptions backup.UploadOptions, parent string) string {
+
+	ctx := context.Background()
+	uploadFileName := database + "/" + database + backupTime.Format(backup.BackupTimeFormat)
+	collections, err := backup.Upload(ctx, storage, storjConfig, uploadFileName, &bufferCollections{name: "items", reader: bytes.NewReader(documents)}, uploadOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := backup.BackupManifest{Database: database, StartedAt: backupTime, FinishedAt: backupTime, Parent: parent, Collections: collections}
+	if err = backup.UploadManifest(ctx, storage, storjConfig, uploadFileName, manifest, nil); err != nil {
+		t.Fatal(err)
+	}
+	return uploadFileName
+}
+
+// storeIncompleteBackup uploads the collection of a back-up which failed before uploading its manifest,
+// and returns the name of the back-up.
+func storeIncompleteBackup(t *testing.T, storage backup.Storage, storjConfig backup.ConfigStorj, database string, backupTime time.Time) string {
+
+	uploadFileName := database + "/" + database + backupTime.Format(backup.BackupTimeFormat)
+	if _, err := backup.Upload(context.Background(), storage, storjConfig, uploadFileName, &bufferCollections{name: "items", reader: bytes.NewReader(bsonDocuments(t, 1))}, backup.UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return uploadFileName
+}
+
+// inDirectory changes the working directory, where restores write their ./dump folder, until the returned function is called.
+func inDirectory(t *testing.T, directory string) func() {
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(directory); err != nil {
+		t.Fatal(err)
+	}
+	return func() { _ = os.Chdir(workingDirectory) }
+}
+
+func TestLocalStorageObjects(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "")
+	defer cleanup()
+
+	metadata := map[string]string{"compression": "gzip"}
+	upload, err := storage.Put(ctx, storjConfig.Bucket, "db/db2020-01-01_00_00_00/items.bson", metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Write([]byte("documents")); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := upload.Commit(); err != nil || size != 9 {
+		t.Fatalf("committed %d bytes: %v", size, err)
+	}
+
+	download, object, err := storage.Get(ctx, storjConfig.Bucket, "db/db2020-01-01_00_00_00/items.bson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(download)
+	_ = download.Close()
+	if err != nil || string(data) != "documents" {
+		t.Errorf("downloaded %q: %v", data, err)
+	}
+	if object.Size != 9 || !reflect.DeepEqual(object.Metadata, metadata) {
+		t.Errorf("described as %+v", object)
+	}
+
+	// An aborted upload stores nothing.
+	upload, err = storage.Put(ctx, storjConfig.Bucket, "db/aborted.bson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = upload.Write([]byte("aborted"))
+	if err = upload.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Stat(ctx, storjConfig.Bucket, "db/aborted.bson"); !errors.Is(err, backup.ErrObjectNotFound) {
+		t.Errorf("aborted object: %v", err)
+	}
+
+	objects, err := storage.List(ctx, storjConfig.Bucket, "db/", false)
+	if err != nil || len(objects) != 1 || !objects[0].IsPrefix || objects[0].Key != "db/db2020-01-01_00_00_00/" {
+		t.Errorf("listed %v: %v", objects, err)
+	}
+	objects, err = storage.List(ctx, storjConfig.Bucket, "", true)
+	if err != nil || len(objects) != 1 || objects[0].Key != "db/db2020-01-01_00_00_00/items.bson" {
+		t.Errorf("listed %v recursively: %v", objects, err)
+	}
+
+	for _, key := range []string{"../escaped", "/absolute", "db/../escaped", ".metadata/internal", "db/"} {
+		if _, err = storage.Put(ctx, storjConfig.Bucket, key, nil); err == nil {
+			t.Errorf("%s: accepted an invalid key", key)
+		}
+	}
+
+	if err = storage.Delete(ctx, storjConfig.Bucket, "db/db2020-01-01_00_00_00/items.bson"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = storage.Get(ctx, storjConfig.Bucket, "db/db2020-01-01_00_00_00/items.bson"); !errors.Is(err, backup.ErrObjectNotFound) {
+		t.Errorf("deleted object: %v", err)
+	}
+	if objects, err = storage.List(ctx, storjConfig.Bucket, "", true); err != nil || len(objects) != 0 {
+		t.Errorf("listed %v after deletion: %v", objects, err)
+	}
+}
+
+func TestLocalStorageMultipart(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "")
+	defer cleanup()
+
+	metadata := map[string]string{"encryption": "aes-256-gcm"}
+	uploadID, err := storage.BeginUpload(ctx, storjConfig.Bucket, "db/object", metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The parts are committed out of order, the second one twice, and an aborted part is left out.
+	for _, part := range []struct {
+		number int
+		data   string
+		commit bool
+	}{{2, "lost", true}, {1, "first ", true}, {2, "second", true}, {3, "aborted", false}} {
+		writer, err := storage.PutPart(ctx, storjConfig.Bucket, "db/object", uploadID, part.number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write([]byte(part.data)); err != nil {
+			t.Fatal(err)
+		}
+		if part.commit {
+			err = writer.Commit()
+		} else {
+			err = writer.Abort()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if parts, err := storage.ListParts(ctx, storjConfig.Bucket, "db/object", uploadID); err != nil || !reflect.DeepEqual(parts, []int{1, 2}) {
+		t.Errorf("listed parts %v: %v", parts, err)
+	}
+	if err = storage.CommitUpload(ctx, storjConfig.Bucket, "db/object", uploadID, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	download, object, err := storage.Get(ctx, storjConfig.Bucket, "db/object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(download)
+	_ = download.Close()
+	if err != nil || string(data) != "first second" || !reflect.DeepEqual(object.Metadata, metadata) {
+		t.Errorf("downloaded %q with %v: %v", data, object.Metadata, err)
+	}
+	if objects, err := storage.List(ctx, storjConfig.Bucket, "", true); err != nil || len(objects) != 1 {
+		t.Errorf("listed %v, expected the object alone: %v", objects, err)
+	}
+}
+
+func TestLocalStoreListRestore(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "backups/")
+	defer cleanup()
+
+	documents := bsonDocuments(t, 100)
+	first := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	storeBackup(t, storage, storjConfig, "inventory", first, documents, backup.UploadOptions{}, "")
+	latest := storeBackup(t, storage, storjConfig, "inventory", first.Add(time.Hour), documents, backup.UploadOptions{Compression: backup.Compression{Codec: "gzip"}}, "")
+
+	databases, err := backup.ListDatabases(ctx, storage, storjConfig.Bucket, storjConfig.UploadPath)
+	if err != nil || !reflect.DeepEqual(databases, []string{"backups/inventory/"}) {
+		t.Errorf("listed databases %v: %v", databases, err)
+	}
+	backups, err := backup.ListBackups(ctx, storage, storjConfig.Bucket, "backups/inventory/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || !backups[0].Time.Equal(first) || backups[1].Prefix != "backups/"+latest+"/" || len(backups[1].Objects) != 2 {
+		t.Fatalf("listed back-ups %+v", backups)
+	}
+
+	verification, err := backup.Verify(ctx, storage, "bucket/backups/inventory", true, nil, nil)
+	if err != nil || !verification.Passed() || verification.Prefix != "backups/"+latest+"/" {
+		t.Errorf("verified %+v: %v", verification, err)
+	}
+
+	// The latest back-up is restored, decompressed, to the ./dump folder.
+	defer inDirectory(t, storjConfig.Directory)()
+	if err = backup.Restore(ctx, storage, "bucket/backups/inventory", true, backup.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join("dump", filepath.Base(latest), "items.bson"))
+	if err != nil || !bytes.Equal(restored, documents) {
+		t.Errorf("restored %d bytes instead of %d: %v", len(restored), len(documents), err)
+	}
+}
+
+func TestLatestBackupBesideOplog(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "backups/")
+	defer cleanup()
+
+	documents := bsonDocuments(t, 10)
+	first := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	storeBackup(t, storage, storjConfig, "inventory", first, documents, backup.UploadOptions{}, "")
+	latest := storeBackup(t, storage, storjConfig, "inventory", first.Add(time.Hour), documents, backup.UploadOptions{}, "")
+	// An oplog segment is captured under the prefix of the database, sorted after its back-ups.
+	upload, err := storage.Put(ctx, storjConfig.Bucket, "backups/inventory/oplog/1593518400-0000000001_1593522000-0000000001.bson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = upload.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	verification, err := backup.Verify(ctx, storage, "bucket/backups/inventory", true, nil, nil)
+	if err != nil || verification.Prefix != "backups/"+latest+"/" {
+		t.Errorf("verified %+v as the latest back-up: %v", verification, err)
+	}
+	if _, err = backup.Verify(ctx, storage, "bucket/backups/catalog", true, nil, nil); !errors.Is(err, backup.ErrNoBackup) {
+		t.Errorf("verified the latest back-up of a database without any: %v", err)
+	}
+}
+
+func TestLatestBackupSkipsIncomplete(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "backups/")
+	defer cleanup()
+
+	documents := bsonDocuments(t, 10)
+	first := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	complete := storeBackup(t, storage, storjConfig, "inventory", first, documents, backup.UploadOptions{}, "")
+	// A back-up still running, whose manifest records no end, then a back-up which failed before its manifest.
+	running := storeIncompleteBackup(t, storage, storjConfig, "inventory", first.Add(time.Hour))
+	if err := backup.UploadManifest(ctx, storage, storjConfig, running, backup.BackupManifest{Database: "inventory", StartedAt: first.Add(time.Hour)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	storeIncompleteBackup(t, storage, storjConfig, "inventory", first.Add(2*time.Hour))
+
+	verification, err := backup.Verify(ctx, storage, "bucket/backups/inventory", true, nil, nil)
+	if err != nil || verification.Prefix != "backups/"+complete+"/" {
+		t.Errorf("verified %+v as the latest back-up: %v", verification, err)
+	}
+	defer inDirectory(t, storjConfig.Directory)()
+	if err = backup.Restore(ctx, storage, "bucket/backups/inventory", true, backup.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join("dump", filepath.Base(complete), "items.bson"))
+	if err != nil || !bytes.Equal(restored, documents) {
+		t.Errorf("restored %d bytes instead of %d: %v", len(restored), len(documents), err)
+	}
+
+	storeIncompleteBackup(t, storage, storjConfig, "catalog", first)
+	if err = backup.Restore(ctx, storage, "bucket/backups/catalog", true, backup.RestoreOptions{}); !errors.Is(err, backup.ErrNoBackup) {
+		t.Errorf("restored the latest back-up of a database without a complete one: %v", err)
+	}
+}
+
+func TestRestoreOplogToDumpRoot(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "backups/")
+	defer cleanup()
+
+	documents, entries := bsonDocuments(t, 10), bsonDocuments(t, 3)
+	backupTime := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	uploadFileName := "inventory/inventory" + backupTime.Format(backup.BackupTimeFormat)
+	collections, err := backup.Upload(ctx, storage, storjConfig, uploadFileName, &bufferCollections{name: "items", reader: bytes.NewReader(documents)}, backup.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oplog, err := backup.Upload(ctx, storage, storjConfig, uploadFileName, &bufferCollections{name: "oplog", reader: bytes.NewReader(entries)}, backup.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := backup.BackupManifest{Database: "inventory", StartedAt: backupTime, FinishedAt: backupTime, Collections: collections, Oplog: &oplog[0]}
+	if err = backup.UploadManifest(ctx, storage, storjConfig, uploadFileName, manifest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oplog is downloaded where mongorestore --oplogReplay reads it, next to the folder of the database.
+	defer inDirectory(t, storjConfig.Directory)()
+	if err = backup.Restore(ctx, storage, "bucket/backups/"+uploadFileName, false, backup.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join("dump", "oplog.bson"))
+	if err != nil || !bytes.Equal(restored, entries) {
+		t.Errorf("restored %d bytes of the oplog instead of %d: %v", len(restored), len(entries), err)
+	}
+	if restored, err = ioutil.ReadFile(filepath.Join("dump", filepath.Base(uploadFileName), "items.bson")); err != nil || !bytes.Equal(restored, documents) {
+		t.Errorf("restored %d bytes instead of %d: %v", len(restored), len(documents), err)
+	}
+}
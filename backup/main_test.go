@@ -0,0 +1,137 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"testing"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// offlineDirectory holds the local storage of the tests run without a Storj configuration, along with their restores.
+var offlineDirectory string
+
+func TestMain(m *testing.M) {
+
+	code := m.Run()
+	if offlineDirectory != "" {
+		_ = os.RemoveAll(offlineDirectory)
+	}
+	os.Exit(code)
+}
+
+// loadStorjConfiguration reads the Storj configuration of the tests and opens its storage.
+// Without a configuration file, the tests run offline against a local directory.
+func loadStorjConfiguration(ctx context.Context) (backup.ConfigStorj, backup.Storage) {
+
+	var storjConfig backup.ConfigStorj
+	data, err := ioutil.ReadFile("../config/storj_config_test.json")
+	switch {
+	case os.IsNotExist(err):
+		if offlineDirectory == "" {
+			if offlineDirectory, err = ioutil.TempDir("", "connector-mongodb-test"); err != nil {
+				log.Fatal(err)
+			}
+		}
+		storjConfig = backup.ConfigStorj{Backend: backup.BackendLocal, Directory: filepath.Join(offlineDirectory, "storage"), Bucket: "connectortest"}
+	case err != nil:
+		log.Fatal("Could not load storj config file: ", err)
+	default:
+		if err = json.Unmarshal(data, &storjConfig); err != nil {
+			log.Fatal("Could not load storj config file: ", err)
+		}
+	}
+	storage, err := backup.Open(ctx, storjConfig, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return storjConfig, storage
+}
+
+// bufferCollections yields a single collection streamed from a buffer.
+type bufferCollections struct {
+	name   string
+	reader io.Reader
+}
+
+func (collections *bufferCollections) NextCollection() (string, io.Reader, error) {
+	if collections.reader == nil {
+		return "", nil, io.EOF
+	}
+	reader := collections.reader
+	collections.reader = nil
+	return collections.name, reader, nil
+}
+
+func TestMongoStore(t *testing.T) {
+
+	ctx := context.Background()
+	storjConfig, storage := loadStorjConfiguration(ctx)
+	defer func() { _ = storage.Close() }()
+
+	// Converting JSON data to bson data.  TODO: convert to BSON using call to mongo library
+	bsonData, _ := json.Marshal("{'testKey': 'testValue'}")
+
+	// Create a buffer as an io.Reader implementor.
+	buf1 := bytes.NewBuffer(bsonData)
+
+	fmt.Printf("Initiating back-up.\n")
+	uploadFileName := path.Join("testdb", "testdb"+time.Now().Format("2006-01-02_15_04_05"))
+	collections, err := backup.Upload(ctx, storage, storjConfig, uploadFileName, &bufferCollections{name: "testdb", reader: buf1}, backup.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest marks the back-up as complete, for the latest one to be restored.
+	manifest := backup.BackupManifest{Database: "testdb", StartedAt: time.Now().UTC(), FinishedAt: time.Now().UTC(), Collections: collections}
+	if err = backup.UploadManifest(ctx, storage, storjConfig, uploadFileName, manifest, nil); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("Back-up complete.\n\n")
+
+}
+
+func TestMongoReStore(t *testing.T) {
+
+	ctx := context.Background()
+	storjConfig, storage := loadStorjConfiguration(ctx)
+	defer func() { _ = storage.Close() }()
+
+	// Offline, the back-up is restored into the temporary directory rather than the package's own.
+	if storjConfig.Backend == backup.BackendLocal && offlineDirectory != "" {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chdir(offlineDirectory); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = os.Chdir(workingDirectory) }()
+	}
+
+	fmt.Printf("Initiating Restore.")
+	if err := backup.Restore(ctx, storage, "connectortest/testdb", true, backup.RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("\nDeleting the test back-up.\n")
+	objects, err := storage.List(ctx, storjConfig.Bucket, "testdb/", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, item := range objects {
+		if err = storage.Delete(ctx, storjConfig.Bucket, item.Key); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("Deleted the test back-up.\n\n")
+}
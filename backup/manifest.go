@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// manifestFileName is the name of the object describing a back-up, stored next to its collections.
+const manifestFileName = "manifest.json"
+
+// bsonFormatVersion identifies the layout of the collection objects: concatenated BSON documents.
+const bsonFormatVersion = 1
+
+// BackupManifest describes the contents of a single back-up.
+type BackupManifest struct {
+	ConnectorVersion string    `json:"connectorVersion"`
+	FormatVersion    int       `json:"formatVersion"`
+	Host             string    `json:"host"`
+	Database         string    `json:"database"`
+	StartedAt        time.Time `json:"startedAt"`
+	FinishedAt       time.Time `json:"finishedAt"`
+	// OplogStart is the newest oplog entry when the back-up started, if the source is a replica set.
+	OplogStart *primitive.Timestamp `json:"oplogStart,omitempty"`
+	// OplogEnd is the newest oplog entry captured with the back-up, if it was taken with the oplog.
+	OplogEnd *primitive.Timestamp `json:"oplogEnd,omitempty"`
+	Oplog    *CollectionManifest  `json:"oplog,omitempty"`
+	// ResumeToken is the change stream position from which the next incremental back-up reads the changes.
+	ResumeToken json.RawMessage `json:"resumeToken,omitempty"`
+	// Parent is the prefix of the back-up an incremental back-up is based on, which is empty for a full back-up.
+	Parent string `json:"parent,omitempty"`
+	// Changes is the object holding the change events of an incremental back-up.
+	Changes     *CollectionManifest  `json:"changes,omitempty"`
+	Collections []CollectionManifest `json:"collections"`
+}
+
+// CollectionManifest describes the back-up object of a single collection.
+type CollectionManifest struct {
+	Name string `json:"name"`
+	// Object is the key of the collection's object relative to the back-up prefix.
+	Object string `json:"object"`
+	// Metadata is the key of the collection's options and indexes relative to the back-up prefix.
+	Metadata string `json:"metadata,omitempty"`
+	// Compression is the codec of the collection's object, which is empty if it is stored uncompressed.
+	Compression string `json:"compression,omitempty"`
+	Documents   int64  `json:"documents"`
+	// Size is the size of the stored object, while SHA256 is the checksum of the uncompressed documents.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// documentCounter implements an io.Writer interface counting the BSON documents of the stream written to it.
+type documentCounter struct {
+	count int64
+	// remaining is the number of bytes left of the current document.
+	remaining int64
+	// header holds a partially received document length.
+	header    [4]byte
+	headerLen int
+}
+
+// Write consumes the stream, counting every document whose length prefix it encounters.
+func (counter *documentCounter) Write(buf []byte) (int, error) {
+
+	numOfBytes := len(buf)
+	for len(buf) > 0 {
+		if counter.remaining == 0 {
+			// Gather the length prefix of the next document.
+			copied := copy(counter.header[counter.headerLen:], buf)
+			counter.headerLen += copied
+			buf = buf[copied:]
+			if counter.headerLen < len(counter.header) {
+				break
+			}
+			length := int32(binary.LittleEndian.Uint32(counter.header[:]))
+			counter.headerLen = 0
+			if length < 5 {
+				return numOfBytes - len(buf), fmt.Errorf("invalid BSON document length %d", length)
+			}
+			counter.remaining = int64(length) - int64(len(counter.header))
+			counter.count++
+		}
+		skipped := int64(len(buf))
+		if skipped > counter.remaining {
+			skipped = counter.remaining
+		}
+		buf = buf[skipped:]
+		counter.remaining -= skipped
+	}
+	return numOfBytes, nil
+}
+
+// UploadManifest uploads the manifest of the back-up stored under the uploadFileName prefix.
+// It must be uploaded after all collections, as its presence marks the back-up as complete.
+func UploadManifest(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, manifest BackupManifest, progress Progress) error {
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return newError(ErrUpload, err)
+	}
+
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + manifestFileName
+	if err = uploadBytes(ctx, storage, configStorj.Bucket, objectKey, manifestJSON); err != nil {
+		return errorf(ErrUpload, "could not upload manifest: %w", err)
+	}
+	progress.report(Event{Kind: EventUploaded, Bucket: configStorj.Bucket, Object: objectKey, Bytes: int64(len(manifestJSON)), Total: int64(len(manifestJSON)), Message: fmt.Sprintf("Uploaded %s to %s.", objectKey, configStorj.Bucket)})
+	return nil
+}
+
+// downloadManifest reads the manifest of the back-up stored under prefix of the bucket.
+// It returns a nil manifest if the back-up has none.
+func downloadManifest(ctx context.Context, storage Storage, bucket string, prefix string) (*BackupManifest, error) {
+
+	manifestJSON, err := downloadBytes(ctx, storage, bucket, prefix+manifestFileName)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err = json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// latestCompleteBackup returns the latest of the back-ups, sorted oldest first, whose manifest marks it as complete,
+// along with its manifest. It returns a nil back-up if none is complete.
+func latestCompleteBackup(ctx context.Context, storage Storage, backups []BackupInfo) (*BackupInfo, *BackupManifest, error) {
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		if !containsObject(backups[i], manifestFileName) {
+			continue
+		}
+		manifest, err := downloadManifest(ctx, storage, backups[i].Bucket, backups[i].Prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if manifest != nil && !manifest.FinishedAt.IsZero() {
+			return &backups[i], manifest, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// checkManifest compares the manifest of a back-up with the objects stored under its prefix.
+// It returns a description of every inconsistency found.
+func checkManifest(manifest *BackupManifest, objects []*ObjectInfo) []string {
+
+	var problems []string
+	if manifest.FinishedAt.IsZero() {
+		problems = append(problems, "the back-up never finished")
+	}
+
+	stored := make(map[string]*ObjectInfo)
+	for _, object := range objects {
+		stored[path.Base(object.Key)] = object
+	}
+	entries := manifest.Collections
+	for _, entry := range []*CollectionManifest{manifest.Oplog, manifest.Changes} {
+		if entry != nil {
+			entries = append(append([]CollectionManifest(nil), entries...), *entry)
+		}
+	}
+	for _, collection := range entries {
+		object, ok := stored[collection.Object]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s collection is missing", collection.Name))
+			continue
+		}
+		if object.Size != collection.Size {
+			problems = append(problems, fmt.Sprintf("%s collection has %d bytes instead of %d", collection.Name, object.Size, collection.Size))
+		}
+		delete(stored, collection.Object)
+		if collection.Metadata != "" {
+			if _, ok := stored[collection.Metadata]; !ok {
+				problems = append(problems, fmt.Sprintf("metadata of %s collection is missing", collection.Name))
+			}
+			delete(stored, collection.Metadata)
+		}
+	}
+	var unlisted []string
+	for name := range stored {
+		if name != manifestFileName {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, name := range unlisted {
+		problems = append(problems, fmt.Sprintf("%s is not listed in the manifest", name))
+	}
+	return problems
+}
+
+// checkBackup verifies the objects of the back-up stored under prefix of the bucket against its manifest.
+// Back-ups without a manifest are accepted with a warning, while incomplete ones are refused unless force is set.
+// It returns the manifest of the back-up, if any, and any error, if occurred.
+func checkBackup(ctx context.Context, storage Storage, bucket string, prefix string, objects []*ObjectInfo, force bool, progress Progress) (*BackupManifest, error) {
+
+	manifest, err := downloadManifest(ctx, storage, bucket, prefix)
+	if err != nil {
+		return nil, errorf(ErrDownload, "could not read the back-up manifest: %w", err)
+	}
+	if manifest == nil {
+		progress.warnf("%s has no manifest, its completeness cannot be verified.", prefix)
+		return nil, nil
+	}
+
+	problems := checkManifest(manifest, objects)
+	if len(problems) > 0 {
+		if !force {
+			return nil, errorf(ErrIncomplete, "back-up %s is incomplete: %s", prefix, strings.Join(problems, ", "))
+		}
+		progress.warnf("back-up %s is incomplete: %s.", prefix, strings.Join(problems, ", "))
+	}
+	return manifest, nil
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// metadataSuffix is appended to a collection name to form the key of its metadata object.
+const metadataSuffix = ".metadata.json"
+
+// MetadataExporter is implemented by collection iterators able to export the options and indexes of a collection.
+type MetadataExporter interface {
+	// Metadata returns the mongodump-compatible <collection>.metadata.json contents of the collection.
+	Metadata(collectionName string) ([]byte, error)
+}
+
+// collectionMetadata mirrors the <collection>.metadata.json files written by mongodump.
+type collectionMetadata struct {
+	Options        bson.D   `bson:"options"`
+	Indexes        []bson.D `bson:"indexes"`
+	UUID           string   `bson:"uuid,omitempty"`
+	CollectionName string   `bson:"collectionName"`
+	Type           string   `bson:"type,omitempty"`
+}
+
+// Metadata returns the options and indexes of the collection as mongodump-compatible extended JSON.
+func (mongoReader *MongoReader) Metadata(collectionName string) ([]byte, error) {
+
+	ctx := mongoReader.ctx
+	specification := mongoReader.specifications[collectionName]
+	metadata := collectionMetadata{Options: bson.D{}, Indexes: []bson.D{}, CollectionName: collectionName, Type: "collection"}
+
+	if specification != nil {
+		if collectionType, ok := specification.Lookup("type").StringValueOK(); ok {
+			metadata.Type = collectionType
+		}
+		if options, ok := specification.Lookup("options").DocumentOK(); ok {
+			if err := bson.Unmarshal(options, &metadata.Options); err != nil {
+				return nil, err
+			}
+		}
+		if _, uuid, ok := specification.Lookup("info", "uuid").BinaryOK(); ok {
+			metadata.UUID = hex.EncodeToString(uuid)
+		}
+	}
+
+	// Views have no indexes of their own.
+	if metadata.Type != "view" {
+		cursor, err := mongoReader.database.Collection(collectionName).Indexes().List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list indexes of %s collection: %w", collectionName, err)
+		}
+		if err = cursor.All(ctx, &metadata.Indexes); err != nil {
+			return nil, fmt.Errorf("failed to list indexes of %s collection: %w", collectionName, err)
+		}
+	}
+
+	return bson.MarshalExtJSON(metadata, true, false)
+}
+
+// createCollection recreates a collection with the options and indexes recorded in its metadata.
+// It returns true if the collection is a view, which holds no documents to restore.
+func (mongoWriter *MongoWriter) createCollection(ctx context.Context, database string, collectionName string, metadataJSON []byte) (bool, error) {
+
+	var metadata collectionMetadata
+	if err := bson.UnmarshalExtJSON(metadataJSON, true, &metadata); err != nil {
+		return false, fmt.Errorf("invalid metadata of %s collection: %w", collectionName, err)
+	}
+	targetDatabase := mongoWriter.client.Database(database)
+
+	// Create the collection with its options, unless it already exists.
+	existing, err := targetDatabase.ListCollectionNames(ctx, bson.D{{Key: "name", Value: collectionName}})
+	if err != nil {
+		return false, err
+	}
+	if len(existing) == 0 {
+		createCommand := append(bson.D{{Key: "create", Value: collectionName}}, metadata.Options...)
+		if err = targetDatabase.RunCommand(ctx, createCommand).Err(); err != nil {
+			return false, fmt.Errorf("failed to create %s collection: %w", collectionName, err)
+		}
+	}
+	if metadata.Type == "view" {
+		return true, nil
+	}
+
+	// Recreate every index but the implicit one on _id.
+	var indexes bson.A
+	for _, index := range metadata.Indexes {
+		var name string
+		var specification bson.D
+		for _, element := range index {
+			switch element.Key {
+			case "name":
+				name, _ = element.Value.(string)
+			case "ns":
+				// The namespace is not accepted by createIndexes and may differ on restore.
+				continue
+			}
+			specification = append(specification, element)
+		}
+		if name != "_id_" {
+			indexes = append(indexes, specification)
+		}
+	}
+	if len(indexes) > 0 {
+		createIndexes := bson.D{{Key: "createIndexes", Value: collectionName}, {Key: "indexes", Value: indexes}}
+		if err = targetDatabase.RunCommand(ctx, createIndexes).Err(); err != nil {
+			return false, fmt.Errorf("failed to create indexes of %s collection: %w", collectionName, err)
+		}
+	}
+	return false, nil
+}
+
+// userSystemCollections lists the system collections holding data of the users, backed up along with the other collections.
+var userSystemCollections = map[string]bool{
+	// system.js holds the stored JavaScript functions of the database.
+	"system.js": true,
+}
+
+// isSystemCollection reports whether the collection is managed by MongoDB itself and must not be backed up,
+// such as system.profile, system.indexes or system.views, which MongoDB rebuilds.
+func isSystemCollection(collectionName string) bool {
+
+	return strings.HasPrefix(collectionName, "system.") && !userSystemCollections[collectionName]
+}
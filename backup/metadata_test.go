@@ -0,0 +1,26 @@
+package backup
+
+import "testing"
+
+func TestIsSystemCollection(t *testing.T) {
+
+	tests := []struct {
+		collection string
+		system     bool
+	}{
+		{"items", false},
+		{"systems", false},
+		{"system.js", false},
+		{"system.profile", true},
+		{"system.indexes", true},
+		{"system.namespaces", true},
+		{"system.views", true},
+		{"system.buckets.weather", true},
+	}
+
+	for _, test := range tests {
+		if system := isSystemCollection(test.collection); system != test.system {
+			t.Errorf("%s: system %t instead of %t", test.collection, system, test.system)
+		}
+	}
+}
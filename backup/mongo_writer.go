@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits of a single bulk write issued while restoring a collection.
+const (
+	restoreBatchDocuments = 1000
+	restoreBatchBytes     = 16 * 1024 * 1024
+)
+
+// MongoWriter restores back-up streams into a live MongoDB instance.
+type MongoWriter struct {
+	client *mongo.Client
+	// database overrides the name of the database the back-up was taken from.
+	database string
+	// drop removes each collection before its documents are restored.
+	drop bool
+	// upsert replaces documents with a matching _id instead of inserting them.
+	upsert bool
+}
+
+// OpenWriter connects to a MongoDB instance based on the specified credentials.
+// It returns a MongoWriter restoring into that instance, which must be closed, and any error, if occurred.
+// If database is not empty, every collection is restored into it instead of its original database.
+func OpenWriter(ctx context.Context, configMongoDB ConfigMongoDB, database string, drop bool, upsert bool) (*MongoWriter, error) {
+
+	client, err := dialMongo(ctx, configMongoDB)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoWriter{client: client, database: database, drop: drop, upsert: upsert}, nil
+}
+
+// Close disconnects from the MongoDB instance.
+func (mongoWriter *MongoWriter) Close() error {
+
+	return mongoWriter.client.Disconnect(context.Background())
+}
+
+// RestoreCollection decodes the concatenated BSON documents read from reader
+// and bulk-inserts them into the given collection.
+// If metadata is not nil, the collection is first recreated with the options and indexes it describes.
+// It returns the number of documents restored and any error, if occurred.
+func (mongoWriter *MongoWriter) RestoreCollection(ctx context.Context, database string, collectionName string, metadata []byte, reader io.Reader) (int, error) {
+
+	if mongoWriter.database != "" {
+		database = mongoWriter.database
+	}
+	collection := mongoWriter.client.Database(database).Collection(collectionName)
+
+	if mongoWriter.drop {
+		if err := collection.Drop(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if metadata != nil {
+		isView, err := mongoWriter.createCollection(ctx, database, collectionName, metadata)
+		if err != nil || isView {
+			return 0, err
+		}
+	}
+
+	var restored, batchSize int
+	var batch []mongo.WriteModel
+	// Write the pending documents as a single unordered bulk operation.
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		restored += len(batch)
+		batch, batchSize = nil, 0
+		return nil
+	}
+
+	documents := bufio.NewReader(reader)
+	for {
+		document, err := readDocument(documents, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+
+		batch = append(batch, mongoWriter.writeModel(document))
+		batchSize += len(document)
+		if len(batch) >= restoreBatchDocuments || batchSize >= restoreBatchBytes {
+			if err = flush(); err != nil {
+				return restored, err
+			}
+		}
+	}
+
+	err := flush()
+	return restored, err
+}
+
+// writeModel returns the bulk write operation restoring a single document.
+func (mongoWriter *MongoWriter) writeModel(document bson.Raw) mongo.WriteModel {
+
+	if mongoWriter.upsert {
+		if id, err := document.LookupErr("_id"); err == nil {
+			return mongo.NewReplaceOneModel().SetFilter(bson.D{{Key: "_id", Value: id}}).SetReplacement(document).SetUpsert(true)
+		}
+	}
+	return mongo.NewInsertOneModel().SetDocument(document)
+}
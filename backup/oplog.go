@@ -0,0 +1,551 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// oplogFileName is the name of the object holding the oplog entries written during a back-up.
+const oplogFileName = "oplog.bson"
+
+// oplogDirectory is the prefix, under the database prefix, of the captured oplog segments.
+const oplogDirectory = "oplog/"
+
+// Limits of a single applyOps command issued while replaying the oplog.
+const (
+	replayBatchOperations = 1000
+	replayBatchBytes      = 8 * 1024 * 1024
+)
+
+// compareTimestamps returns -1, 0 or 1 if a is respectively before, equal to or after b.
+func compareTimestamps(a primitive.Timestamp, b primitive.Timestamp) int {
+
+	switch {
+	case a.T < b.T || (a.T == b.T && a.I < b.I):
+		return -1
+	case a.T == b.T && a.I == b.I:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// oplogSegment describes an object holding consecutive oplog entries.
+type oplogSegment struct {
+	Key   string
+	First primitive.Timestamp
+	Last  primitive.Timestamp
+	Size  int64
+}
+
+// oplogSegmentName returns the object name of the segment holding the entries from first to last.
+func oplogSegmentName(first primitive.Timestamp, last primitive.Timestamp) string {
+
+	return fmt.Sprintf("%010d-%010d_%010d-%010d.bson", first.T, first.I, last.T, last.I)
+}
+
+// listOplogSegments lists the oplog segments captured under the databasePrefix of the bucket, oldest first.
+func listOplogSegments(ctx context.Context, storage Storage, bucket string, databasePrefix string) ([]oplogSegment, error) {
+
+	var segments []oplogSegment
+	objects, err := storage.List(ctx, bucket, databasePrefix+oplogDirectory, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range objects {
+		segment := oplogSegment{Key: item.Key, Size: item.Size}
+		_, err := fmt.Sscanf(path.Base(item.Key), "%010d-%010d_%010d-%010d.bson", &segment.First.T, &segment.First.I, &segment.Last.T, &segment.Last.I)
+		if item.IsPrefix || err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return compareTimestamps(segments[i].First, segments[j].First) < 0 })
+	return segments, nil
+}
+
+// backupOplogStart returns the oplog position from which the oplog must be replayed over the back-up.
+func backupOplogStart(manifest *BackupManifest, backup BackupInfo) primitive.Timestamp {
+
+	if manifest != nil && manifest.OplogStart != nil {
+		return *manifest.OplogStart
+	}
+	return primitive.Timestamp{T: uint32(backup.Time.Unix())}
+}
+
+// pruneOplogSegments deletes the oplog segments captured before the given back-up, which can no longer be replayed.
+// The segments are only listed if dryRun is set. It returns the number of bytes freed and any error, if occurred.
+func pruneOplogSegments(ctx context.Context, storage Storage, backup BackupInfo, dryRun bool, progress Progress) (int64, error) {
+
+	databasePrefix := strings.TrimSuffix(backup.Prefix, path.Base(backup.Prefix)+"/")
+	segments, err := listOplogSegments(ctx, storage, backup.Bucket, databasePrefix)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+	manifest, err := downloadManifest(ctx, storage, backup.Bucket, backup.Prefix)
+	if err != nil {
+		return 0, fmt.Errorf("could not read the back-up manifest: %w", err)
+	}
+	start := backupOplogStart(manifest, backup)
+
+	var freed int64
+	for _, segment := range segments {
+		if compareTimestamps(segment.Last, start) > 0 {
+			break
+		}
+		deleted := Event{Kind: EventDeleted, Bucket: backup.Bucket, Object: segment.Key, Bytes: segment.Size, Total: segment.Size}
+		if dryRun {
+			deleted.Message = fmt.Sprintf("Would delete %s/%s (%d bytes)", backup.Bucket, segment.Key, segment.Size)
+		} else {
+			if err = storage.Delete(ctx, backup.Bucket, segment.Key); err != nil {
+				return freed, fmt.Errorf("could not delete oplog segment: %w", err)
+			}
+			deleted.Message = fmt.Sprintf("Deleted %s/%s (%d bytes)", backup.Bucket, segment.Key, segment.Size)
+		}
+		progress.report(deleted)
+		freed += segment.Size
+	}
+	return freed, nil
+}
+
+// UploadBackupOplog uploads the oplog entries of the database written since the start timestamp, up to the newest one,
+// as the oplog.bson object of the back-up stored under the uploadFileName prefix.
+// Replaying them over the back-up makes it consistent as of its end.
+// It returns the manifest entry of the uploaded oplog, the timestamp of its newest entry and any error, if occurred.
+func UploadBackupOplog(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, mongoReader *MongoReader, start primitive.Timestamp, uploadOptions UploadOptions) (CollectionManifest, primitive.Timestamp, error) {
+
+	client := mongoReader.database.Client()
+	end, err := oplogBoundary(ctx, client, true)
+	if err != nil {
+		return CollectionManifest{}, end, newError(ErrUpload, err)
+	}
+
+	cursor, err := oplogCollection(client).Find(ctx, oplogFilter(mongoReader.database.Name(), start, end))
+	if err != nil {
+		return CollectionManifest{}, end, errorf(ErrUpload, "could not read the oplog: %w", err)
+	}
+	oplogReader := &CollectionReader{ctx: ctx, cursor: cursor}
+	defer func() { _ = oplogReader.Close() }()
+
+	// The entries written since the start of the back-up must all still be in the oplog.
+	oldest, err := oplogBoundary(ctx, client, false)
+	if err != nil {
+		return CollectionManifest{}, end, newError(ErrUpload, err)
+	}
+	if compareTimestamps(oldest, start) > 0 {
+		return CollectionManifest{}, end, errorf(ErrUpload, "the oplog rolled over during the back-up, its size must be increased to take a consistent back-up")
+	}
+
+	entry := CollectionManifest{Name: "oplog", Object: oplogFileName + uploadOptions.Compression.Extension(), Compression: uploadOptions.Compression.Codec}
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + entry.Object
+	uploadOptions.Progress.report(Event{Kind: EventUploading, Bucket: configStorj.Bucket, Object: objectKey, Message: fmt.Sprintf("Uploading %s to %s...", objectKey, configStorj.Bucket)})
+	if err = uploadBSON(ctx, storage, configStorj.Bucket, objectKey, &contextReader{ctx: ctx, reader: oplogReader}, make([]byte, 1048576), uploadOptions, &entry); err != nil {
+		return entry, end, errorf(ErrUpload, "could not upload the oplog: %w", err)
+	}
+	return entry, end, nil
+}
+
+// oplogCollection returns the oplog of the replica set the client is connected to.
+func oplogCollection(client *mongo.Client) *mongo.Collection {
+
+	return client.Database("local").Collection("oplog.rs")
+}
+
+// oplogBoundary returns the timestamp of the oldest entry of the oplog, or of the newest one if newest is set.
+func oplogBoundary(ctx context.Context, client *mongo.Client, newest bool) (primitive.Timestamp, error) {
+
+	order := 1
+	if newest {
+		order = -1
+	}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "$natural", Value: order}}).SetProjection(bson.D{{Key: "ts", Value: 1}})
+	entry, err := oplogCollection(client).FindOne(ctx, bson.D{}, findOptions).DecodeBytes()
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("oplog unavailable, a replica set is required: %w", err)
+	}
+	t, i, ok := entry.Lookup("ts").TimestampOK()
+	if !ok {
+		return primitive.Timestamp{}, errors.New("oplog entry without timestamp")
+	}
+	return primitive.Timestamp{T: t, I: i}, nil
+}
+
+// OplogTimestamp returns the timestamp of the newest entry of the oplog of the database's replica set.
+func (mongoReader *MongoReader) OplogTimestamp(ctx context.Context) (primitive.Timestamp, error) {
+
+	return oplogBoundary(ctx, mongoReader.database.Client(), true)
+}
+
+// oplogFilter selects the oplog entries of the database written after the given timestamp and, unless until is zero, not after until.
+// Transactions are logged as applyOps commands on the admin database, whose operations are filtered on replay.
+func oplogFilter(database string, after primitive.Timestamp, until primitive.Timestamp) bson.D {
+
+	timestampFilter := bson.D{{Key: "$gt", Value: after}}
+	if !until.IsZero() {
+		timestampFilter = append(timestampFilter, bson.E{Key: "$lte", Value: until})
+	}
+	return bson.D{
+		{Key: "ts", Value: timestampFilter},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "ns", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(database) + `\.`}}},
+			bson.D{{Key: "ns", Value: "admin.$cmd"}},
+		}},
+	}
+}
+
+// CaptureOptions defines how the oplog is captured.
+type CaptureOptions struct {
+	// Interval is the maximum time span of a segment before it is uploaded.
+	Interval time.Duration
+	// SegmentSize is the maximum size in bytes of a segment before it is uploaded.
+	SegmentSize int64
+	// Progress receives the events of the capture, if set.
+	Progress Progress
+}
+
+// CaptureOplog tails the oplog of the replica set of the database described by configMongoDB and uploads its entries
+// as segments under the database prefix of the configured bucket and upload path, next to its back-ups, until ctx is cancelled.
+// The capture resumes after the last captured segment, or else after the start of the latest back-up, or else from now.
+// A segment is uploaded once it spans the interval or reaches the segment size of the options,
+// the pending entries being uploaded once ctx is cancelled.
+func CaptureOplog(ctx context.Context, storage Storage, configStorj ConfigStorj, configMongoDB ConfigMongoDB, captureOptions CaptureOptions) error {
+
+	client, err := dialMongo(ctx, configMongoDB)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Disconnect(context.Background()) }()
+	databasePrefix := configStorj.UploadPath + configMongoDB.Database + "/"
+
+	// Resume after the last captured segment, or else after the start of the latest back-up.
+	segments, err := listOplogSegments(ctx, storage, configStorj.Bucket, databasePrefix)
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	var after primitive.Timestamp
+	if len(segments) > 0 {
+		after = segments[len(segments)-1].Last
+	} else if after, err = latestBackupOplogStart(ctx, storage, configStorj.Bucket, databasePrefix); err != nil {
+		return newError(ErrDownload, err)
+	}
+	if after.IsZero() {
+		if after, err = oplogBoundary(ctx, client, true); err != nil {
+			return newError(ErrConnect, err)
+		}
+	}
+	captureOptions.Progress.infof("Capturing the oplog of %s after %v.\n", configMongoDB.Database, after)
+	return captureOplog(ctx, client, storage, configStorj.Bucket, databasePrefix, configMongoDB.Database, after, captureOptions)
+}
+
+// captureOplog tails the oplog of the replica set for the entries of the database written after the given timestamp
+// and uploads them as segments under the databasePrefix of the bucket, as CaptureOplog does.
+func captureOplog(ctx context.Context, client *mongo.Client, storage Storage, bucket string, databasePrefix string, database string, after primitive.Timestamp, captureOptions CaptureOptions) error {
+
+	var segment bytes.Buffer
+	var first, last primitive.Timestamp
+	segmentStarted := time.Now()
+	progress := captureOptions.Progress
+
+	// Upload the pending entries as a segment.
+	flush := func(ctx context.Context) error {
+		if segment.Len() == 0 {
+			return nil
+		}
+		objectKey := databasePrefix + oplogDirectory + oplogSegmentName(first, last)
+		if err := uploadBytes(ctx, storage, bucket, objectKey, segment.Bytes()); err != nil {
+			return errorf(ErrUpload, "could not upload oplog segment: %w", err)
+		}
+		progress.report(Event{Kind: EventUploaded, Bucket: bucket, Object: objectKey, Bytes: int64(segment.Len()), Total: int64(segment.Len()),
+			Message: fmt.Sprintf("Uploaded %s to %s (%d bytes).", objectKey, bucket, segment.Len())})
+		segment.Reset()
+		segmentStarted = time.Now()
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		// Detect entries lost because the oplog rolled over since the last captured one.
+		oldest, err := oplogBoundary(ctx, client, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return newError(ErrConnect, err)
+		}
+		if compareTimestamps(oldest, after) > 0 {
+			progress.warnf("the oplog rolled over, entries between %v and %v are lost.", after, oldest)
+		}
+
+		findOptions := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(time.Second).SetNoCursorTimeout(true)
+		cursor, err := oplogCollection(client).Find(ctx, oplogFilter(database, after, primitive.Timestamp{}), findOptions)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return errorf(ErrConnect, "could not tail the oplog: %w", err)
+		}
+
+		for ctx.Err() == nil {
+			if cursor.TryNext(ctx) {
+				t, i := cursor.Current.Lookup("ts").Timestamp()
+				last = primitive.Timestamp{T: t, I: i}
+				if segment.Len() == 0 {
+					first = last
+				}
+				segment.Write(cursor.Current)
+				after = last
+			} else if cursor.Err() != nil || cursor.ID() == 0 {
+				// The cursor died, it is reopened from the last captured entry.
+				break
+			}
+			if int64(segment.Len()) >= captureOptions.SegmentSize || (segment.Len() > 0 && time.Since(segmentStarted) >= captureOptions.Interval) {
+				if err = flush(ctx); err != nil {
+					_ = cursor.Close(context.Background())
+					return err
+				}
+			}
+		}
+		if err = cursor.Err(); err != nil && ctx.Err() == nil {
+			progress.warnf("oplog cursor failed, resuming: %s", err)
+		}
+		_ = cursor.Close(context.Background())
+
+		// Wait before reopening the cursor.
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	// The entries captured before the cancellation are still uploaded.
+	return flush(context.Background())
+}
+
+// latestBackupOplogStart returns the oplog position recorded by the latest back-up stored under databasePrefix,
+// or a zero timestamp if there is none.
+func latestBackupOplogStart(ctx context.Context, storage Storage, bucket string, databasePrefix string) (primitive.Timestamp, error) {
+
+	backups, err := ListBackups(ctx, storage, bucket, databasePrefix)
+	if err != nil || len(backups) == 0 {
+		return primitive.Timestamp{}, err
+	}
+	manifest, err := downloadManifest(ctx, storage, bucket, backups[len(backups)-1].Prefix)
+	if err != nil || manifest == nil || manifest.OplogStart == nil {
+		return primitive.Timestamp{}, err
+	}
+	return *manifest.OplogStart, nil
+}
+
+// ReplayOplog applies the oplog entries of the given database read from reader
+// which were written after the after timestamp and, unless until is zero, not after the until timestamp.
+// It returns the number of entries applied and any error, if occurred.
+func (mongoWriter *MongoWriter) ReplayOplog(ctx context.Context, database string, reader io.Reader, after primitive.Timestamp, until primitive.Timestamp) (int, error) {
+
+	var applied, batchSize int
+	var batch bson.A
+	// Apply the pending operations with a single applyOps command.
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		command := bson.D{{Key: "applyOps", Value: batch}}
+		if err := mongoWriter.client.Database("admin").RunCommand(ctx, command).Err(); err != nil {
+			return fmt.Errorf("failed to replay the oplog: %w", err)
+		}
+		batch, batchSize = nil, 0
+		return nil
+	}
+
+	documents := bufio.NewReader(reader)
+	for {
+		entry, err := readDocument(documents, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		t, i, _ := entry.Lookup("ts").TimestampOK()
+		timestamp := primitive.Timestamp{T: t, I: i}
+		if compareTimestamps(timestamp, after) <= 0 {
+			continue
+		}
+		if !until.IsZero() && compareTimestamps(timestamp, until) > 0 {
+			break
+		}
+
+		var document bson.D
+		if err = bson.Unmarshal(entry, &document); err != nil {
+			return applied, err
+		}
+		for _, operation := range mongoWriter.replayableOperations(document, database) {
+			batch = append(batch, operation)
+		}
+		batchSize += len(entry)
+		applied++
+		if len(batch) >= replayBatchOperations || batchSize >= replayBatchBytes {
+			if err = flush(); err != nil {
+				return applied, err
+			}
+		}
+	}
+
+	err := flush()
+	return applied, err
+}
+
+// replayableOperations converts an oplog entry into the operations to apply on the target database.
+// Entries of other databases are dropped and transactions are unpacked into their operations.
+func (mongoWriter *MongoWriter) replayableOperations(entry bson.D, database string) []bson.D {
+
+	var op, ns string
+	var object bson.D
+	for _, element := range entry {
+		switch element.Key {
+		case "op":
+			op, _ = element.Value.(string)
+		case "ns":
+			ns, _ = element.Value.(string)
+		case "o":
+			object, _ = element.Value.(bson.D)
+		}
+	}
+
+	switch {
+	case op == "n":
+		return nil
+	case op == "c" && ns == "admin.$cmd":
+		// Transactions are logged as an applyOps command holding their operations.
+		var operations []bson.D
+		for _, element := range object {
+			if inner, ok := element.Value.(bson.A); ok && element.Key == "applyOps" {
+				for _, innerEntry := range inner {
+					if innerDocument, ok := innerEntry.(bson.D); ok {
+						operations = append(operations, mongoWriter.replayableOperations(innerDocument, database)...)
+					}
+				}
+			}
+		}
+		return operations
+	case !strings.HasPrefix(ns, database+"."):
+		return nil
+	}
+
+	target := database
+	if mongoWriter.database != "" {
+		target = mongoWriter.database
+	}
+	var operation bson.D
+	for _, element := range entry {
+		switch element.Key {
+		case "ui":
+			// Collection UUIDs differ on the restored instance.
+			continue
+		case "ns":
+			element.Value = target + ns[len(database):]
+		case "o":
+			for i, field := range object {
+				// Commands like renameCollection hold full namespaces.
+				if name, ok := field.Value.(string); ok && op == "c" && (field.Key == "renameCollection" || field.Key == "to") && strings.HasPrefix(name, database+".") {
+					object[i].Value = target + name[len(database):]
+				}
+			}
+			element.Value = object
+		}
+		operation = append(operation, element)
+	}
+	return []bson.D{operation}
+}
+
+// RestoreUntil restores the database stored at backupPath, in the format bucket/uploadPath/db, as it was at the given time:
+// the latest complete back-up taken before that time is restored and the captured oplog is replayed up to it.
+// The back-up can only be restored into MongoDB, with the MongoWriter of the restore options.
+func RestoreUntil(ctx context.Context, storage Storage, backupPath string, until time.Time, restoreOptions RestoreOptions) error {
+
+	if restoreOptions.MongoWriter == nil {
+		return errorf(ErrConfig, "the oplog can only be replayed into a MongoDB instance")
+	}
+	backupPath = strings.TrimSuffix(backupPath, "/")
+	keys := strings.Split(backupPath, "/")
+	if len(keys) < 2 {
+		return errorf(ErrConfig, "invalid back-up path %s", backupPath)
+	}
+	bucket, databasePrefix := keys[0], backupPath[len(keys[0])+1:]+"/"
+	progress := restoreOptions.Progress
+
+	backups, err := ListBackups(ctx, storage, bucket, databasePrefix)
+	if err != nil {
+		return err
+	}
+	// The back-ups which failed or are still running cannot be the base of the recovery.
+	taken := 0
+	for taken < len(backups) && !backups[taken].Time.After(until) {
+		taken++
+	}
+	backup, _, err := latestCompleteBackup(ctx, storage, backups[:taken])
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	if backup == nil {
+		return errorf(ErrNoBackup, "no complete back-up of %s taken before %s", backupPath, until.Format(BackupTimeFormat))
+	}
+
+	progress.infof("Restoring the backup of %s/%s...", bucket, backup.Prefix)
+	untilTimestamp := primitive.Timestamp{T: uint32(until.Unix()), I: ^uint32(0)}
+	manifest, err := restoreBackup(ctx, storage, bucket, backup.Prefix, restoreOptions, untilTimestamp)
+	if err != nil {
+		return err
+	}
+
+	// Replay the oplog from the start of the back-up, as it may be smeared over its whole duration,
+	// or from its end if the oplog written during the back-up was already replayed.
+	after := backupOplogStart(manifest, *backup)
+	if manifest != nil && manifest.OplogEnd != nil {
+		after = *manifest.OplogEnd
+		if compareTimestamps(after, untilTimestamp) > 0 {
+			progress.warnf("the back-up finished after the requested time, it may hold later writes.")
+		}
+	}
+
+	segments, err := listOplogSegments(ctx, storage, bucket, databasePrefix)
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	var replayed int
+	covered := after
+	for _, segment := range segments {
+		if compareTimestamps(segment.Last, after) <= 0 || compareTimestamps(segment.First, untilTimestamp) > 0 {
+			continue
+		}
+		download, _, err := storage.Get(ctx, bucket, segment.Key)
+		if err != nil {
+			return newError(ErrDownload, err)
+		}
+		count, err := restoreOptions.MongoWriter.ReplayOplog(ctx, backup.Database, &contextReader{ctx: ctx, reader: download}, after, untilTimestamp)
+		_ = download.Close()
+		if err != nil {
+			return errorf(ErrRestore, "failed to replay %s: %w", segment.Key, err)
+		}
+		replayed += count
+		covered = segment.Last
+	}
+	if compareTimestamps(covered, primitive.Timestamp{T: uint32(until.Unix())}) < 0 {
+		progress.warnf("the captured oplog ends at %s, before the requested time.", time.Unix(int64(covered.T), 0).Format(BackupTimeFormat))
+	}
+	progress.infof("\nReplayed %d oplog entries, database restored as of %s.", replayed, until.Format(BackupTimeFormat))
+	return nil
+}
@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// collectionBufferSize is the size of the buffer through which the collections are uploaded,
+// shared by the workers of a parallel upload so that memory stays bounded whatever their number.
+const collectionBufferSize = 10485760
+
+// minWorkerBufferSize is the smallest buffer of a worker of a parallel upload.
+const minWorkerBufferSize = 1048576
+
+// contextReader fails reading once its context is cancelled, aborting the upload it feeds.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read reads from the underlying reader unless the context is cancelled.
+func (contextReader *contextReader) Read(buf []byte) (int, error) {
+
+	if err := contextReader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return contextReader.reader.Read(buf)
+}
+
+// runParallel runs task for each index from 0 to count-1, workers at a time, and calls report for each index in order,
+// once its task and the tasks of the previous indexes are done.
+// After a task fails, the tasks in flight are cancelled through their context and no other task is started,
+// those being reported with context.Canceled, as are the tasks not started once the parent ctx is cancelled.
+// It returns the first error other than a cancellation, in order, or the error of the parent ctx.
+func runParallel(parent context.Context, count int, workers int, task func(ctx context.Context, i int) error, report func(i int, err error)) error {
+
+	if workers > count {
+		workers = count
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	errs := make([]error, count)
+	done := make([]chan struct{}, count)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// Hand out the tasks in order until all are started or one fails.
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < count; i++ {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				for ; i < count; i++ {
+					errs[i] = ctx.Err()
+					close(done[i])
+				}
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if errs[i] = task(ctx, i); errs[i] != nil {
+					if ctx.Err() != nil {
+						// The task was aborted because another one failed.
+						errs[i] = ctx.Err()
+					}
+					cancel()
+				}
+				close(done[i])
+			}
+		}()
+	}
+
+	// Report the tasks in order, keeping the error which caused the others to be cancelled.
+	var failure error
+	for i := range done {
+		<-done[i]
+		report(i, errs[i])
+		if errs[i] != nil && errs[i] != context.Canceled && failure == nil {
+			failure = errs[i]
+		}
+	}
+	wg.Wait()
+	if failure == nil {
+		failure = parent.Err()
+	}
+	return failure
+}
+
+// uploadCollectionsParallel uploads the collections of opener as uploadCollections does, uploadOptions.Parallel at a time,
+// each read through a cursor and uploaded through an upload of its own.
+// The progress is reported in the order of the collections. If any collection fails, the uploads in flight are aborted,
+// no other collection is started and the first error is returned.
+func uploadCollectionsParallel(parent context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, opener CollectionOpener, uploadOptions UploadOptions) ([]CollectionManifest, error) {
+
+	names := opener.CollectionNames()
+	exporter, _ := opener.(MetadataExporter)
+	// A checkpointed back-up is uploaded here one collection at a time unless Parallel is set.
+	workers := uploadOptions.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+	bufferSize := collectionBufferSize / workers
+	if bufferSize < minWorkerBufferSize {
+		bufferSize = minWorkerBufferSize
+	}
+	// Each worker reuses the buffer of its previous upload.
+	buffers := make(chan []byte, workers)
+	for i := 0; i < workers && i < len(names); i++ {
+		buffers <- make([]byte, bufferSize)
+	}
+
+	entries := make([]CollectionManifest, len(names))
+	var uploaded []CollectionManifest
+	err := runParallel(parent, len(names), workers, func(ctx context.Context, i int) error {
+		buf := <-buffers
+		defer func() { buffers <- buf }()
+		var err error
+		entries[i], err = uploadOpenedCollection(ctx, storage, configStorj, uploadFileName, opener, names[i], exporter, buf, uploadOptions)
+		return err
+	}, func(i int, err error) {
+		switch {
+		case err == nil:
+			reportUploaded(uploadOptions.Progress, configStorj, uploadFileName, entries[i])
+			uploaded = append(uploaded, entries[i])
+		case err == context.Canceled:
+			uploadOptions.Progress.report(Event{Kind: EventFailed, Bucket: configStorj.Bucket, Collection: names[i], Err: err, Message: fmt.Sprintf("Aborted upload of %s collection.", names[i])})
+		default:
+			uploadOptions.Progress.report(Event{Kind: EventFailed, Bucket: configStorj.Bucket, Collection: names[i], Err: err, Message: fmt.Sprintf("Failed upload of %s collection: %s", names[i], err)})
+		}
+	})
+	return uploaded, err
+}
+
+// uploadOpenedCollection opens the collection and uploads it as uploadCollection does, until ctx is cancelled.
+// It is uploaded as uploadResumableCollection does if the back-up has a checkpoint.
+func uploadOpenedCollection(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, opener CollectionOpener, name string, exporter MetadataExporter, buf []byte, uploadOptions UploadOptions) (CollectionManifest, error) {
+
+	if err := ctx.Err(); err != nil {
+		return CollectionManifest{}, err
+	}
+	if resumable, ok := opener.(ResumableOpener); ok && uploadOptions.checkpoint != nil {
+		return uploadResumableCollection(ctx, storage, configStorj, uploadFileName, resumable, name, exporter, buf, uploadOptions)
+	}
+	reader, err := opener.OpenCollection(name)
+	if err != nil {
+		return CollectionManifest{}, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	return uploadCollection(ctx, storage, configStorj, uploadFileName, name, &contextReader{ctx: ctx, reader: reader}, exporter, buf, uploadOptions)
+}
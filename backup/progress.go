@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+)
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+// Kinds of the events reported to a Progress callback.
+const (
+	// EventInfo reports the progress of an operation, described by the message of the event.
+	EventInfo EventKind = iota
+	// EventWarning reports a problem which does not stop the operation.
+	EventWarning
+	// EventUploading reports that an object is being uploaded.
+	EventUploading
+	// EventUploaded reports that an object was uploaded, along with its number of documents and size.
+	EventUploaded
+	// EventDownloading reports the number of bytes of an object downloaded so far, along with its size.
+	// It is first reported with zero bytes when the download starts.
+	EventDownloading
+	// EventRestored reports that an object was restored, along with its number of documents if restored into MongoDB.
+	EventRestored
+	// EventVerified reports that an object was verified, along with its number of documents and the problems found, if any.
+	EventVerified
+	// EventDeleted reports that a back-up or an oplog segment was deleted, or would be on a dry run, along with its size.
+	EventDeleted
+	// EventFailed reports that the transfer of an object failed or was aborted, along with the error.
+	EventFailed
+	// EventCopied reports that an object was copied to another storage, along with its size.
+	EventCopied
+	// EventSkipped reports that an object was not copied, being already present in the other storage, along with its size.
+	EventSkipped
+)
+
+// Event describes the progress of an operation.
+type Event struct {
+	Kind EventKind
+	// Bucket and Object identify the object the event is about, if any.
+	Bucket string
+	Object string
+	// Collection is the name of the collection the event is about, if any.
+	Collection string
+	// Documents and Bytes are the number of documents and bytes transferred, Total the size of the object.
+	Documents int64
+	Bytes     int64
+	Total     int64
+	// Err is the error of a failed transfer, or the problems found by a failed verification.
+	Err error
+	// Message describes the event, for display.
+	Message string
+}
+
+// Progress receives the events of an operation. It may be called concurrently by parallel transfers.
+type Progress func(Event)
+
+// report passes the event to the callback, if set.
+func (progress Progress) report(event Event) {
+
+	if progress != nil {
+		progress(event)
+	}
+}
+
+// infof reports an informational event with the formatted message.
+func (progress Progress) infof(format string, args ...interface{}) {
+
+	progress.report(Event{Kind: EventInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+// warnf reports a warning with the formatted message.
+func (progress Progress) warnf(format string, args ...interface{}) {
+
+	progress.report(Event{Kind: EventWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// progressReader reports the bytes read from the download of an object, with EventDownloading events.
+type progressReader struct {
+	reader   io.Reader
+	event    Event
+	progress Progress
+}
+
+// Read reads from the underlying reader, reporting the number of bytes read so far.
+func (progressReader *progressReader) Read(buf []byte) (int, error) {
+
+	n, err := progressReader.reader.Read(buf)
+	if n > 0 {
+		progressReader.event.Bytes += int64(n)
+		progressReader.progress.report(progressReader.event)
+	}
+	return n, err
+}
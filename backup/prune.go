@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy defines which back-ups of a database are kept when pruning.
+type RetentionPolicy struct {
+	// KeepLast keeps the given number of most recent back-ups.
+	KeepLast int
+	// KeepDaily keeps the most recent back-up of each day, for the given number of days.
+	KeepDaily int
+	// KeepWeekly keeps the most recent back-up of each week, for the given number of weeks.
+	KeepWeekly int
+	// KeepMonthly keeps the most recent back-up of each month, for the given number of months.
+	KeepMonthly int
+}
+
+// IsEmpty reports whether the policy retains nothing at all.
+func (policy RetentionPolicy) IsEmpty() bool {
+
+	return policy.KeepLast <= 0 && policy.KeepDaily <= 0 && policy.KeepWeekly <= 0 && policy.KeepMonthly <= 0
+}
+
+// Expired returns the back-ups, sorted oldest first, which are not retained by the policy at the given time.
+func (policy RetentionPolicy) Expired(backups []BackupInfo, now time.Time) []BackupInfo {
+
+	keep := make([]bool, len(backups))
+	for i := len(backups) - 1; i >= 0 && len(backups)-i <= policy.KeepLast; i-- {
+		keep[i] = true
+	}
+	if policy.KeepDaily > 0 {
+		keepNewestPerPeriod(backups, keep, now.AddDate(0, 0, -policy.KeepDaily), func(t time.Time) string {
+			return t.Format("2006-01-02")
+		})
+	}
+	if policy.KeepWeekly > 0 {
+		keepNewestPerPeriod(backups, keep, now.AddDate(0, 0, -7*policy.KeepWeekly), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		})
+	}
+	if policy.KeepMonthly > 0 {
+		keepNewestPerPeriod(backups, keep, now.AddDate(0, -policy.KeepMonthly, 0), func(t time.Time) string {
+			return t.Format("2006-01")
+		})
+	}
+
+	var expired []BackupInfo
+	for i, backup := range backups {
+		if !keep[i] {
+			expired = append(expired, backup)
+		}
+	}
+	return expired
+}
+
+// keepNewestPerPeriod marks the most recent back-up of each period, among the back-ups taken after since.
+// The back-ups must be sorted oldest first.
+func keepNewestPerPeriod(backups []BackupInfo, keep []bool, since time.Time, period func(time.Time) string) {
+
+	seen := make(map[string]bool)
+	for i := len(backups) - 1; i >= 0 && backups[i].Time.After(since); i-- {
+		if key := period(backups[i].Time); !seen[key] {
+			seen[key] = true
+			keep[i] = true
+		}
+	}
+}
+
+// Prune deletes the back-ups of the database stored at backupPath, in the format bucket/uploadPath/db,
+// which are not retained by the policy, along with the oplog segments captured before the oldest retained back-up.
+// Only the complete back-ups are retained by the policy, the latest incomplete back-up being kept as it may still be running.
+// The back-ups are only listed if dryRun is set. It returns the number of bytes freed and any error, if occurred.
+func Prune(ctx context.Context, storage Storage, backupPath string, policy RetentionPolicy, dryRun bool, progress Progress) (int64, error) {
+
+	backupPath = strings.TrimSuffix(backupPath, "/")
+	keys := strings.Split(backupPath, "/")
+	if len(keys) < 2 {
+		return 0, errorf(ErrConfig, "invalid back-up path %s", backupPath)
+	}
+	if policy.IsEmpty() {
+		return 0, errorf(ErrConfig, "no retention policy given, refusing to delete every back-up")
+	}
+
+	backups, err := ListBackups(ctx, storage, keys[0], backupPath[len(keys[0])+1:]+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the complete back-ups count toward the retention. The incomplete ones failed, and expire,
+	// except for the latest one, which may still be running.
+	complete, incomplete, err := splitCompleteBackups(ctx, storage, backups)
+	if err != nil {
+		return 0, newError(ErrDownload, err)
+	}
+	expired := policy.Expired(complete, time.Now())
+	if len(incomplete) > 1 {
+		expired = append(expired, incomplete[:len(incomplete)-1]...)
+		sort.Slice(expired, func(i, j int) bool { return expired[i].Time.Before(expired[j].Time) })
+	}
+	if expired, err = retainIncrementalChains(ctx, storage, backups, expired); err != nil {
+		return 0, newError(ErrDownload, err)
+	}
+	var freed int64
+	for _, backup := range expired {
+		deleted := Event{Kind: EventDeleted, Bucket: backup.Bucket, Object: backup.Prefix, Bytes: backup.Size, Total: backup.Size}
+		if dryRun {
+			deleted.Message = fmt.Sprintf("Would delete %s/%s (%d bytes)", backup.Bucket, backup.Prefix, backup.Size)
+		} else {
+			if err = deleteBackup(ctx, storage, backup); err != nil {
+				return freed, newError(ErrDelete, err)
+			}
+			deleted.Message = fmt.Sprintf("Deleted %s/%s (%d bytes)", backup.Bucket, backup.Prefix, backup.Size)
+		}
+		progress.report(deleted)
+		freed += backup.Size
+	}
+
+	// The oplog captured before the oldest retained complete back-up can no longer be replayed.
+	expiredPrefixes := make(map[string]bool)
+	for _, backup := range expired {
+		expiredPrefixes[backup.Prefix] = true
+	}
+	for _, backup := range complete {
+		if !expiredPrefixes[backup.Prefix] {
+			segmentsFreed, err := pruneOplogSegments(ctx, storage, backup, dryRun, progress)
+			freed += segmentsFreed
+			if err != nil {
+				return freed, newError(ErrDelete, err)
+			}
+			break
+		}
+	}
+
+	if dryRun {
+		progress.infof("%s: %d of %d back-ups would be deleted, freeing %d bytes.", backupPath, len(expired), len(backups), freed)
+	} else {
+		progress.infof("%s: %d of %d back-ups deleted, freeing %d bytes.", backupPath, len(expired), len(backups), freed)
+	}
+	return freed, nil
+}
+
+// splitCompleteBackups separates the back-ups, sorted oldest first, whose manifest marks them as complete from the others,
+// which failed or are still running.
+func splitCompleteBackups(ctx context.Context, storage Storage, backups []BackupInfo) ([]BackupInfo, []BackupInfo, error) {
+
+	var complete, incomplete []BackupInfo
+	for _, backup := range backups {
+		var manifest *BackupManifest
+		if containsObject(backup, manifestFileName) {
+			var err error
+			if manifest, err = downloadManifest(ctx, storage, backup.Bucket, backup.Prefix); err != nil {
+				return nil, nil, err
+			}
+		}
+		if manifest != nil && !manifest.FinishedAt.IsZero() {
+			complete = append(complete, backup)
+		} else {
+			incomplete = append(incomplete, backup)
+		}
+	}
+	return complete, incomplete, nil
+}
+
+// retainIncrementalChains removes from the expired back-ups those which a retained incremental back-up is based on,
+// as it could no longer be restored without them. The back-ups must be sorted oldest first.
+func retainIncrementalChains(ctx context.Context, storage Storage, backups []BackupInfo, expired []BackupInfo) ([]BackupInfo, error) {
+
+	isExpired := make(map[string]bool)
+	for _, backup := range expired {
+		isExpired[backup.Prefix] = true
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+		if isExpired[backup.Prefix] || !containsObject(backup, changesFileName) {
+			continue
+		}
+		manifest, err := downloadManifest(ctx, storage, backup.Bucket, backup.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil && manifest.Parent != "" {
+			isExpired[manifest.Parent] = false
+		}
+	}
+
+	var remaining []BackupInfo
+	for _, backup := range expired {
+		if isExpired[backup.Prefix] {
+			remaining = append(remaining, backup)
+		}
+	}
+	return remaining, nil
+}
+
+// containsObject reports whether the back-up holds an object with the given name.
+func containsObject(backup BackupInfo, objectName string) bool {
+
+	for _, object := range backup.Objects {
+		if object.Key == backup.Prefix+objectName {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBackup deletes every object of the back-up.
+// The manifest is deleted first, so that an interrupted deletion leaves the back-up marked as incomplete.
+func deleteBackup(ctx context.Context, storage Storage, backup BackupInfo) error {
+
+	objects := append([]*ObjectInfo(nil), backup.Objects...)
+	for i, object := range objects {
+		if path.Base(object.Key) == manifestFileName {
+			objects[0], objects[i] = objects[i], objects[0]
+			break
+		}
+	}
+	for _, object := range objects {
+		if err := storage.Delete(ctx, backup.Bucket, object.Key); err != nil {
+			return fmt.Errorf("could not delete back-up object: %w", err)
+		}
+	}
+	return nil
+}
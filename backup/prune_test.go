@@ -0,0 +1,77 @@
+package backup_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+func TestRetentionPolicyExpired(t *testing.T) {
+
+	now := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.UTC)
+	var backups []backup.BackupInfo
+	// One back-up every 12 hours over the last 90 days, oldest first.
+	for hours := 90 * 24; hours > 0; hours -= 12 {
+		backups = append(backups, backup.BackupInfo{Time: now.Add(-time.Duration(hours) * time.Hour)})
+	}
+
+	tests := []struct {
+		name   string
+		policy backup.RetentionPolicy
+		kept   int
+	}{
+		{"last", backup.RetentionPolicy{KeepLast: 3}, 3},
+		{"daily", backup.RetentionPolicy{KeepDaily: 7}, 7},
+		{"weekly", backup.RetentionPolicy{KeepWeekly: 4}, 5},
+		{"monthly", backup.RetentionPolicy{KeepMonthly: 2}, 2},
+		{"combined", backup.RetentionPolicy{KeepLast: 3, KeepDaily: 2}, 3},
+		{"everything", backup.RetentionPolicy{KeepLast: 1000}, len(backups)},
+	}
+
+	for _, test := range tests {
+		expired := test.policy.Expired(backups, now)
+		if kept := len(backups) - len(expired); kept != test.kept {
+			t.Errorf("%s: kept %d back-ups, expected %d", test.name, kept, test.kept)
+		}
+		for _, backup := range expired {
+			if backup.Time.Equal(backups[len(backups)-1].Time) {
+				t.Errorf("%s: the latest back-up expired", test.name)
+			}
+		}
+	}
+}
+
+func TestPruneCountsCompleteBackups(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "backups/")
+	defer cleanup()
+
+	documents := bsonDocuments(t, 10)
+	first := time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local)
+	storeBackup(t, storage, storjConfig, "inventory", first, documents, backup.UploadOptions{}, "")
+	kept := []string{storeBackup(t, storage, storjConfig, "inventory", first.Add(time.Hour), documents, backup.UploadOptions{}, "")}
+	// A back-up which failed, then the latest complete back-up and a back-up still running.
+	storeIncompleteBackup(t, storage, storjConfig, "inventory", first.Add(90*time.Minute))
+	kept = append(kept, storeBackup(t, storage, storjConfig, "inventory", first.Add(2*time.Hour), documents, backup.UploadOptions{}, ""))
+	kept = append(kept, storeIncompleteBackup(t, storage, storjConfig, "inventory", first.Add(3*time.Hour)))
+
+	if _, err := backup.Prune(ctx, storage, "bucket/backups/inventory", backup.RetentionPolicy{KeepLast: 2}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := backup.ListBackups(ctx, storage, "bucket", "backups/inventory/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []string
+	for _, backup := range backups {
+		prefixes = append(prefixes, strings.TrimSuffix(strings.TrimPrefix(backup.Prefix, "backups/"), "/"))
+	}
+	if !reflect.DeepEqual(prefixes, kept) {
+		t.Errorf("kept %v instead of %v", prefixes, kept)
+	}
+}
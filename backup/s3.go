@@ -0,0 +1,334 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/credentials"
+)
+
+const (
+	// s3DefaultRegion is the region used when the configuration sets none, accepted by MinIO and the Storj gateway.
+	s3DefaultRegion = "us-east-1"
+	// s3PartSize is the size of the parts an object of unknown size is streamed in, limiting it to 10000 parts.
+	s3PartSize = 16777216
+)
+
+// s3Storage stores the objects on an S3-compatible service, such as MinIO or the Storj gateway.
+type s3Storage struct {
+	client *minio.Client
+	core   minio.Core
+	region string
+}
+
+// NewS3Storage returns the storage of the back-ups on the S3-compatible service described by the Storj configuration.
+func NewS3Storage(configStorj ConfigStorj) (MultipartStorage, error) {
+
+	if configStorj.Endpoint == "" {
+		return nil, errorf(ErrConfig, "the endpoint of the s3 backend is not set")
+	}
+	if configStorj.AccessKeyID == "" || configStorj.SecretAccessKey == "" {
+		return nil, errorf(ErrConfig, "the access key ID and the secret access key of the s3 backend are required")
+	}
+	endpoint := configStorj.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" || strings.Trim(endpointURL.Path, "/") != "" {
+		return nil, errorf(ErrConfig, "invalid endpoint %q of the s3 backend, expected a host with an optional scheme and port", configStorj.Endpoint)
+	}
+
+	region := configStorj.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	lookup := minio.BucketLookupPath
+	if configStorj.VirtualHostedStyle {
+		lookup = minio.BucketLookupDNS
+	}
+	client, err := minio.NewWithOptions(endpointURL.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(configStorj.AccessKeyID, configStorj.SecretAccessKey, ""),
+		Secure:       endpointURL.Scheme == "https",
+		Region:       region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, newError(ErrConfig, err)
+	}
+	return &s3Storage{client: client, core: minio.Core{Client: client}, region: region}, nil
+}
+
+// s3Error wraps the error of the service about the key, matching ErrObjectNotFound when the object does not exist.
+func s3Error(key string, err error) error {
+
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NotFound" {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return fmt.Errorf("%s: %w", key, err)
+}
+
+// s3ObjectInfo describes an object as returned by the service, the names of its custom metadata in lower case.
+func s3ObjectInfo(info minio.ObjectInfo) *ObjectInfo {
+
+	object := &ObjectInfo{Key: info.Key, Size: info.Size, Modified: info.LastModified}
+	for name, value := range info.UserMetadata {
+		if object.Metadata == nil {
+			object.Metadata = map[string]string{}
+		}
+		object.Metadata[strings.ToLower(name)] = value
+	}
+	return object
+}
+
+// Put starts the upload of an object, streamed to the service in parts of a multipart upload as it is written.
+func (storage *s3Storage) Put(ctx context.Context, bucket string, key string, metadata map[string]string) (ObjectWriter, error) {
+
+	reader, writer := io.Pipe()
+	upload := &s3Upload{writer: writer, done: make(chan struct{})}
+	go func() {
+		defer close(upload.done)
+		upload.size, upload.err = storage.client.PutObjectWithContext(ctx, bucket, key, reader, -1,
+			minio.PutObjectOptions{UserMetadata: metadata, PartSize: s3PartSize})
+		_ = reader.CloseWithError(upload.err)
+	}()
+	return upload, nil
+}
+
+// errUploadAborted aborts the upload of an object to an S3-compatible service.
+var errUploadAborted = errors.New("upload aborted")
+
+// s3Upload uploads an object to an S3-compatible service.
+type s3Upload struct {
+	writer *io.PipeWriter
+	// done is closed once the upload is over, its size and error set.
+	done chan struct{}
+	size int64
+	err  error
+}
+
+// Write streams the data to the upload.
+func (upload *s3Upload) Write(data []byte) (int, error) {
+
+	return upload.writer.Write(data)
+}
+
+// Commit ends the stream and waits for the upload to complete.
+func (upload *s3Upload) Commit() (int64, error) {
+
+	_ = upload.writer.Close()
+	<-upload.done
+	return upload.size, upload.err
+}
+
+// Abort fails the stream, the multipart upload begun being aborted.
+func (upload *s3Upload) Abort() error {
+
+	_ = upload.writer.CloseWithError(errUploadAborted)
+	<-upload.done
+	return nil
+}
+
+// Get downloads the object.
+func (storage *s3Storage) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, *ObjectInfo, error) {
+
+	reader, info, _, err := storage.core.GetObjectWithContext(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(key, err)
+	}
+	info.Key = key
+	return reader, s3ObjectInfo(info), nil
+}
+
+// Stat requests the description of the object.
+func (storage *s3Storage) Stat(ctx context.Context, bucket string, key string) (*ObjectInfo, error) {
+
+	info, err := storage.client.StatObjectWithContext(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(key, err)
+	}
+	info.Key = key
+	return s3ObjectInfo(info), nil
+}
+
+// List lists the objects of the bucket. The listings of S3 carry no custom metadata, which Get and Stat return.
+func (storage *s3Storage) List(ctx context.Context, bucket string, prefix string, recursive bool) ([]*ObjectInfo, error) {
+
+	done := make(chan struct{})
+	defer close(done)
+	var objects []*ObjectInfo
+	for info := range storage.client.ListObjectsV2(bucket, prefix, recursive, done) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		object := s3ObjectInfo(info)
+		object.IsPrefix = strings.HasSuffix(info.Key, "/")
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// Delete deletes the object, the service ignoring any missing one.
+func (storage *s3Storage) Delete(ctx context.Context, bucket string, key string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return storage.client.RemoveObject(bucket, key)
+}
+
+// EnsureBucket creates the bucket in the region of the configuration, unless it exists.
+func (storage *s3Storage) EnsureBucket(ctx context.Context, bucket string) error {
+
+	exists, err := storage.client.BucketExistsWithContext(ctx, bucket)
+	if err != nil || exists {
+		return err
+	}
+	err = storage.client.MakeBucketWithContext(ctx, bucket, storage.region)
+	if code := minio.ToErrorResponse(err).Code; code == "BucketAlreadyOwnedByYou" {
+		return nil
+	}
+	return err
+}
+
+// Close does nothing, the client keeping its idle connections for the other clients of its transport.
+func (storage *s3Storage) Close() error {
+
+	return nil
+}
+
+// BeginUpload creates a multipart upload, which records the custom metadata.
+func (storage *s3Storage) BeginUpload(ctx context.Context, bucket string, key string, metadata map[string]string) (string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return storage.core.NewMultipartUpload(bucket, key, minio.PutObjectOptions{UserMetadata: metadata})
+}
+
+// PutPart starts the upload of a part, spooled to a temporary file until it is committed,
+// the service requiring the size of a part before its data.
+func (storage *s3Storage) PutPart(ctx context.Context, bucket string, key string, uploadID string, number int) (PartWriter, error) {
+
+	file, err := ioutil.TempFile("", "connector-mongodb-part")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Part{ctx: ctx, storage: storage, bucket: bucket, key: key, uploadID: uploadID, number: number, file: file}, nil
+}
+
+// s3Part uploads a part of a multipart upload to an S3-compatible service.
+type s3Part struct {
+	ctx      context.Context
+	storage  *s3Storage
+	bucket   string
+	key      string
+	uploadID string
+	number   int
+	file     *os.File
+	size     int64
+}
+
+// Write writes the data to the temporary file.
+func (part *s3Part) Write(data []byte) (int, error) {
+
+	n, err := part.file.Write(data)
+	part.size += int64(n)
+	return n, err
+}
+
+// Commit uploads the part from the temporary file, which it removes.
+func (part *s3Part) Commit() error {
+
+	defer func() { _ = part.Abort() }()
+	if _, err := part.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := part.storage.core.PutObjectPartWithContext(part.ctx, part.bucket, part.key, part.uploadID, part.number, part.file, part.size, "", "", nil)
+	return err
+}
+
+// Abort removes the temporary file.
+func (part *s3Part) Abort() error {
+
+	_ = part.file.Close()
+	if err := os.Remove(part.file.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// listParts lists the parts uploaded page by page.
+func (storage *s3Storage) listParts(bucket string, key string, uploadID string) ([]minio.CompletePart, error) {
+
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		result, err := storage.core.ListObjectParts(bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker == 0 {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// ListParts lists the numbers of the parts uploaded.
+func (storage *s3Storage) ListParts(ctx context.Context, bucket string, key string, uploadID string) ([]int, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	parts, err := storage.listParts(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		numbers[i] = part.PartNumber
+	}
+	return numbers, nil
+}
+
+// CommitUpload completes the multipart upload with the parts listed by the service.
+// The custom metadata was recorded as the upload began.
+func (storage *s3Storage) CommitUpload(ctx context.Context, bucket string, key string, uploadID string, metadata map[string]string) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	parts, err := storage.listParts(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%s: no part uploaded", key)
+	}
+	_, err = storage.core.CompleteMultipartUploadWithContext(ctx, bucket, key, uploadID, parts)
+	return err
+}
+
+// AbortUpload aborts the multipart upload, deleting its parts.
+func (storage *s3Storage) AbortUpload(ctx context.Context, bucket string, key string, uploadID string) error {
+
+	return storage.core.AbortMultipartUploadWithContext(ctx, bucket, key, uploadID)
+}
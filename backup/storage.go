@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Backends of the back-ups, selected by the backend field of the Storj configuration.
+const (
+	// BackendStorj stores the back-ups on the Storj network, the default.
+	BackendStorj = "storj"
+	// BackendLocal stores the back-ups in a local directory, holding a directory per bucket.
+	BackendLocal = "local"
+	// BackendS3 stores the back-ups on an S3-compatible service, such as MinIO or the Storj gateway.
+	BackendS3 = "s3"
+)
+
+// ErrObjectNotFound is matched by the errors of the storages for an object which does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes an object stored in a storage, or a prefix shared by several objects.
+type ObjectInfo struct {
+	Key string
+	// IsPrefix is set for the prefixes listed without recursion, ending with a slash, which are not objects.
+	IsPrefix bool
+	// Size is the size of the object in bytes.
+	Size     int64
+	Modified time.Time
+	// Metadata is the custom metadata the object was uploaded with.
+	Metadata map[string]string
+}
+
+// Storage stores the objects of the back-ups in buckets, under keys whose segments are separated by slashes.
+// Its methods may be called concurrently.
+type Storage interface {
+	// Put starts the upload of an object under the key, along with its custom metadata.
+	// The object is only stored, replacing any object of the same key, once the writer is committed.
+	Put(ctx context.Context, bucket string, key string, metadata map[string]string) (ObjectWriter, error)
+	// Get opens the object stored under the key for reading, along with its description.
+	// The reader must be closed.
+	Get(ctx context.Context, bucket string, key string) (io.ReadCloser, *ObjectInfo, error)
+	// List lists the objects whose key starts with the prefix, which is either empty or ends with a slash.
+	// Unless recursive, the objects under a further slash are only listed once, as a prefix ending with that slash.
+	List(ctx context.Context, bucket string, prefix string, recursive bool) ([]*ObjectInfo, error)
+	// Delete deletes the object stored under the key, if any.
+	Delete(ctx context.Context, bucket string, key string) error
+	// Stat returns the description of the object stored under the key.
+	Stat(ctx context.Context, bucket string, key string) (*ObjectInfo, error)
+	// EnsureBucket creates the bucket, unless it exists.
+	EnsureBucket(ctx context.Context, bucket string) error
+	// Close releases the resources of the storage.
+	Close() error
+}
+
+// ObjectWriter uploads an object to a storage.
+type ObjectWriter interface {
+	io.Writer
+	// Commit stores the object written, returning its size.
+	Commit() (int64, error)
+	// Abort discards the object written.
+	Abort() error
+}
+
+// MultipartStorage is implemented by the storages able to upload an object in parts, over several runs,
+// which resumable back-ups require.
+type MultipartStorage interface {
+	Storage
+	// BeginUpload starts a multipart upload of an object under the key, returning its ID.
+	// The custom metadata is given both when the upload begins and when it is committed, each storage recording it at either.
+	BeginUpload(ctx context.Context, bucket string, key string, metadata map[string]string) (string, error)
+	// PutPart starts the upload of the part of the given number, from 1, replacing any part of the same number once committed.
+	PutPart(ctx context.Context, bucket string, key string, uploadID string, number int) (PartWriter, error)
+	// ListParts returns the numbers of the parts committed, in order.
+	ListParts(ctx context.Context, bucket string, key string, uploadID string) ([]int, error)
+	// CommitUpload stores the object made of the parts committed, in the order of their numbers.
+	CommitUpload(ctx context.Context, bucket string, key string, uploadID string, metadata map[string]string) error
+	// AbortUpload discards the upload and its parts.
+	AbortUpload(ctx context.Context, bucket string, key string, uploadID string) error
+}
+
+// PartWriter uploads a part of a multipart upload.
+type PartWriter interface {
+	io.Writer
+	// Commit stores the part written.
+	Commit() error
+	// Abort discards the part written.
+	Abort() error
+}
+
+// Open opens the storage of the back-ups described by the Storj configuration, as selected by its backend,
+// and ensures its bucket exists. The Storj network is accessed with its serialized access if accessKey is set.
+// It returns the storage, which must be closed, and any error, if occurred.
+func Open(ctx context.Context, configStorj ConfigStorj, accessKey bool) (Storage, error) {
+
+	var storage Storage
+	var err error
+	switch configStorj.Backend {
+	case "", BackendStorj:
+		_, project, err := Connect(ctx, configStorj, accessKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewStorjStorage(project), nil
+	case BackendLocal:
+		storage, err = NewLocalStorage(configStorj.Directory)
+	case BackendS3:
+		storage, err = NewS3Storage(configStorj)
+	default:
+		err = errorf(ErrConfig, "unknown backend %q, expected storj, local or s3", configStorj.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.EnsureBucket(ctx, configStorj.Bucket); err != nil {
+		_ = storage.Close()
+		return nil, newError(ErrConnect, err)
+	}
+	return storage, nil
+}
+
+// uploadBytes uploads a small object held entirely in memory.
+func uploadBytes(ctx context.Context, storage Storage, bucket string, objectKey string, data []byte) error {
+
+	upload, err := storage.Put(ctx, bucket, objectKey, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = upload.Write(data); err != nil {
+		_ = upload.Abort()
+		return err
+	}
+	_, err = upload.Commit()
+	return err
+}
+
+// downloadBytes downloads a small object entirely into memory.
+func downloadBytes(ctx context.Context, storage Storage, bucket string, objectKey string) ([]byte, error) {
+
+	download, _, err := storage.Get(ctx, bucket, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = download.Close() }()
+	return ioutil.ReadAll(download)
+}
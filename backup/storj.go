@@ -0,0 +1,926 @@
+// Copyright (C) 2019 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"storj.io/uplink"
+)
+
+// BackupTimeFormat is the layout of the timestamp suffixed to the database name of every back-up.
+const BackupTimeFormat = "2006-01-02_15_04_05"
+
+// ConfigStorj depicts keys to search for within the stroj_config.json file.
+type ConfigStorj struct {
+	APIKey    string `json:"apikey"`
+	Satellite string `json:"satellite"`
+	Bucket    string `json:"bucket"`
+	// UploadPath is the key prefix of the back-ups within the bucket, which is either empty or ends with a slash.
+	UploadPath           string `json:"uploadPath"`
+	EncryptionPassphrase string `json:"encryptionpassphrase"`
+	SerializedAccess     string `json:"serializedAccess"`
+	AllowDownload        string `json:"allowDownload"`
+	AllowUpload          string `json:"allowUpload"`
+	AllowList            string `json:"allowList"`
+	AllowDelete          string `json:"allowDelete"`
+	NotBefore            string `json:"notBefore"`
+	NotAfter             string `json:"notAfter"`
+	// Backend is the storage of the back-ups: storj, the default, local or s3.
+	Backend string `json:"backend"`
+	// Directory is the root directory of the local backend, holding a directory per bucket.
+	Directory string `json:"directory"`
+	// Endpoint is the URL of the S3-compatible service of the s3 backend, such as http://localhost:9000 for MinIO.
+	Endpoint string `json:"endpoint"`
+	// Region is the region of the s3 backend, us-east-1 by default.
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	// VirtualHostedStyle addresses the buckets of the s3 backend as subdomains of the endpoint instead of path segments.
+	VirtualHostedStyle bool `json:"virtualHostedStyle"`
+}
+
+// Share returns a shareable serialized access restricted as per the permissions of the Storj configuration.
+func Share(access *uplink.Access, configStorj ConfigStorj) (string, error) {
+
+	allowDownload, _ := strconv.ParseBool(configStorj.AllowDownload)
+	allowUpload, _ := strconv.ParseBool(configStorj.AllowUpload)
+	allowList, _ := strconv.ParseBool(configStorj.AllowList)
+	allowDelete, _ := strconv.ParseBool(configStorj.AllowDelete)
+	notBefore, _ := time.Parse("2006-01-02_15:04:05", configStorj.NotBefore)
+	notAfter, _ := time.Parse("2006-01-02_15:04:05", configStorj.NotAfter)
+
+	permission := uplink.Permission{
+		AllowDownload: allowDownload,
+		AllowUpload:   allowUpload,
+		AllowList:     allowList,
+		AllowDelete:   allowDelete,
+		NotBefore:     notBefore,
+		NotAfter:      notAfter,
+	}
+
+	// Create shared access.
+	sharedAccess, err := access.Share(permission)
+	if err != nil {
+		return "", errorf(ErrConfig, "could not generate shared access: %w", err)
+	}
+
+	// Generate restricted serialized access.
+	serializedAccess, err := sharedAccess.Serialize()
+	if err != nil {
+		return "", errorf(ErrConfig, "could not serialize shared access: %w", err)
+	}
+	return serializedAccess, nil
+}
+
+// Connect connects to the Storj network described by the Storj configuration, using its serialized access if accessKey is set
+// or else its API key, satellite and encryption passphrase, and ensures its bucket exists.
+// It returns the access and the project, which must be closed, and any error, if occurred.
+func Connect(ctx context.Context, configStorj ConfigStorj, accessKey bool) (*uplink.Access, *uplink.Project, error) {
+
+	var access *uplink.Access
+	var cfg uplink.Config
+
+	// Configure the UserAgent
+	cfg.UserAgent = "MongoDB"
+	var err error
+
+	if accessKey {
+		// Generate access handle using serialized access.
+		access, err = uplink.ParseAccess(configStorj.SerializedAccess)
+		if err != nil {
+			return nil, nil, errorf(ErrConfig, "invalid serialized access: %w", err)
+		}
+	} else {
+		// Generate access handle using API key, satellite url and encryption passphrase.
+		access, err = cfg.RequestAccessWithPassphrase(ctx, configStorj.Satellite, configStorj.APIKey, configStorj.EncryptionPassphrase)
+		if err != nil {
+			return nil, nil, newError(ErrConnect, err)
+		}
+	}
+
+	// Open a new porject.
+	project, err := cfg.OpenProject(ctx, access)
+	if err != nil {
+		return nil, nil, newError(ErrConnect, err)
+	}
+
+	// Ensure the desired Bucket within the Project
+	_, err = project.EnsureBucket(ctx, configStorj.Bucket)
+	if err != nil {
+		_ = project.Close()
+		return nil, nil, newError(ErrConnect, err)
+	}
+	return access, project, nil
+}
+
+// storjStorage stores the back-ups on the Storj network.
+type storjStorage struct {
+	project *uplink.Project
+}
+
+// NewStorjStorage returns the storage of the back-ups on the Storj network, in the buckets of the project.
+// Closing it closes the project.
+func NewStorjStorage(project *uplink.Project) MultipartStorage {
+
+	return &storjStorage{project: project}
+}
+
+// storjObjectInfo describes an object listed or downloaded from the Storj network.
+func storjObjectInfo(object *uplink.Object) *ObjectInfo {
+
+	return &ObjectInfo{Key: object.Key, IsPrefix: object.IsPrefix, Size: object.System.ContentLength, Modified: object.System.Created, Metadata: object.Custom}
+}
+
+// storjError matches the errors of the Storj network for missing objects with ErrObjectNotFound.
+func storjError(err error) error {
+
+	if errors.Is(err, uplink.ErrObjectNotFound) {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, err)
+	}
+	return err
+}
+
+// Put starts the upload of an object to the Storj network.
+func (storage *storjStorage) Put(ctx context.Context, bucket string, key string, metadata map[string]string) (ObjectWriter, error) {
+
+	upload, err := storage.project.UploadObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &storjUpload{ctx: ctx, upload: upload, metadata: metadata}, nil
+}
+
+// Get downloads an object from the Storj network.
+func (storage *storjStorage) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, *ObjectInfo, error) {
+
+	download, err := storage.project.DownloadObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, nil, storjError(err)
+	}
+	return download, storjObjectInfo(download.Info()), nil
+}
+
+// List lists the objects stored on the Storj network under the prefix.
+func (storage *storjStorage) List(ctx context.Context, bucket string, prefix string, recursive bool) ([]*ObjectInfo, error) {
+
+	var objects []*ObjectInfo
+	items := storage.project.ListObjects(ctx, bucket, &uplink.ListObjectsOptions{Prefix: prefix, Recursive: recursive, System: true, Custom: true})
+	for items.Next() {
+		objects = append(objects, storjObjectInfo(items.Item()))
+	}
+	return objects, items.Err()
+}
+
+// Delete deletes an object from the Storj network.
+func (storage *storjStorage) Delete(ctx context.Context, bucket string, key string) error {
+
+	_, err := storage.project.DeleteObject(ctx, bucket, key)
+	return err
+}
+
+// Stat describes an object stored on the Storj network.
+func (storage *storjStorage) Stat(ctx context.Context, bucket string, key string) (*ObjectInfo, error) {
+
+	object, err := storage.project.StatObject(ctx, bucket, key)
+	if err != nil {
+		return nil, storjError(err)
+	}
+	return storjObjectInfo(object), nil
+}
+
+// EnsureBucket creates a bucket on the Storj network, unless it exists.
+func (storage *storjStorage) EnsureBucket(ctx context.Context, bucket string) error {
+
+	_, err := storage.project.EnsureBucket(ctx, bucket)
+	return err
+}
+
+// Close closes the project.
+func (storage *storjStorage) Close() error {
+
+	return storage.project.Close()
+}
+
+// BeginUpload starts a multipart upload to the Storj network, which records the custom metadata when it is committed.
+func (storage *storjStorage) BeginUpload(ctx context.Context, bucket string, key string, metadata map[string]string) (string, error) {
+
+	upload, err := storage.project.BeginUpload(ctx, bucket, key, nil)
+	if err != nil {
+		return "", err
+	}
+	return upload.UploadID, nil
+}
+
+// PutPart starts the upload of a part to the Storj network.
+func (storage *storjStorage) PutPart(ctx context.Context, bucket string, key string, uploadID string, number int) (PartWriter, error) {
+
+	return storage.project.UploadPart(ctx, bucket, key, uploadID, uint32(number))
+}
+
+// ListParts lists the parts of a multipart upload to the Storj network.
+func (storage *storjStorage) ListParts(ctx context.Context, bucket string, key string, uploadID string) ([]int, error) {
+
+	var numbers []int
+	parts := storage.project.ListUploadParts(ctx, bucket, key, uploadID, nil)
+	for parts.Next() {
+		numbers = append(numbers, int(parts.Item().PartNumber))
+	}
+	sort.Ints(numbers)
+	return numbers, parts.Err()
+}
+
+// CommitUpload commits a multipart upload to the Storj network, along with its custom metadata.
+func (storage *storjStorage) CommitUpload(ctx context.Context, bucket string, key string, uploadID string, metadata map[string]string) error {
+
+	_, err := storage.project.CommitUpload(ctx, bucket, key, uploadID, &uplink.CommitUploadOptions{CustomMetadata: metadata})
+	return err
+}
+
+// AbortUpload aborts a multipart upload to the Storj network.
+func (storage *storjStorage) AbortUpload(ctx context.Context, bucket string, key string, uploadID string) error {
+
+	return storage.project.AbortUpload(ctx, bucket, key, uploadID)
+}
+
+// storjUpload uploads an object to the Storj network, setting its custom metadata when it is committed.
+type storjUpload struct {
+	ctx      context.Context
+	upload   *uplink.Upload
+	metadata map[string]string
+}
+
+// Write writes to the upload.
+func (storjUpload *storjUpload) Write(p []byte) (int, error) {
+
+	return storjUpload.upload.Write(p)
+}
+
+// Commit sets the custom metadata of the object and commits it.
+func (storjUpload *storjUpload) Commit() (int64, error) {
+
+	if len(storjUpload.metadata) > 0 {
+		if err := storjUpload.upload.SetCustomMetadata(storjUpload.ctx, storjUpload.metadata); err != nil {
+			_ = storjUpload.upload.Abort()
+			return 0, err
+		}
+	}
+	if err := storjUpload.upload.Commit(); err != nil {
+		return 0, err
+	}
+	return storjUpload.upload.Info().System.ContentLength, nil
+}
+
+// Abort aborts the upload.
+func (storjUpload *storjUpload) Abort() error {
+
+	return storjUpload.upload.Abort()
+}
+
+// UploadOptions defines how a back-up is uploaded.
+type UploadOptions struct {
+	// Compression compresses the collections' objects, which are stored uncompressed by default.
+	Compression Compression
+	// Encryption encrypts the objects with keys of our own, on top of the encryption of the Storj network.
+	Encryption *Encryption
+	// Parallel is the number of collections dumped and uploaded concurrently, each with its own cursor and upload.
+	// Collections are uploaded one at a time if it is zero or one.
+	Parallel int
+	// Progress receives the events of the upload, if set.
+	Progress Progress
+	// checkpoint records the progress of the back-up, the collections being uploaded through multipart uploads if it is set.
+	checkpoint *BackupCheckpoint
+}
+
+// Upload uploads the back-up of each collection yielded by collections
+// as a separate object under the uploadFileName prefix of the storj network, until ctx is cancelled.
+// It returns the manifest entries describing the uploaded collections and any error, if occurred.
+func Upload(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, collections CollectionIterator, uploadOptions UploadOptions) ([]CollectionManifest, error) {
+
+	uploaded, err := uploadCollections(ctx, storage, configStorj, uploadFileName, collections, uploadOptions)
+	return uploaded, newError(ErrUpload, err)
+}
+
+// uploadCollections uploads the back-up of each collection yielded by collections, as Upload does.
+func uploadCollections(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, collections CollectionIterator, uploadOptions UploadOptions) ([]CollectionManifest, error) {
+
+	if opener, ok := collections.(CollectionOpener); ok && uploadOptions.Parallel > 1 {
+		return uploadCollectionsParallel(ctx, storage, configStorj, uploadFileName, opener, uploadOptions)
+	}
+	if opener, ok := collections.(ResumableOpener); ok && uploadOptions.checkpoint != nil {
+		return uploadCollectionsParallel(ctx, storage, configStorj, uploadFileName, opener, uploadOptions)
+	}
+
+	buf := make([]byte, collectionBufferSize)
+	exporter, _ := collections.(MetadataExporter)
+	var uploaded []CollectionManifest
+
+	// Loop to upload and commit each collection one by one.
+	for {
+		collectionName, collectionReader, err := collections.NextCollection()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return uploaded, err
+		}
+
+		objectKey := configStorj.UploadPath + uploadFileName + "/" + collectionName + ".bson" + uploadOptions.Compression.Extension()
+		uploadOptions.Progress.report(Event{Kind: EventUploading, Bucket: configStorj.Bucket, Object: objectKey, Collection: collectionName, Message: fmt.Sprintf("Uploading %s to %s...", objectKey, configStorj.Bucket)})
+		uploadedCollection, err := uploadCollection(ctx, storage, configStorj, uploadFileName, collectionName, &contextReader{ctx: ctx, reader: collectionReader}, exporter, buf, uploadOptions)
+		if err != nil {
+			uploadOptions.Progress.report(Event{Kind: EventFailed, Bucket: configStorj.Bucket, Object: objectKey, Collection: collectionName, Err: err, Message: fmt.Sprintf("Failed upload of %s collection: %s", collectionName, err)})
+			return uploaded, err
+		}
+		reportUploaded(uploadOptions.Progress, configStorj, uploadFileName, uploadedCollection)
+		uploaded = append(uploaded, uploadedCollection)
+	}
+	return uploaded, nil
+}
+
+// uploadCollection uploads the documents of the collection read from reader, along with its metadata if exporter is set,
+// under the uploadFileName prefix of the storj network.
+// It returns the manifest entry of the collection and any error, if occurred.
+func uploadCollection(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, collectionName string, reader io.Reader, exporter MetadataExporter, buf []byte, uploadOptions UploadOptions) (CollectionManifest, error) {
+
+	uploadedCollection := CollectionManifest{Name: collectionName, Object: collectionName + ".bson" + uploadOptions.Compression.Extension(), Compression: uploadOptions.Compression.Codec}
+	var err error
+	if uploadedCollection.Metadata, err = uploadMetadata(ctx, storage, configStorj, uploadFileName, collectionName, exporter, buf, uploadOptions); err != nil {
+		return uploadedCollection, err
+	}
+
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + uploadedCollection.Object
+	if err := uploadBSON(ctx, storage, configStorj.Bucket, objectKey, reader, buf, uploadOptions, &uploadedCollection); err != nil {
+		return uploadedCollection, fmt.Errorf("could not upload %s collection: %w", collectionName, err)
+	}
+	return uploadedCollection, nil
+}
+
+// reportUploaded reports the upload of the object of a collection stored under the uploadFileName prefix.
+func reportUploaded(progress Progress, configStorj ConfigStorj, uploadFileName string, entry CollectionManifest) {
+
+	objectKey := configStorj.UploadPath + uploadFileName + "/" + entry.Object
+	progress.report(Event{Kind: EventUploaded, Bucket: configStorj.Bucket, Object: objectKey, Collection: entry.Name, Documents: entry.Documents, Bytes: entry.Size, Total: entry.Size,
+		Message: fmt.Sprintf("Uploaded %s to %s, %d documents.", objectKey, configStorj.Bucket, entry.Documents)})
+}
+
+// uploadMetadata exports the options and indexes of the collection next to its documents, if exporter is set.
+// It returns the key of the metadata object relative to the back-up prefix, which is empty without exporter.
+func uploadMetadata(ctx context.Context, storage Storage, configStorj ConfigStorj, uploadFileName string, collectionName string, exporter MetadataExporter, buf []byte, uploadOptions UploadOptions) (string, error) {
+
+	if exporter == nil {
+		return "", nil
+	}
+	metadata, err := exporter.Metadata(collectionName)
+	if err != nil {
+		return "", err
+	}
+	metadataKey := configStorj.UploadPath + uploadFileName + "/" + collectionName + metadataSuffix
+	if _, err = uploadObject(ctx, storage, configStorj.Bucket, metadataKey, bytes.NewReader(metadata), buf, UploadOptions{Encryption: uploadOptions.Encryption}); err != nil {
+		return "", fmt.Errorf("could not upload metadata of %s collection: %w", collectionName, err)
+	}
+	return collectionName + metadataSuffix, nil
+}
+
+// uploadBSON uploads the concatenated BSON documents read from reader as a single object, compressed and encrypted as per uploadOptions,
+// recording their number and checksum in entry along with the size of the stored object.
+func uploadBSON(ctx context.Context, storage Storage, bucket string, objectKey string, reader io.Reader, buf []byte, uploadOptions UploadOptions, entry *CollectionManifest) error {
+
+	// Checksum and count the plain documents while they are being uploaded.
+	hash := sha256.New()
+	counter := &documentCounter{}
+	size, err := uploadObject(ctx, storage, bucket, objectKey, io.TeeReader(reader, io.MultiWriter(hash, counter)), buf, uploadOptions)
+	if err != nil {
+		return err
+	}
+
+	entry.Documents = counter.count
+	entry.Size = size
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// uploadObject uploads the data read from reader as a single object, compressed then encrypted as per uploadOptions.
+// The codec and encryption keys are recorded in the object's custom metadata for restore to decode it.
+// It returns the size of the stored object.
+func uploadObject(ctx context.Context, storage Storage, bucket string, objectKey string, reader io.Reader, buf []byte, uploadOptions UploadOptions) (int64, error) {
+
+	upload, err := storage.Put(ctx, bucket, objectKey, objectMetadata(uploadOptions))
+	if err != nil {
+		return 0, err
+	}
+
+	var encryptor io.WriteCloser = nopWriteCloser{upload}
+	if uploadOptions.Encryption != nil {
+		if encryptor, err = uploadOptions.Encryption.NewWriter(upload); err != nil {
+			_ = upload.Abort()
+			return 0, err
+		}
+	}
+	compressor, err := uploadOptions.Compression.NewWriter(encryptor)
+	if err != nil {
+		_ = upload.Abort()
+		return 0, err
+	}
+
+	if _, err = io.CopyBuffer(compressor, reader, buf); err == nil {
+		if err = compressor.Close(); err == nil {
+			err = encryptor.Close()
+		}
+	}
+	if err != nil {
+		_ = upload.Abort()
+		return 0, err
+	}
+	return upload.Commit()
+}
+
+// objectMetadata returns the custom metadata recording the codec and encryption keys of an object uploaded as per uploadOptions.
+func objectMetadata(uploadOptions UploadOptions) map[string]string {
+
+	custom := map[string]string{}
+	if uploadOptions.Encryption != nil {
+		for key, value := range uploadOptions.Encryption.metadata() {
+			custom[key] = value
+		}
+	}
+	if uploadOptions.Compression.Codec != "" {
+		custom[compressionMetadataKey] = uploadOptions.Compression.Codec
+	}
+	return custom
+}
+
+// BackupInfo describes a single back-up stored in a storage.
+type BackupInfo struct {
+	Bucket string
+	// Prefix is the key prefix of the back-up's objects, ending with a slash.
+	Prefix   string
+	Database string
+	Time     time.Time
+	// Size is the total size of the back-up's objects in bytes.
+	Size    int64
+	Objects []*ObjectInfo
+}
+
+// ListBackups lists the back-ups stored under the databasePrefix of the bucket, oldest first.
+// Prefixes not named after the database and a timestamp are ignored.
+func ListBackups(ctx context.Context, storage Storage, bucket string, databasePrefix string) ([]BackupInfo, error) {
+
+	database := path.Base(databasePrefix)
+	var backups []BackupInfo
+	items, err := storage.List(ctx, bucket, databasePrefix, false)
+	if err != nil {
+		return nil, newError(ErrDownload, err)
+	}
+	for _, item := range items {
+		name := path.Base(item.Key)
+		if !item.IsPrefix || !strings.HasPrefix(name, database) {
+			continue
+		}
+		backupTime, err := time.ParseInLocation(BackupTimeFormat, name[len(database):], time.Local)
+		if err != nil {
+			continue
+		}
+
+		backup := BackupInfo{Bucket: bucket, Prefix: item.Key, Database: database, Time: backupTime}
+		if backup.Objects, err = storage.List(ctx, bucket, item.Key, true); err != nil {
+			return nil, newError(ErrDownload, err)
+		}
+		for _, object := range backup.Objects {
+			backup.Size += object.Size
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// ListDatabases lists the key prefixes of the databases backed up under the uploadPath of the bucket.
+func ListDatabases(ctx context.Context, storage Storage, bucket string, uploadPath string) ([]string, error) {
+
+	var databasePrefixes []string
+	items, err := storage.List(ctx, bucket, uploadPath, false)
+	if err != nil {
+		return nil, newError(ErrDownload, err)
+	}
+	for _, item := range items {
+		if item.IsPrefix {
+			databasePrefixes = append(databasePrefixes, item.Key)
+		}
+	}
+	return databasePrefixes, nil
+}
+
+// decodeObject returns a reader decrypting then decompressing the object read from reader,
+// as recorded in the custom metadata of the object.
+func decodeObject(reader io.Reader, object *ObjectInfo, decryption *Decryption) (io.ReadCloser, error) {
+
+	if scheme := object.Metadata[encryptionMetadataKey]; scheme != "" {
+		var err error
+		if reader, err = decryption.decryptReader(reader, scheme, object.Metadata[encryptionKeysMetadataKey]); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", object.Key, err)
+		}
+	}
+	return decompressReader(reader, object.Metadata[compressionMetadataKey])
+}
+
+// downloadDecoded downloads a small object entirely into memory, decoding it as per its custom metadata.
+func downloadDecoded(ctx context.Context, storage Storage, bucket string, objectKey string, decryption *Decryption) ([]byte, error) {
+
+	download, info, err := storage.Get(ctx, bucket, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = download.Close() }()
+	reader, err := decodeObject(download, info, decryption)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	return ioutil.ReadAll(reader)
+}
+
+// openDecoded opens the download of an object along with a reader decoding it as per its custom metadata.
+// Both must be closed.
+func openDecoded(ctx context.Context, storage Storage, bucket string, objectKey string, decryption *Decryption) (io.ReadCloser, io.ReadCloser, error) {
+
+	download, info, err := storage.Get(ctx, bucket, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := decodeObject(download, info, decryption)
+	if err != nil {
+		_ = download.Close()
+		return nil, nil, err
+	}
+	return download, reader, nil
+}
+
+// findLatestBackup returns the key prefix of the latest complete back-up of the database at backupPath, in the format bucket/uploadPath/db.
+// Only the prefixes named after the database and a timestamp are back-ups, the oplog segments of the database being left out,
+// and the back-ups which failed or are still running are passed over.
+func findLatestBackup(ctx context.Context, storage Storage, backupPath string) (string, error) {
+
+	keys := strings.SplitN(backupPath, "/", 2)
+	if len(keys) < 2 || keys[1] == "" {
+		return "", errorf(ErrConfig, "invalid back-up path %s", backupPath)
+	}
+	backups, err := ListBackups(ctx, storage, keys[0], keys[1]+"/")
+	if err != nil {
+		return "", err
+	}
+	latest, _, err := latestCompleteBackup(ctx, storage, backups)
+	if err != nil {
+		return "", newError(ErrDownload, err)
+	}
+	if latest == nil {
+		return "", errorf(ErrNoBackup, "no complete back-up of %s to restore", backupPath)
+	}
+	return latest.Prefix, nil
+}
+
+// RestoreOptions defines how a back-up is restored.
+type RestoreOptions struct {
+	// MongoWriter streams the back-up into a live MongoDB instance instead of the ./dump folder.
+	MongoWriter *MongoWriter
+	// Force restores back-ups whose manifest reports them as incomplete.
+	Force bool
+	// Decryption decrypts the objects encrypted with keys of our own.
+	Decryption *Decryption
+	// Parallel is the number of objects downloaded and restored concurrently, one at a time if it is zero or one.
+	Parallel int
+	// BandwidthLimit limits the total download bandwidth of the restore, in bytes per second, if set.
+	BandwidthLimit int64
+	// Progress receives the events of the restore, if set.
+	Progress Progress
+	// bandwidth is the limiter shared by the downloads of a restore.
+	bandwidth *bandwidthLimiter
+}
+
+// Restore restores the back-up at backupPath, in the format bucket/uploadPath/db/dbYYYY-MM-DD_HH_MM_SS,
+// or the latest back-up of the database at backupPath, in the format bucket/uploadPath/db, if latest is set.
+// The downloads are aborted once ctx is cancelled.
+func Restore(ctx context.Context, storage Storage, backupPath string, latest bool, restoreOptions RestoreOptions) error {
+
+	if latest {
+		restoreOptions.Progress.infof("Restoring the latest backup of %s...", backupPath)
+	} else {
+		restoreOptions.Progress.infof("Restoring the backup of %s...", backupPath)
+	}
+	bucket, prefix, err := resolveBackup(ctx, storage, backupPath, latest)
+	if err != nil {
+		return err
+	}
+
+	if _, err = restoreBackup(ctx, storage, bucket, prefix, restoreOptions, primitive.Timestamp{}); err != nil {
+		return err
+	}
+	if latest {
+		restoreOptions.Progress.infof("\nLatest back-up restored.")
+	} else {
+		restoreOptions.Progress.infof("\nBack-up restored.")
+	}
+	return nil
+}
+
+// resolveBackup returns the bucket and key prefix of the back-up at backupPath,
+// or of the latest back-up of the database at backupPath if latest is set.
+func resolveBackup(ctx context.Context, storage Storage, backupPath string, latest bool) (string, string, error) {
+
+	if backupPath == "" {
+		return "", "", errorf(ErrConfig, "no back-up path given")
+	}
+	keys := strings.Split(backupPath, "/")
+	if latest {
+		checkSlash := backupPath[len(backupPath)-1:]
+		if checkSlash == "/" {
+			backupPath = backupPath[:len(backupPath)-1]
+		}
+		pathTokens := strings.Split(backupPath, "/")
+		if len(pathTokens) > 3 {
+			return "", "", errorf(ErrConfig, "invalid regular expression, it should only contain the pattern of database name")
+		}
+		prefix, err := findLatestBackup(ctx, storage, backupPath)
+		return keys[0], prefix, err
+	}
+	if len(keys) < 2 {
+		return "", "", errorf(ErrConfig, "invalid back-up path %s", backupPath)
+	}
+	// Convert the backup path to standard form
+	checkSlash := backupPath[len(backupPath)-1:]
+	if checkSlash != "/" {
+		backupPath = backupPath + "/"
+	}
+	return keys[0], backupPath[len(keys[0])+1:], nil
+}
+
+// restoreBackup restores every object of the back-up stored under prefix of the bucket.
+// When restoring into MongoDB, the oplog captured during the back-up is replayed up to oplogUntil, unless it is zero.
+// It returns the manifest of the back-up, if any, and any error, if occurred.
+func restoreBackup(ctx context.Context, storage Storage, bucket string, prefix string, restoreOptions RestoreOptions, oplogUntil primitive.Timestamp) (*BackupManifest, error) {
+
+	// Collect the objects of the back-up and check them against its manifest.
+	var objects []*ObjectInfo
+	listed, err := storage.List(ctx, bucket, prefix, false)
+	if err != nil {
+		return nil, newError(ErrDownload, err)
+	}
+	for _, item := range listed {
+		if !item.IsPrefix {
+			objects = append(objects, item)
+		}
+	}
+	manifest, err := checkBackup(ctx, storage, bucket, prefix, objects, restoreOptions.Force, restoreOptions.Progress)
+	if err != nil {
+		return nil, err
+	}
+
+	mongoWriter := restoreOptions.MongoWriter
+	if manifest != nil && manifest.Parent != "" {
+		return manifest, restoreIncrementalBackup(ctx, storage, bucket, prefix, manifest, restoreOptions)
+	}
+	storedKeys := make(map[string]bool)
+	for _, item := range objects {
+		storedKeys[item.Key] = true
+	}
+	var restored []*ObjectInfo
+	var oplogKey string
+	// Select the collection back-up files to download inside the ./dump folder or to restore into MongoDB.
+	for _, item := range objects {
+		objectName := filepath.Base(item.Key)
+		// Compressed objects are restored under their uncompressed name.
+		fileName := trimCompressionExtension(objectName)
+		if fileName == oplogFileName {
+			oplogKey = item.Key
+		}
+		if objectName == manifestFileName || (mongoWriter != nil && (fileName == oplogFileName || !strings.HasSuffix(fileName, ".bson"))) {
+			continue
+		}
+		restored = append(restored, item)
+	}
+	if len(restored) == 0 {
+		return manifest, errorf(ErrNoBackup, "nothing to restore at %s/%s", bucket, prefix)
+	}
+
+	if restoreOptions.BandwidthLimit > 0 && restoreOptions.bandwidth == nil {
+		restoreOptions.bandwidth = newBandwidthLimiter(restoreOptions.BandwidthLimit)
+	}
+	results := make([]Event, len(restored))
+	err = runParallel(ctx, len(restored), restoreOptions.Parallel, func(ctx context.Context, i int) error {
+		var err error
+		results[i], err = restoreObject(ctx, storage, bucket, prefix, restored[i], storedKeys, restoreOptions)
+		return err
+	}, func(i int, err error) {
+		switch {
+		case err == nil:
+			restoreOptions.Progress.report(results[i])
+		case err == context.Canceled:
+			restoreOptions.Progress.report(Event{Kind: EventFailed, Bucket: bucket, Object: restored[i].Key, Err: err, Message: fmt.Sprintf("Aborted restore of %s.", restored[i].Key)})
+		default:
+			restoreOptions.Progress.report(Event{Kind: EventFailed, Bucket: bucket, Object: restored[i].Key, Err: err, Message: fmt.Sprintf("Failed restore of %s: %s", restored[i].Key, err)})
+		}
+	})
+	if err != nil {
+		return manifest, newError(ErrRestore, err)
+	}
+
+	// Replay the oplog captured during the back-up, making the restored data consistent.
+	if mongoWriter != nil && oplogKey != "" {
+		var after primitive.Timestamp
+		database := path.Base(path.Dir(strings.TrimSuffix(prefix, "/")))
+		if manifest != nil {
+			if manifest.Database != "" {
+				database = manifest.Database
+			}
+			if manifest.OplogStart != nil {
+				after = *manifest.OplogStart
+			}
+		}
+		download, reader, err := openDecoded(ctx, storage, bucket, oplogKey, restoreOptions.Decryption)
+		if err != nil {
+			return manifest, newError(ErrDownload, err)
+		}
+		count, err := mongoWriter.ReplayOplog(ctx, database, reader, after, oplogUntil)
+		_ = reader.Close()
+		_ = download.Close()
+		if err != nil {
+			return manifest, errorf(ErrRestore, "failed to replay the oplog of the back-up: %w", err)
+		}
+		restoreOptions.Progress.infof("Replayed %d oplog entries written during the back-up.", count)
+	}
+	return manifest, nil
+}
+
+// restoreObject downloads an object of the back-up stored under prefix of the bucket, decoding it as it is read,
+// and streams it into MongoDB, along with the metadata of its collection, or into a file of the ./dump folder.
+// The download is aborted once ctx is cancelled.
+// It returns the event reporting what was restored and any error, if occurred.
+func restoreObject(ctx context.Context, storage Storage, bucket string, prefix string, item *ObjectInfo, storedKeys map[string]bool, restoreOptions RestoreOptions) (Event, error) {
+
+	restored := Event{Kind: EventRestored, Bucket: bucket, Object: item.Key}
+	download, info, err := storage.Get(ctx, bucket, item.Key)
+	if err != nil {
+		return restored, err
+	}
+	defer func() { _ = download.Close() }()
+
+	var reader io.Reader = &contextReader{ctx: ctx, reader: download}
+	if restoreOptions.bandwidth != nil {
+		reader = restoreOptions.bandwidth.NewReader(reader)
+	}
+	restored.Total = info.Size
+	if restoreOptions.Progress != nil {
+		downloading := Event{Kind: EventDownloading, Bucket: bucket, Object: item.Key, Total: restored.Total}
+		restoreOptions.Progress.report(downloading)
+		reader = &progressReader{reader: reader, event: downloading, progress: restoreOptions.Progress}
+	}
+	decoded, err := decodeObject(ioutil.NopCloser(reader), info, restoreOptions.Decryption)
+	if err != nil {
+		return restored, err
+	}
+	defer func() { _ = decoded.Close() }()
+
+	fileName := trimCompressionExtension(filepath.Base(item.Key))
+	if mongoWriter := restoreOptions.MongoWriter; mongoWriter != nil {
+		// Stream the collection straight into the target MongoDB instance.
+		collectionName := strings.TrimSuffix(fileName, ".bson")
+		database := filepath.Base(filepath.Dir(filepath.Dir(item.Key)))
+		var metadata []byte
+		if metadataKey := prefix + collectionName + metadataSuffix; storedKeys[metadataKey] {
+			if metadata, err = downloadDecoded(ctx, storage, bucket, metadataKey, restoreOptions.Decryption); err != nil {
+				return restored, fmt.Errorf("failed to read metadata of %s collection: %w", collectionName, err)
+			}
+		}
+		count, err := mongoWriter.RestoreCollection(ctx, database, collectionName, metadata, decoded)
+		if err != nil {
+			return restored, fmt.Errorf("failed to restore %s collection: %w", collectionName, err)
+		}
+		restored.Collection, restored.Documents = collectionName, int64(count)
+		restored.Message = fmt.Sprintf("Restored %d documents into %s collection.", count, collectionName)
+		return restored, nil
+	}
+
+	// Stream the object to its file, without holding it in memory.
+	// The oplog goes to the root of the folder, where mongorestore --oplogReplay reads it, as mongodump --oplog writes it.
+	downloadFileName := filepath.Join("dump", filepath.Base(filepath.Dir(item.Key)), fileName)
+	if fileName == oplogFileName {
+		downloadFileName = filepath.Join("dump", fileName)
+	}
+	if err = os.MkdirAll(filepath.Dir(downloadFileName), 0750); err != nil {
+		return restored, err
+	}
+	file, err := os.OpenFile(filepath.Clean(downloadFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return restored, err
+	}
+	if _, err = io.Copy(file, decoded); err == nil {
+		err = file.Close()
+	} else {
+		_ = file.Close()
+	}
+	if err != nil {
+		_ = os.Remove(downloadFileName)
+		return restored, err
+	}
+	restored.Message = fmt.Sprintf("Downloaded %s.", downloadFileName)
+	return restored, nil
+}
+
+// restoreIncrementalBackup restores the back-up the incremental back-up is based on, down to the full back-up of its chain,
+// then applies the changes recorded by the incremental back-up.
+func restoreIncrementalBackup(ctx context.Context, storage Storage, bucket string, prefix string, manifest *BackupManifest, restoreOptions RestoreOptions) error {
+
+	if restoreOptions.MongoWriter == nil {
+		return errorf(ErrConfig, "incremental back-ups can only be restored into MongoDB")
+	}
+	if _, err := restoreBackup(ctx, storage, bucket, manifest.Parent, restoreOptions, primitive.Timestamp{}); err != nil {
+		return err
+	}
+
+	changesObject := changesFileName
+	if manifest.Changes != nil {
+		changesObject = manifest.Changes.Object
+	}
+	download, reader, err := openDecoded(ctx, storage, bucket, prefix+changesObject, restoreOptions.Decryption)
+	if err != nil {
+		return newError(ErrDownload, err)
+	}
+	count, err := restoreOptions.MongoWriter.ApplyChanges(ctx, manifest.Database, reader)
+	_ = reader.Close()
+	_ = download.Close()
+	if err != nil {
+		return errorf(ErrRestore, "failed to apply the changes of %s: %w", prefix, err)
+	}
+	restoreOptions.Progress.infof("Applied %d changes of the incremental back-up %s.", count, prefix)
+	return nil
+}
+
+// RestoreMatching finds the databases backed up at backupPath, in the format bucket or bucket/uploadPath,
+// whose name matches the pattern and restores the latest back-up of each of them.
+// When the MongoWriter restores into a single database, the pattern must match a single database,
+// as the collections of several databases would be merged.
+func RestoreMatching(ctx context.Context, storage Storage, matchPattern string, backupPath string, restoreOptions RestoreOptions) error {
+
+	matching, err := matchDatabases(ctx, storage, matchPattern, backupPath)
+	if err != nil {
+		return err
+	}
+	if mongoWriter := restoreOptions.MongoWriter; mongoWriter != nil && mongoWriter.database != "" && len(matching) > 1 {
+		return errorf(ErrConfig, "%d databases match %s, they cannot all be restored into the %s database", len(matching), matchPattern, mongoWriter.database)
+	}
+	for _, databasePath := range matching {
+		restoreOptions.Progress.infof("Matching database:  %s", databasePath)
+		if err = Restore(ctx, storage, databasePath, true, restoreOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchDatabases returns the paths of the databases backed up at backupPath, in the format bucket or bucket/uploadPath,
+// whose name matches the pattern, in the format bucket/uploadPath/db/.
+func matchDatabases(ctx context.Context, storage Storage, matchPattern string, backupPath string) ([]string, error) {
+
+	matcher, err := regexp.Compile(matchPattern)
+	if err != nil {
+		return nil, errorf(ErrConfig, "invalid pattern: %w", err)
+	}
+	backupPath = strings.TrimSuffix(backupPath, "/")
+	keys := strings.Split(backupPath, "/")
+	if len(keys) > 2 {
+		return nil, errorf(ErrConfig, "invalid back-up path %s", backupPath)
+	}
+	var prefix string
+	if len(keys) > 1 {
+		prefix = backupPath[len(keys[0])+1:] + "/"
+	}
+	databases, err := storage.List(ctx, keys[0], prefix, false)
+	if err != nil {
+		return nil, newError(ErrDownload, err)
+	}
+	var matching []string
+	for _, item := range databases {
+		if matcher.MatchString(filepath.Base(item.Key)) {
+			matching = append(matching, keys[0]+"/"+item.Key)
+		}
+	}
+	return matching, nil
+}
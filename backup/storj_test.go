@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRestoreMatchingIntoDatabase(t *testing.T) {
+
+	ctx := context.Background()
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(directory) }()
+	storage, err := NewLocalStorage(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"inventory/inventory2020-06-30_12_00_00/items.bson", "invoices/invoices2020-06-30_12_00_00/items.bson"} {
+		if err = uploadBytes(ctx, storage, "bucket", key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The collections of both databases would be merged into the target database.
+	restoreOptions := RestoreOptions{MongoWriter: &MongoWriter{database: "restored"}}
+	if err = RestoreMatching(ctx, storage, "^inv", "bucket", restoreOptions); !errors.Is(err, ErrConfig) {
+		t.Errorf("restored several databases into one: %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DestinationPolicy decides whether the failure of a single destination of a TeeStorage fails the whole back-up.
+type DestinationPolicy int
+
+const (
+	// RequireAll fails the back-up as soon as any destination fails.
+	RequireAll DestinationPolicy = iota
+	// RequireAny keeps backing up to the remaining destinations when one fails,
+	// only failing the back-up once every destination has failed.
+	RequireAny
+)
+
+// ParseDestinationPolicy parses a destination policy, either all or any.
+func ParseDestinationPolicy(policy string) (DestinationPolicy, error) {
+
+	switch policy {
+	case "", "all":
+		return RequireAll, nil
+	case "any":
+		return RequireAny, nil
+	}
+	return RequireAll, errorf(ErrConfig, "unknown destination policy %q, expected all or any", policy)
+}
+
+// Destination is a storage a TeeStorage writes the back-ups to, under its own bucket and upload path.
+type Destination struct {
+	// Name identifies the destination in the events and the status.
+	Name    string
+	Storage Storage
+	// Bucket and UploadPath replace those of the first destination in the keys written to this one.
+	Bucket     string
+	UploadPath string
+}
+
+// DestinationStatus reports whether a destination of a TeeStorage failed.
+type DestinationStatus struct {
+	Name string
+	// Err is the error which failed the destination, nil if every operation succeeded.
+	Err error
+}
+
+// TeeStorage fans the objects written out to several destinations at once, so that the database is read only once.
+// Objects are read and listed from the first destination which has not failed. A destination which fails
+// is skipped for the rest of the run, leaving its back-up without manifest, as incomplete, and the operation
+// only fails as per the policy.
+// It does not support multipart uploads, the upload IDs of its destinations being unrelated, so back-ups through it
+// cannot be resumed.
+type TeeStorage struct {
+	destinations []Destination
+	policy       DestinationPolicy
+	progress     Progress
+
+	mu     sync.Mutex
+	failed []error
+}
+
+// NewTeeStorage returns the storage writing to every destination, the keys being given in the bucket
+// and under the upload path of the first one. The failures of destinations are reported to progress, if set.
+// Closing it closes every destination.
+func NewTeeStorage(destinations []Destination, policy DestinationPolicy, progress Progress) *TeeStorage {
+
+	return &TeeStorage{destinations: destinations, policy: policy, progress: progress, failed: make([]error, len(destinations))}
+}
+
+// Status returns the status of every destination, in order.
+func (tee *TeeStorage) Status() []DestinationStatus {
+
+	tee.mu.Lock()
+	defer tee.mu.Unlock()
+	status := make([]DestinationStatus, len(tee.destinations))
+	for i, destination := range tee.destinations {
+		status[i] = DestinationStatus{Name: destination.Name, Err: tee.failed[i]}
+	}
+	return status
+}
+
+// active returns the indexes of the destinations which have not failed.
+func (tee *TeeStorage) active() []int {
+
+	tee.mu.Lock()
+	defer tee.mu.Unlock()
+	var active []int
+	for i, err := range tee.failed {
+		if err == nil {
+			active = append(active, i)
+		}
+	}
+	return active
+}
+
+// fail records the failure of the destination and returns the error failing the whole operation, if any, as per the policy.
+func (tee *TeeStorage) fail(i int, err error) error {
+
+	tee.mu.Lock()
+	if tee.failed[i] == nil {
+		tee.failed[i] = err
+	}
+	remaining := 0
+	for _, failure := range tee.failed {
+		if failure == nil {
+			remaining++
+		}
+	}
+	tee.mu.Unlock()
+
+	name := tee.destinations[i].Name
+	if tee.policy == RequireAll {
+		return fmt.Errorf("destination %s failed: %w", name, err)
+	}
+	if remaining == 0 {
+		return fmt.Errorf("every destination failed, the last one %s: %w", name, err)
+	}
+	tee.progress.report(Event{Kind: EventWarning, Err: err,
+		Message: fmt.Sprintf("destination %s failed, continuing with the %d other ones: %s", name, remaining, err)})
+	return nil
+}
+
+// translate returns the bucket and key of the destination matching those of the first destination.
+func (tee *TeeStorage) translate(i int, bucket string, key string) (string, string) {
+
+	first, destination := tee.destinations[0], tee.destinations[i]
+	if i == 0 || bucket != first.Bucket {
+		return bucket, key
+	}
+	if strings.HasPrefix(key, first.UploadPath) {
+		key = destination.UploadPath + key[len(first.UploadPath):]
+	}
+	return destination.Bucket, key
+}
+
+// translateBack returns the key of the first destination matching a key of the destination.
+func (tee *TeeStorage) translateBack(i int, key string) string {
+
+	first, destination := tee.destinations[0], tee.destinations[i]
+	if i == 0 || !strings.HasPrefix(key, destination.UploadPath) {
+		return key
+	}
+	return first.UploadPath + key[len(destination.UploadPath):]
+}
+
+// reader returns the index of the destination to read from.
+func (tee *TeeStorage) reader() (int, error) {
+
+	active := tee.active()
+	if len(active) == 0 {
+		return 0, fmt.Errorf("every destination failed")
+	}
+	return active[0], nil
+}
+
+// each runs the operation on every destination which has not failed, concurrently,
+// and returns the error failing the whole operation, if any, as per the policy.
+func (tee *TeeStorage) each(operation func(i int) error) error {
+
+	active := tee.active()
+	if len(active) == 0 {
+		return fmt.Errorf("every destination failed")
+	}
+	errs := make([]error, len(active))
+	var wg sync.WaitGroup
+	for n, i := range active {
+		wg.Add(1)
+		go func(n int, i int) {
+			defer wg.Done()
+			errs[n] = operation(i)
+		}(n, i)
+	}
+	wg.Wait()
+
+	var failure error
+	for n, err := range errs {
+		if err == nil {
+			continue
+		}
+		if err = tee.fail(active[n], err); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
+
+// Put starts the upload of the object to every destination.
+func (tee *TeeStorage) Put(ctx context.Context, bucket string, key string, metadata map[string]string) (ObjectWriter, error) {
+
+	writers := make([]ObjectWriter, len(tee.destinations))
+	err := tee.each(func(i int) error {
+		destinationBucket, destinationKey := tee.translate(i, bucket, key)
+		var err error
+		writers[i], err = tee.destinations[i].Storage.Put(ctx, destinationBucket, destinationKey, metadata)
+		return err
+	})
+	upload := &teeUpload{tee: tee, writers: writers}
+	if err != nil {
+		_ = upload.Abort()
+		return nil, err
+	}
+	return upload, nil
+}
+
+// teeUpload uploads an object to every destination of a TeeStorage.
+type teeUpload struct {
+	tee     *TeeStorage
+	writers []ObjectWriter
+	size    int64
+}
+
+// run runs the operation on the writer of every destination which has not failed,
+// aborting the writers of those which fail, or failed during another operation of the storage.
+func (upload *teeUpload) run(operation func(i int, writer ObjectWriter) error) error {
+
+	err := upload.tee.each(func(i int) error {
+		writer := upload.writers[i]
+		if writer == nil {
+			return fmt.Errorf("upload was not started")
+		}
+		if err := operation(i, writer); err != nil {
+			_ = writer.Abort()
+			upload.writers[i] = nil
+			return err
+		}
+		return nil
+	})
+	active := make(map[int]bool)
+	for _, i := range upload.tee.active() {
+		active[i] = true
+	}
+	for i, writer := range upload.writers {
+		if writer != nil && !active[i] {
+			_ = writer.Abort()
+			upload.writers[i] = nil
+		}
+	}
+	return err
+}
+
+// Write writes the data to every destination concurrently.
+func (upload *teeUpload) Write(data []byte) (int, error) {
+
+	err := upload.run(func(i int, writer ObjectWriter) error {
+		n, err := writer.Write(data)
+		if err == nil && n < len(data) {
+			err = io.ErrShortWrite
+		}
+		return err
+	})
+	if err != nil {
+		_ = upload.Abort()
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Commit commits the object to every destination, returning its size, the same on each of them.
+func (upload *teeUpload) Commit() (int64, error) {
+
+	var mu sync.Mutex
+	err := upload.run(func(i int, writer ObjectWriter) error {
+		size, err := writer.Commit()
+		if err != nil {
+			return err
+		}
+		upload.writers[i] = nil
+		mu.Lock()
+		upload.size = size
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		_ = upload.Abort()
+		return 0, err
+	}
+	return upload.size, nil
+}
+
+// Abort aborts the upload to every destination which has not failed.
+func (upload *teeUpload) Abort() error {
+
+	var failure error
+	for i, writer := range upload.writers {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Abort(); err != nil && failure == nil {
+			failure = err
+		}
+		upload.writers[i] = nil
+	}
+	return failure
+}
+
+// Get downloads the object from the first destination which has not failed.
+func (tee *TeeStorage) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, *ObjectInfo, error) {
+
+	i, err := tee.reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	destinationBucket, destinationKey := tee.translate(i, bucket, key)
+	reader, object, err := tee.destinations[i].Storage.Get(ctx, destinationBucket, destinationKey)
+	if object != nil {
+		object.Key = tee.translateBack(i, object.Key)
+	}
+	return reader, object, err
+}
+
+// Stat describes the object stored in the first destination which has not failed.
+func (tee *TeeStorage) Stat(ctx context.Context, bucket string, key string) (*ObjectInfo, error) {
+
+	i, err := tee.reader()
+	if err != nil {
+		return nil, err
+	}
+	destinationBucket, destinationKey := tee.translate(i, bucket, key)
+	object, err := tee.destinations[i].Storage.Stat(ctx, destinationBucket, destinationKey)
+	if object != nil {
+		object.Key = tee.translateBack(i, object.Key)
+	}
+	return object, err
+}
+
+// List lists the objects of the first destination which has not failed.
+func (tee *TeeStorage) List(ctx context.Context, bucket string, prefix string, recursive bool) ([]*ObjectInfo, error) {
+
+	i, err := tee.reader()
+	if err != nil {
+		return nil, err
+	}
+	destinationBucket, destinationPrefix := tee.translate(i, bucket, prefix)
+	objects, err := tee.destinations[i].Storage.List(ctx, destinationBucket, destinationPrefix, recursive)
+	for _, object := range objects {
+		object.Key = tee.translateBack(i, object.Key)
+	}
+	return objects, err
+}
+
+// Delete deletes the object from every destination.
+func (tee *TeeStorage) Delete(ctx context.Context, bucket string, key string) error {
+
+	return tee.each(func(i int) error {
+		destinationBucket, destinationKey := tee.translate(i, bucket, key)
+		return tee.destinations[i].Storage.Delete(ctx, destinationBucket, destinationKey)
+	})
+}
+
+// EnsureBucket creates the bucket of every destination, unless it exists.
+func (tee *TeeStorage) EnsureBucket(ctx context.Context, bucket string) error {
+
+	return tee.each(func(i int) error {
+		destinationBucket, _ := tee.translate(i, bucket, "")
+		return tee.destinations[i].Storage.EnsureBucket(ctx, destinationBucket)
+	})
+}
+
+// Close closes every destination, returning the first error.
+func (tee *TeeStorage) Close() error {
+
+	var failure error
+	for _, destination := range tee.destinations {
+		if err := destination.Storage.Close(); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
@@ -0,0 +1,136 @@
+package backup_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// openTeeStorage opens a tee storage over two local storages in temporary directories, removed by the returned function,
+// along with their configurations. The first stores the back-ups under backups/ and the second under replica/.
+func openTeeStorage(t *testing.T, policy backup.DestinationPolicy) (*backup.TeeStorage, []backup.ConfigStorj, func()) {
+
+	primary, primaryConfig, cleanupPrimary := openLocalStorage(t, "primary", "backups/")
+	mirror, mirrorConfig, cleanupMirror := openLocalStorage(t, "mirror", "replica/")
+	tee := backup.NewTeeStorage([]backup.Destination{
+		{Name: "primary", Storage: primary, Bucket: primaryConfig.Bucket, UploadPath: primaryConfig.UploadPath},
+		{Name: "mirror", Storage: mirror, Bucket: mirrorConfig.Bucket, UploadPath: mirrorConfig.UploadPath},
+	}, policy, nil)
+	return tee, []backup.ConfigStorj{primaryConfig, mirrorConfig}, func() { cleanupPrimary(); cleanupMirror() }
+}
+
+// breakLocalStorage replaces the directory of a local storage by a file, failing every write to it.
+func breakLocalStorage(t *testing.T, storjConfig backup.ConfigStorj) {
+
+	if err := os.RemoveAll(storjConfig.Directory); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(storjConfig.Directory, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listKeys lists the keys of every object of the bucket.
+func listKeys(t *testing.T, storage backup.Storage, bucket string, prefix string) []string {
+
+	objects, err := storage.List(context.Background(), bucket, prefix, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+func TestTeeStorageTranslatesKeys(t *testing.T) {
+
+	ctx := context.Background()
+	tee, configs, cleanup := openTeeStorage(t, backup.RequireAll)
+	defer cleanup()
+
+	name := storeBackup(t, tee, configs[0], "inventory", time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local), bsonDocuments(t, 10), backup.UploadOptions{}, "")
+	mirror, err := backup.NewLocalStorage(configs[1].Directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"replica/" + name + "/items.bson", "replica/" + name + "/manifest.json"}
+	if keys := listKeys(t, mirror, "mirror", ""); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("stored %v in the mirror instead of %v", keys, expected)
+	}
+	// The keys read back through the tee are those of the first destination.
+	expected = []string{"backups/" + name + "/items.bson", "backups/" + name + "/manifest.json"}
+	if keys := listKeys(t, tee, "primary", "backups/"); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("listed %v instead of %v", keys, expected)
+	}
+
+	if err = tee.Delete(ctx, "primary", "backups/"+name+"/manifest.json"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := listKeys(t, mirror, "mirror", ""); len(keys) != 1 {
+		t.Errorf("left %v in the mirror after a deletion", keys)
+	}
+}
+
+func TestTeeStorageRequireAll(t *testing.T) {
+
+	ctx := context.Background()
+	tee, configs, cleanup := openTeeStorage(t, backup.RequireAll)
+	defer cleanup()
+
+	breakLocalStorage(t, configs[1])
+	if _, err := tee.Put(ctx, "primary", "backups/inventory/object", nil); err == nil || !strings.Contains(err.Error(), "mirror") {
+		t.Errorf("put to a failed destination: %v", err)
+	}
+	if status := tee.Status(); status[0].Err != nil || status[1].Err == nil {
+		t.Errorf("reported the status %+v", status)
+	}
+	if keys := listKeys(t, tee, "primary", ""); len(keys) != 0 {
+		t.Errorf("left %v in the first destination", keys)
+	}
+}
+
+func TestTeeStorageRequireAny(t *testing.T) {
+
+	ctx := context.Background()
+	tee, configs, cleanup := openTeeStorage(t, backup.RequireAny)
+	defer cleanup()
+
+	// Once the first destination fails, the back-up goes on with the mirror, which it is read back from.
+	breakLocalStorage(t, configs[0])
+	documents := bsonDocuments(t, 10)
+	name := storeBackup(t, tee, configs[0], "inventory", time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local), documents, backup.UploadOptions{}, "")
+	if status := tee.Status(); status[0].Err == nil || status[1].Err != nil {
+		t.Errorf("reported the status %+v", status)
+	}
+
+	download, object, err := tee.Get(ctx, "primary", "backups/"+name+"/items.bson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(download)
+	_ = download.Close()
+	if err != nil || len(data) != len(documents) || object.Key != "backups/"+name+"/items.bson" {
+		t.Errorf("downloaded %d bytes of %s: %v", len(data), object.Key, err)
+	}
+	if object, err = tee.Stat(ctx, "primary", "backups/"+name+"/manifest.json"); err != nil || object.Key != "backups/"+name+"/manifest.json" {
+		t.Errorf("described %+v: %v", object, err)
+	}
+	backups, err := backup.ListBackups(ctx, tee, "primary", "backups/inventory/")
+	if err != nil || len(backups) != 1 || backups[0].Prefix != "backups/"+name+"/" {
+		t.Errorf("listed the back-ups %+v: %v", backups, err)
+	}
+
+	// The operation only fails once every destination failed.
+	breakLocalStorage(t, configs[1])
+	if _, err = tee.Put(ctx, "primary", "backups/inventory/object", nil); err == nil {
+		t.Error("put with every destination failed")
+	}
+}
@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VerifiedObject is the result of verifying a single object of a back-up.
+type VerifiedObject struct {
+	Name      string
+	Documents int64
+	Problems  []string
+}
+
+// Verification is the result of verifying a back-up.
+type Verification struct {
+	Bucket string
+	Prefix string
+	// Problems lists the problems of the back-up as a whole, such as a missing manifest or missing or unlisted objects.
+	Problems []string
+	Objects  []VerifiedObject
+}
+
+// Passed reports whether the back-up and all its objects passed verification.
+func (verification *Verification) Passed() bool {
+
+	if len(verification.Problems) > 0 {
+		return false
+	}
+	for _, object := range verification.Objects {
+		if len(object.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify streams every object of the back-up at backupPath, or of the latest back-up of the database at backupPath
+// if latest is set, checking it against the manifest. The result of each object is reported as it is verified.
+// It returns the verification, whose problems do not make it an error, and any error preventing it, if occurred.
+func Verify(ctx context.Context, storage Storage, backupPath string, latest bool, decryption *Decryption, progress Progress) (*Verification, error) {
+
+	bucket, prefix, err := resolveBackup(ctx, storage, backupPath, latest)
+	if err != nil {
+		return nil, err
+	}
+	verification := &Verification{Bucket: bucket, Prefix: prefix}
+	progress.infof("Verifying %s/%s...\n", bucket, prefix)
+
+	var objects []*ObjectInfo
+	listed, err := storage.List(ctx, bucket, prefix, false)
+	if err != nil {
+		return nil, newError(ErrDownload, err)
+	}
+	for _, item := range listed {
+		if !item.IsPrefix {
+			objects = append(objects, item)
+		}
+	}
+	manifest, err := downloadManifest(ctx, storage, bucket, prefix)
+	if err != nil {
+		return nil, errorf(ErrDownload, "could not read the back-up manifest: %w", err)
+	}
+	if manifest == nil {
+		problem := fmt.Sprintf("%s has no manifest to verify it against.", prefix)
+		verification.Problems = append(verification.Problems, problem)
+		progress.report(Event{Kind: EventVerified, Bucket: bucket, Object: prefix, Err: errors.New(problem), Message: "FAIL " + problem})
+		return verification, nil
+	}
+
+	// Problems of the back-up as a whole, such as missing or unlisted objects.
+	for _, problem := range checkManifest(manifest, objects) {
+		verification.Problems = append(verification.Problems, problem)
+		progress.report(Event{Kind: EventVerified, Bucket: bucket, Object: prefix, Err: errors.New(problem), Message: "FAIL " + problem})
+	}
+
+	entries := append([]CollectionManifest(nil), manifest.Collections...)
+	for _, entry := range []*CollectionManifest{manifest.Oplog, manifest.Changes} {
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	for _, entry := range entries {
+		result := verifyObject(ctx, storage, bucket, prefix, entry, decryption)
+		verification.Objects = append(verification.Objects, result)
+		verified := Event{Kind: EventVerified, Bucket: bucket, Object: prefix + entry.Object, Collection: result.Name, Documents: result.Documents}
+		if len(result.Problems) > 0 {
+			verified.Err = errors.New(strings.Join(result.Problems, ", "))
+			verified.Message = fmt.Sprintf("FAIL %s: %s", result.Name, verified.Err)
+		} else {
+			verified.Message = fmt.Sprintf("PASS %s: %d documents", result.Name, result.Documents)
+		}
+		progress.report(verified)
+	}
+
+	if verification.Passed() {
+		progress.infof("\nBack-up %s/%s verified.", bucket, prefix)
+	} else {
+		progress.infof("\nBack-up %s/%s failed verification.", bucket, prefix)
+	}
+	return verification, nil
+}
+
+// verifyObject streams the object of a manifest entry, along with its metadata, checking its checksum,
+// document count and that every document is well-formed.
+func verifyObject(ctx context.Context, storage Storage, bucket string, prefix string, entry CollectionManifest, decryption *Decryption) VerifiedObject {
+
+	result := VerifiedObject{Name: entry.Name}
+	if entry.Metadata != "" {
+		metadataJSON, err := downloadDecoded(ctx, storage, bucket, prefix+entry.Metadata, decryption)
+		var metadata collectionMetadata
+		if err == nil {
+			err = bson.UnmarshalExtJSON(metadataJSON, true, &metadata)
+		}
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("unreadable metadata: %s", err))
+		}
+	}
+
+	download, reader, err := openDecoded(ctx, storage, bucket, prefix+entry.Object, decryption)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result
+	}
+	defer func() { _ = download.Close() }()
+	defer func() { _ = reader.Close() }()
+
+	hash := sha256.New()
+	documents := bufio.NewReader(io.TeeReader(&contextReader{ctx: ctx, reader: reader}, hash))
+	for {
+		document, err := readDocument(documents, nil)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = document.Validate()
+		}
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("malformed document %d: %s", result.Documents+1, err))
+			return result
+		}
+		result.Documents++
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != entry.SHA256 {
+		result.Problems = append(result.Problems, fmt.Sprintf("checksum %s instead of %s", checksum, entry.SHA256))
+	}
+	if result.Documents != entry.Documents {
+		result.Problems = append(result.Problems, fmt.Sprintf("%d documents instead of %d", result.Documents, entry.Documents))
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package backup_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+func TestVerifyCorruptedObject(t *testing.T) {
+
+	ctx := context.Background()
+	storage, storjConfig, cleanup := openLocalStorage(t, "bucket", "")
+	defer cleanup()
+
+	documents := bsonDocuments(t, 10)
+	name := storeBackup(t, storage, storjConfig, "inventory", time.Date(2020, time.June, 30, 12, 0, 0, 0, time.Local), documents, backup.UploadOptions{}, "")
+	verification, err := backup.Verify(ctx, storage, "bucket/"+name, false, nil, nil)
+	if err != nil || !verification.Passed() {
+		t.Fatalf("verified %+v: %v", verification, err)
+	}
+
+	// The collection is overwritten with zeros, keeping its size, then cut in its last document.
+	for _, corrupted := range [][]byte{make([]byte, len(documents)), documents[:len(documents)-1]} {
+		upload, err := storage.Put(ctx, "bucket", name+"/items.bson", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = upload.Write(corrupted); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = upload.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		verification, err = backup.Verify(ctx, storage, "bucket/"+name, false, nil, nil)
+		if err != nil || verification.Passed() || len(verification.Objects) != 1 || !strings.Contains(strings.Join(verification.Objects[0].Problems, "; "), "malformed document") {
+			t.Errorf("verified %d corrupted bytes as %+v: %v", len(corrupted), verification, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// addCollectionFilterFlags sets up the flags selecting the collections to back up.
+func addCollectionFilterFlags(cmd *cobra.Command) {
+
+	cmd.Flags().StringArray("include-collection", nil, "only back up the collections matching the glob, or the regular expression enclosed in slashes, can be repeated.")
+	cmd.Flags().StringArray("exclude-collection", nil, "do not back up the collections matching the glob, or the regular expression enclosed in slashes, can be repeated.")
+}
+
+// applyCollectionFilterFlags adds the collection patterns of the flags of the command to the MongoDB configuration.
+func applyCollectionFilterFlags(cmd *cobra.Command, configMongoDB *backup.ConfigMongoDB) error {
+
+	include, _ := cmd.Flags().GetStringArray("include-collection")
+	exclude, _ := cmd.Flags().GetStringArray("exclude-collection")
+	configMongoDB.IncludeCollections = append(configMongoDB.IncludeCollections, include...)
+	configMongoDB.ExcludeCollections = append(configMongoDB.ExcludeCollections, exclude...)
+	return configMongoDB.ValidateCollections()
+}
@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Prefixes of the environment variables and of the `set` flag overriding the fields of the configuration files.
+const (
+	mongoEnvPrefix   = "MONGODB_"
+	storjEnvPrefix   = "STORJ_"
+	mongoFlagPrefix  = "mongo."
+	storjFlagPrefix  = "storj."
+	secretFileSuffix = "_file"
+)
+
+// configOverrides holds the `field=value` overrides of the `set` flag, prefixed by mongo. or storj.
+var configOverrides []string
+
+// envReference matches the ${ENV_VAR} and ${ENV_VAR:-default} references interpolated in the configuration files.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// redacted replaces the secret values displayed.
+const redacted = "********"
+
+// redact hides a secret value, showing whether it is set.
+func redact(secret string) string {
+
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// loadConfigFile reads the JSON, YAML or TOML configuration file into config, a pointer to a configuration struct.
+// ${ENV_VAR} references in string values are interpolated, and a `<field>_file` key is replaced by a `<field>` key
+// holding the contents of the file it names, as for Docker and Kubernetes secrets.
+// If overrides is set, every field may then be overridden by the environment variable named by envPrefix followed by the upper
+// snake case of its JSON key, such as MONGODB_PASSWORD, or its _FILE variant, and finally by the `set` flag with flagPrefix.
+// The overrides only apply to the main configuration of a command, not to the further ones such as copy destinations.
+func loadConfigFile(fullFileName string, envPrefix string, flagPrefix string, overrides bool, config interface{}) error {
+
+	fields, err := readConfigFile(fullFileName)
+	if err != nil {
+		return err
+	}
+	return decodeConfig(fullFileName, fields, envPrefix, flagPrefix, overrides, false, config)
+}
+
+// readConfigFile decodes a configuration file, in YAML if its extension is .yaml or .yml, in TOML if it is .toml
+// and in JSON otherwise.
+func readConfigFile(fullFileName string) (map[string]interface{}, error) {
+
+	contents, err := ioutil.ReadFile(filepath.Clean(fullFileName))
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(fullFileName)) {
+	case ".yaml", ".yml":
+		var document interface{}
+		if err = yaml.Unmarshal(contents, &document); err == nil && document != nil {
+			var ok bool
+			if fields, ok = stringKeys(document).(map[string]interface{}); !ok {
+				err = errors.New("the document is not a mapping")
+			}
+		}
+	case ".toml":
+		_, err = toml.Decode(string(contents), &fields)
+	default:
+		err = json.Unmarshal(contents, &fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", fullFileName, err)
+	}
+	return fields, nil
+}
+
+// stringKeys converts the mappings decoded from YAML, whose keys may be of any type, to maps of string keys.
+func stringKeys(value interface{}) interface{} {
+
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+	}
+	return value
+}
+
+// decodeConfig resolves the fields decoded from a configuration file, as loadConfigFile does, into config.
+// name describes where the fields come from in errors. The environment variables and the `set` flag override the fields
+// only if overrides is set. If strict is set, unknown fields are an error.
+func decodeConfig(name string, fields map[string]interface{}, envPrefix string, flagPrefix string, overrides bool, strict bool, config interface{}) error {
+
+	interpolated, err := interpolateEnv(fields)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	fields = interpolated.(map[string]interface{})
+	if err = resolveSecretFiles(fields); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	configType := reflect.TypeOf(config).Elem()
+	for key, value := range fields {
+		field, ok := configFieldByKey(configType, key)
+		if !ok {
+			if strict {
+				return fmt.Errorf("%s: %s", name, unknownKeyError(configType, key))
+			}
+			continue
+		}
+		// Scalars are accepted for strings, such as a port number given as a YAML integer.
+		if field.Type.Kind() == reflect.String {
+			switch value.(type) {
+			case float64, int, int64, bool:
+				fields[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	for i := 0; overrides && envPrefix != "" && i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		envName := envPrefix + upperSnakeCase(key)
+		if value, ok := os.LookupEnv(envName); ok {
+			if fields[key], err = parseConfigValue(field.Type, value); err != nil {
+				return fmt.Errorf("invalid %s environment variable: %w", envName, err)
+			}
+		}
+		if secretFile, ok := os.LookupEnv(envName + strings.ToUpper(secretFileSuffix)); ok {
+			secret, err := readSecretFile(secretFile)
+			if err != nil {
+				return err
+			}
+			fields[key] = secret
+		}
+	}
+
+	for _, override := range configOverrides {
+		if !overrides || !strings.HasPrefix(override, flagPrefix) {
+			continue
+		}
+		separator := strings.Index(override, "=")
+		if separator < 0 {
+			return fmt.Errorf("invalid override %q, expected %sfield=value", override, flagPrefix)
+		}
+		key, value := override[len(flagPrefix):separator], override[separator+1:]
+		field, ok := configFieldByKey(configType, key)
+		if !ok {
+			return fmt.Errorf("invalid override %q: %s", override, unknownKeyError(configType, key))
+		}
+		if fields[key], err = parseConfigValue(field.Type, value); err != nil {
+			return fmt.Errorf("invalid override %q: %w", override, err)
+		}
+	}
+
+	// Decode the resolved fields into the configuration struct.
+	resolved, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(resolved))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err = decoder.Decode(config); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) {
+			return fmt.Errorf("%s: invalid value of %s, expected %s but got %s", name, typeError.Field, typeError.Type, typeError.Value)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// unknownKeyError describes a key which is not a field of the configuration struct, suggesting the closest field.
+func unknownKeyError(configType reflect.Type, key string) error {
+
+	suggestion, distance := "", len(key)/2+1
+	for i := 0; i < configType.NumField(); i++ {
+		field := strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]
+		if d := editDistance(strings.ToLower(key), strings.ToLower(field)); d < distance {
+			suggestion, distance = field, d
+		}
+	}
+	if suggestion != "" {
+		return fmt.Errorf("unknown key %q, did you mean %q?", key, suggestion)
+	}
+	return fmt.Errorf("unknown key %q", key)
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a string, b string) int {
+
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// minInt returns the smallest of two integers.
+func minInt(a int, b int) int {
+
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// interpolateEnv replaces the ${ENV_VAR} references of the string values of a decoded JSON value.
+// A reference to an unset variable without a default is an error.
+func interpolateEnv(value interface{}) (interface{}, error) {
+
+	switch value := value.(type) {
+	case string:
+		var err error
+		interpolated := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+			match := envReference.FindStringSubmatch(reference)
+			if env, ok := os.LookupEnv(match[1]); ok {
+				return env
+			}
+			if strings.Contains(reference, ":-") {
+				return match[2]
+			}
+			err = fmt.Errorf("environment variable %s is not set", match[1])
+			return ""
+		})
+		return interpolated, err
+	case map[string]interface{}:
+		for key, item := range value {
+			interpolated, err := interpolateEnv(item)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = interpolated
+		}
+	case []interface{}:
+		for i, item := range value {
+			interpolated, err := interpolateEnv(item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = interpolated
+		}
+	}
+	return value, nil
+}
+
+// resolveSecretFiles replaces each `<field>_file` key by a `<field>` key holding the contents of the file.
+func resolveSecretFiles(fields map[string]interface{}) error {
+
+	for key, value := range fields {
+		if !strings.HasSuffix(key, secretFileSuffix) {
+			continue
+		}
+		fileName, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a file name", key)
+		}
+		secret, err := readSecretFile(fileName)
+		if err != nil {
+			return err
+		}
+		delete(fields, key)
+		fields[strings.TrimSuffix(key, secretFileSuffix)] = secret
+	}
+	return nil
+}
+
+// readSecretFile returns the contents of a secret file, without its trailing newline.
+func readSecretFile(fileName string) (string, error) {
+
+	contents, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file: %w", err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// configFieldByKey returns the field of the configuration struct with the given JSON key.
+func configFieldByKey(configType reflect.Type, key string) (reflect.StructField, bool) {
+
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if strings.Split(field.Tag.Get("json"), ",")[0] == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// parseConfigValue parses an override of a field of the given type into its JSON value.
+// Lists are given comma-separated, maps and structs in JSON.
+func parseConfigValue(fieldType reflect.Type, value string) (interface{}, error) {
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Slice:
+		if fieldType.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if value == "" {
+				return []string{}, nil
+			}
+			return strings.Split(value, ","), nil
+		}
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// upperSnakeCase converts a JSON key, such as uploadPath or tlsCAFile, to UPLOAD_PATH or TLS_CA_FILE.
+func upperSnakeCase(key string) string {
+
+	runes := []rune(key)
+	var snake strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			snake.WriteByte('_')
+		}
+		snake.WriteRune(unicode.ToUpper(r))
+	}
+	return snake.String()
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(directory) }()
+	secretFile := filepath.Join(directory, "secret")
+	if err = ioutil.WriteFile(secretFile, []byte("secret from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved []string) { configOverrides = saved }(configOverrides)
+
+	// The SECRET_FILE placeholder of the file and the environment is replaced by the path of the secret file.
+	tests := []struct {
+		name      string
+		file      string
+		env       map[string]string
+		set       []string
+		overrides bool
+		expected  backup.ConfigStorj
+		fails     bool
+	}{
+		{name: "plain", file: `{"bucket": "bucket", "virtualHostedStyle": true}`, overrides: true,
+			expected: backup.ConfigStorj{Bucket: "bucket", VirtualHostedStyle: true}},
+		{name: "environment variable", file: `{"bucket": "bucket", "apikey": "key"}`, env: map[string]string{"STORJ_BUCKET": "env", "STORJ_UPLOAD_PATH": "path/"}, overrides: true,
+			expected: backup.ConfigStorj{Bucket: "env", UploadPath: "path/", APIKey: "key"}},
+		{name: "environment secret file", file: `{"apikey": "key"}`, env: map[string]string{"STORJ_APIKEY_FILE": "SECRET_FILE"}, overrides: true,
+			expected: backup.ConfigStorj{APIKey: "secret from file"}},
+		{name: "invalid environment variable", file: `{}`, env: map[string]string{"STORJ_VIRTUAL_HOSTED_STYLE": "maybe"}, overrides: true, fails: true},
+		{name: "secret file key", file: `{"apikey_file": "SECRET_FILE"}`,
+			expected: backup.ConfigStorj{APIKey: "secret from file"}},
+		{name: "missing secret file", file: `{"apikey_file": "SECRET_FILE.missing"}`, fails: true},
+		{name: "reference", file: `{"bucket": "${CONFIG_TEST_BUCKET}", "uploadPath": "${CONFIG_TEST_UNSET:-default/}"}`, env: map[string]string{"CONFIG_TEST_BUCKET": "referenced"},
+			expected: backup.ConfigStorj{Bucket: "referenced", UploadPath: "default/"}},
+		{name: "unset reference", file: `{"bucket": "${CONFIG_TEST_UNSET}"}`, fails: true},
+		{name: "set flag", file: `{"bucket": "bucket"}`, env: map[string]string{"STORJ_BUCKET": "env"}, set: []string{"storj.bucket=set", "mongo.database=ignored", "storj.virtualHostedStyle=true"}, overrides: true,
+			expected: backup.ConfigStorj{Bucket: "set", VirtualHostedStyle: true}},
+		{name: "unknown set flag", file: `{}`, set: []string{"storj.bucekt=set"}, overrides: true, fails: true},
+		{name: "without overrides", file: `{"bucket": "${CONFIG_TEST_BUCKET}", "apikey_file": "SECRET_FILE"}`,
+			env: map[string]string{"CONFIG_TEST_BUCKET": "referenced", "STORJ_BUCKET": "env", "STORJ_APIKEY": "env"}, set: []string{"storj.bucket=set", "storj.bucekt=ignored"},
+			expected: backup.ConfigStorj{Bucket: "referenced", APIKey: "secret from file"}},
+	}
+
+	for _, test := range tests {
+		fileName := filepath.Join(directory, "storj_config.json")
+		if err = ioutil.WriteFile(fileName, []byte(strings.Replace(test.file, "SECRET_FILE", secretFile, -1)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range test.env {
+			_ = os.Setenv(name, strings.Replace(value, "SECRET_FILE", secretFile, -1))
+		}
+		configOverrides = test.set
+
+		var configStorj backup.ConfigStorj
+		err := loadConfigFile(fileName, storjEnvPrefix, storjFlagPrefix, test.overrides, &configStorj)
+		for name := range test.env {
+			_ = os.Unsetenv(name)
+		}
+		switch {
+		case test.fails && err == nil:
+			t.Errorf("%s: loaded %+v instead of failing", test.name, configStorj)
+		case !test.fails && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case !test.fails && configStorj != test.expected:
+			t.Errorf("%s: loaded %+v instead of %+v", test.name, configStorj, test.expected)
+		}
+	}
+}
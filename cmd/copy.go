@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// copyCmd represents the copy command
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "Command to copy back-ups from a storj bucket to another one.",
+	Long:  `Command to replicate a back-up, or the back-ups of the databases matching a pattern, from a bucket to another one, on the same or another satellite, project or backend, streaming every object from one to the other without writing anything to disk. Every object is checked against the manifest of its back-up and the objects already present in the destination are skipped, so that an interrupted copy can be run again. The oplog segments captured under <db>/oplog/ for point-in-time recovery are not copied.`,
+	Run:   mongoCopy,
+}
+
+func init() {
+
+	// Setup the copy command with its flags.
+	rootCmd.AddCommand(copyCmd)
+	var defaultBackupPathStorj string
+	var defaultMatchDatabase string
+	var defaultStorjFile string
+	var defaultDestinationFile string
+	copyCmd.Flags().BoolP("accesskey", "a", false, "Connect to the source using access key(default connection method is by using API Key).")
+	copyCmd.Flags().Bool("to-accesskey", false, "Connect to the destination using access key(default connection method is by using API Key).")
+	copyCmd.Flags().StringVarP(&defaultBackupPathStorj, "path", "p", "", "storj path of the back-up to be copied in the format bucket/uploadPath/db/dbYYYY-MM-DD_HH_MM_SS, or bucket/uploadPath with match.")
+	copyCmd.Flags().BoolP("latest", "l", false, "to copy the latest back-up of the database at the given path, or of each matching database.")
+	copyCmd.Flags().StringVarP(&defaultMatchDatabase, "match", "m", "", "pattern to match with the database(s) whose back-ups are to be copied.")
+	copyCmd.Flags().StringVarP(&defaultStorjFile, "storj", "s", "././config/storj_config.json", "full filepath contaning storj V3 configuration of the source.")
+	copyCmd.Flags().StringVarP(&defaultDestinationFile, "to", "t", "", "full filepath contaning storj V3 configuration of the destination, whose bucket and upload path receive the back-ups.")
+	copyCmd.Flags().BoolP("force", "f", false, "copy the back-up even if its manifest reports it as incomplete.")
+	copyCmd.Flags().StringSlice("encryption-key", nil, "keyfile of the key which encrypted the back-up, can be repeated to try several keys, to verify its checksums.")
+	copyCmd.Flags().String("encryption-identity", "", "age identity file able to decrypt the back-up encrypted for age recipients, to verify its checksums.")
+}
+
+func mongoCopy(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	fullFileNameDestination, _ := cmd.Flags().GetString("to")
+	backupPath, _ := cmd.Flags().GetString("path")
+	matchPattern, _ := cmd.Flags().GetString("match")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+	useDestinationAccessKey, _ := cmd.Flags().GetBool("to-accesskey")
+	backupLatest, _ := cmd.Flags().GetBool("latest")
+	forceCopy, _ := cmd.Flags().GetBool("force")
+	encryptionKeyFiles, _ := cmd.Flags().GetStringSlice("encryption-key")
+	encryptionIdentityFile, _ := cmd.Flags().GetString("encryption-identity")
+	if backupPath == "" {
+		log.Fatal("Error: path is required!\n")
+	}
+	if fullFileNameDestination == "" {
+		log.Fatal("Error: to is required!\n")
+	}
+	if len(strings.Split(matchPattern, "/")) > 1 {
+		log.Fatal("Error: Invalid regular expression! It should only contain the pattern of database name.\n")
+	}
+
+	copyOptions := backup.CopyOptions{Force: forceCopy, Progress: printProgress(false)}
+	if len(encryptionKeyFiles) > 0 || encryptionIdentityFile != "" {
+		decryption, err := backup.LoadDecryption(encryptionKeyFiles, encryptionIdentityFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		copyOptions.Decryption = decryption
+	}
+
+	// Read the configurations of both storages and connect to them.
+	sourceConfig := LoadStorjConfiguration(fullFileNameStorj)
+	destinationConfig := loadStorjConfigurationFile(fullFileNameDestination, false)
+	_, source := ConnectToStorage(sourceConfig, useAccessKey)
+	defer func() { _ = source.Close() }()
+	_, destination := ConnectToStorage(destinationConfig, useDestinationAccessKey)
+	defer func() { _ = destination.Close() }()
+
+	fmt.Printf("Initiating copy to %s.\n\n", describeStorage(destinationConfig))
+	ctx := context.Background()
+	var stats backup.CopyStats
+	var err error
+	if matchPattern != "" {
+		stats, err = backup.CopyMatching(ctx, source, matchPattern, backupPath, backupLatest, destination, destinationConfig, copyOptions)
+	} else {
+		stats, err = backup.Copy(ctx, source, backupPath, backupLatest, destination, destinationConfig, copyOptions)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("\nCopied %d back-up(s): %d object(s) copied (%d bytes), %d already present.\n", stats.Backups, stats.Copied, stats.Bytes, stats.Skipped)
+}
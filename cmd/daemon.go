@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Command to run scheduled back-ups to storj V3 network.",
+	Long:  `Command to keep running and back up MongoDB databases to the given Storj Bucket on the cron schedules of a schedule file, retrying failed back-ups.`,
+	Run:   mongoDaemon,
+}
+
+func init() {
+
+	// Setup the daemon command with its flags.
+	rootCmd.AddCommand(daemonCmd)
+	var defaultScheduleFile string
+	var defaultStorjFile string
+	daemonCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
+	daemonCmd.Flags().StringVarP(&defaultScheduleFile, "schedule", "c", "././config/schedule.json", "full filepath contaning the back-up schedules.")
+	daemonCmd.Flags().StringVarP(&defaultStorjFile, "storj", "u", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+}
+
+// ConfigSchedule depicts keys to search for within the schedule.json file.
+type ConfigSchedule struct {
+	// Jitter delays every back-up by a random duration up to it, such as "5m".
+	Jitter string `json:"jitter"`
+	// Retries is the number of times a failed back-up is retried.
+	Retries int `json:"retries"`
+	// RetryDelay is the delay before the first retry, doubled on each following one, such as "1m".
+	RetryDelay string                  `json:"retryDelay"`
+	Backups    []ConfigScheduledBackup `json:"backups"`
+}
+
+// ConfigScheduledBackup describes a back-up of the schedule file.
+type ConfigScheduledBackup struct {
+	Name string `json:"name"`
+	// Mongo is the full filepath containing the MongoDB configuration of the database, relative to the schedule file.
+	// It defaults to the MongoDB configuration of the active profile.
+	Mongo string `json:"mongo"`
+	// Databases, AllDatabases, Include, Exclude and SystemDatabases select the databases to back up, as the flags of the store command do.
+	Databases       []string `json:"databases"`
+	AllDatabases    bool     `json:"allDatabases"`
+	Include         string   `json:"include"`
+	Exclude         string   `json:"exclude"`
+	SystemDatabases bool     `json:"systemDatabases"`
+	// Schedule is the cron expression of the back-up.
+	Schedule             string                 `json:"schedule"`
+	Oplog                bool                   `json:"oplog"`
+	Incremental          bool                   `json:"incremental"`
+	Compress             string                 `json:"compress"`
+	CompressionLevel     int                    `json:"compressionLevel"`
+	EncryptionKey        string                 `json:"encryptionKey"`
+	EncryptionRecipients string                 `json:"encryptionRecipients"`
+	Parallel             int                    `json:"parallel"`
+	Retention            backup.RetentionPolicy `json:"retention"`
+}
+
+// scheduledBackup is a back-up of the schedule file ready to run.
+type scheduledBackup struct {
+	name          string
+	schedule      *CronSchedule
+	configMongoDB backup.ConfigMongoDB
+	databases     backup.DatabaseSelection
+	backupOptions backup.BackupOptions
+	retention     backup.RetentionPolicy
+	// running holds a token while the back-up runs, to prevent overlapping runs.
+	running chan struct{}
+}
+
+// daemon runs the scheduled back-ups.
+type daemon struct {
+	storage     backup.Storage
+	storjConfig backup.ConfigStorj
+	jitter      time.Duration
+	retries     int
+	retryDelay  time.Duration
+	// running holds a token per database while one of its back-ups runs, so that the back-ups of a database
+	// scheduled by several entries, such as a full and an incremental one, do not overlap.
+	running      map[string]chan struct{}
+	runningMutex sync.Mutex
+}
+
+// loadSchedule reads the schedule file, or the schedule of the active profile, and prepares its back-ups.
+func loadSchedule(fullFileName string) (ConfigSchedule, []*scheduledBackup, error) {
+
+	var configSchedule ConfigSchedule
+	if activeProfile != nil && activeProfile.schedule != nil {
+		configSchedule = *activeProfile.schedule
+		fullFileName = activeProfile.file
+	} else if err := loadConfigFile(fullFileName, "", "schedule.", true, &configSchedule); err != nil {
+		return configSchedule, nil, fmt.Errorf("could not load schedule file: %w", err)
+	}
+	if len(configSchedule.Backups) == 0 {
+		return configSchedule, nil, fmt.Errorf("no back-ups in the schedule file")
+	}
+
+	var backups []*scheduledBackup
+	var err error
+	for i, config := range configSchedule.Backups {
+		scheduled := &scheduledBackup{name: config.Name, retention: config.Retention, running: make(chan struct{}, 1)}
+		if scheduled.name == "" {
+			scheduled.name = fmt.Sprintf("back-up %d", i+1)
+		}
+		if scheduled.schedule, err = ParseCronSchedule(config.Schedule); err != nil {
+			return configSchedule, nil, fmt.Errorf("%s: %w", scheduled.name, err)
+		}
+		switch mongoFile := config.Mongo; {
+		case mongoFile != "":
+			if !filepath.IsAbs(mongoFile) {
+				mongoFile = filepath.Join(filepath.Dir(fullFileName), mongoFile)
+			}
+			scheduled.configMongoDB = loadMongoFile(mongoFile)
+		case activeProfile != nil && activeProfile.mongo != nil:
+			scheduled.configMongoDB = LoadMongoProperty("")
+		default:
+			return configSchedule, nil, fmt.Errorf("%s: no mongo configuration file", scheduled.name)
+		}
+		if scheduled.databases, err = backup.NewDatabaseSelection(config.Databases, config.AllDatabases, config.Include, config.Exclude, config.SystemDatabases); err != nil {
+			return configSchedule, nil, fmt.Errorf("%s: %w", scheduled.name, err)
+		}
+		scheduled.backupOptions = backup.BackupOptions{Oplog: config.Oplog, Incremental: config.Incremental}
+		scheduled.backupOptions.UploadOptions.Compression = backup.Compression{Codec: config.Compress, Level: config.CompressionLevel}
+		scheduled.backupOptions.UploadOptions.Parallel = config.Parallel
+		scheduled.backupOptions.UploadOptions.Progress = printProgress(false)
+		if err = scheduled.backupOptions.UploadOptions.Compression.Validate(); err != nil {
+			return configSchedule, nil, fmt.Errorf("%s: %w", scheduled.name, err)
+		}
+		if scheduled.backupOptions.UploadOptions.Encryption, err = backup.LoadEncryption(config.EncryptionKey, config.EncryptionRecipients); err != nil {
+			return configSchedule, nil, fmt.Errorf("%s: %w", scheduled.name, err)
+		}
+		backups = append(backups, scheduled)
+	}
+	return configSchedule, backups, nil
+}
+
+// schedule runs the back-up on its schedule until ctx is cancelled, then waits for its running back-up to finish.
+// A run is skipped if the previous one is still running.
+func (daemon *daemon) schedule(ctx context.Context, scheduled *scheduledBackup) {
+
+	for {
+		next := scheduled.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("%s: the schedule never matches, no back-up will run.\n", scheduled.name)
+			return
+		}
+		log.Printf("%s: next back-up at %s.\n", scheduled.name, next.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			// Wait for the running back-up, if any.
+			scheduled.running <- struct{}{}
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		select {
+		case scheduled.running <- struct{}{}:
+			go func() {
+				defer func() { <-scheduled.running }()
+				daemon.run(ctx, scheduled)
+			}()
+		default:
+			log.Printf("%s: the previous back-up is still running, skipping this one.\n", scheduled.name)
+		}
+	}
+}
+
+// run takes the back-up of each selected database after a random jitter, retrying it with an exponential backoff if it fails,
+// then applies its retention policy.
+func (daemon *daemon) run(ctx context.Context, scheduled *scheduledBackup) {
+
+	if daemon.jitter > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(daemon.jitter)))):
+		}
+	}
+
+	databases, err := backup.SelectDatabases(ctx, scheduled.configMongoDB, scheduled.databases)
+	if err != nil {
+		log.Printf("%s: could not list the databases: %s\n", scheduled.name, err)
+		return
+	}
+	for _, database := range databases {
+		if ctx.Err() != nil {
+			return
+		}
+		daemon.runDatabase(ctx, scheduled, database)
+	}
+}
+
+// lockDatabase waits until no other back-up of the database runs, unless ctx is cancelled first.
+// It returns the function releasing the database, or nil if ctx was cancelled.
+func (daemon *daemon) lockDatabase(ctx context.Context, scheduled *scheduledBackup, database string) func() {
+
+	daemon.runningMutex.Lock()
+	if daemon.running == nil {
+		daemon.running = make(map[string]chan struct{})
+	}
+	running, ok := daemon.running[database]
+	if !ok {
+		running = make(chan struct{}, 1)
+		daemon.running[database] = running
+	}
+	daemon.runningMutex.Unlock()
+
+	select {
+	case running <- struct{}{}:
+	default:
+		log.Printf("%s: another back-up of %s is running, waiting for it to finish.\n", scheduled.name, database)
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return func() { <-running }
+}
+
+// runDatabase takes the back-up of a database, once no other back-up of it runs, retrying it with an exponential backoff if it fails,
+// then applies the retention policy of the back-up.
+func (daemon *daemon) runDatabase(ctx context.Context, scheduled *scheduledBackup, database string) {
+
+	unlock := daemon.lockDatabase(ctx, scheduled, database)
+	if unlock == nil {
+		return
+	}
+	defer unlock()
+	configMongoDB := backup.DatabaseConfig(scheduled.configMongoDB, database)
+	delay := daemon.retryDelay
+	for attempt := 0; ; attempt++ {
+		log.Printf("%s: starting back-up of %s.\n", scheduled.name, database)
+		manifest, err := backup.Store(context.Background(), daemon.storage, daemon.storjConfig, configMongoDB, scheduled.backupOptions)
+		if err == nil {
+			log.Printf("%s: back-up of %s complete, %d collections uploaded.\n", scheduled.name, database, len(manifest.Collections))
+			break
+		}
+		if attempt >= daemon.retries {
+			log.Printf("%s: back-up of %s failed, giving up after %d attempts: %s\n", scheduled.name, database, attempt+1, err)
+			return
+		}
+		log.Printf("%s: back-up of %s failed, retrying in %s: %s\n", scheduled.name, database, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	if !scheduled.retention.IsEmpty() {
+		databasePath := daemon.storjConfig.Bucket + "/" + daemon.storjConfig.UploadPath + database
+		if _, err := backup.Prune(context.Background(), daemon.storage, databasePath, scheduled.retention, false, printProgress(false)); err != nil {
+			log.Printf("%s: prune of %s failed: %s\n", scheduled.name, database, err)
+		}
+	}
+}
+
+func mongoDaemon(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	scheduleFile, _ := cmd.Flags().GetString("schedule")
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+
+	// Read the back-up schedules, failing early on any invalid one.
+	configSchedule, backups, err := loadSchedule(scheduleFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	daemon := &daemon{retries: configSchedule.Retries, retryDelay: time.Minute}
+	if configSchedule.Jitter != "" {
+		if daemon.jitter, err = time.ParseDuration(configSchedule.Jitter); err != nil {
+			log.Fatal("Invalid jitter : ", err)
+		}
+	}
+	if configSchedule.RetryDelay != "" {
+		if daemon.retryDelay, err = time.ParseDuration(configSchedule.RetryDelay); err != nil {
+			log.Fatal("Invalid retry delay : ", err)
+		}
+	}
+
+	// Read storj network configurations from and external file and create a storj configuration object.
+	daemon.storjConfig = LoadStorjConfiguration(fullFileNameStorj)
+
+	// Connect to storj network using the specified credentials.
+	_, daemon.storage = ConnectToStorage(daemon.storjConfig, useAccessKey)
+	defer func() { _ = daemon.storage.Close() }()
+
+	// Stop scheduling back-ups on interruption, letting the running ones finish.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		log.Printf("Stopping, waiting for the running back-ups to finish.\n")
+		cancel()
+	}()
+
+	fmt.Printf("\nRunning %d scheduled back-ups.\n\n", len(backups))
+	var wg sync.WaitGroup
+	for _, scheduled := range backups {
+		wg.Add(1)
+		go func(scheduled *scheduledBackup) {
+			defer wg.Done()
+			daemon.schedule(ctx, scheduled)
+		}(scheduled)
+	}
+	wg.Wait()
+	fmt.Printf("\nDaemon stopped.\n")
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDaemonLockDatabase(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	daemon := &daemon{}
+	full, incremental := &scheduledBackup{name: "full"}, &scheduledBackup{name: "incremental"}
+
+	unlock := daemon.lockDatabase(ctx, full, "shop")
+	// The back-ups of another database run meanwhile.
+	if unlockUsers := daemon.lockDatabase(ctx, incremental, "users"); unlockUsers == nil {
+		t.Fatal("could not lock another database")
+	} else {
+		unlockUsers()
+	}
+
+	// Another back-up of the database waits for the running one to finish.
+	locked := make(chan func(), 1)
+	go func() { locked <- daemon.lockDatabase(ctx, incremental, "shop") }()
+	select {
+	case <-locked:
+		t.Fatal("two back-ups of the database ran together")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock = <-locked:
+		if unlock == nil {
+			t.Fatal("could not lock the database once released")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the database was never released")
+	}
+
+	// Waiting stops once the daemon stops.
+	go func() { locked <- daemon.lockDatabase(ctx, full, "shop") }()
+	cancel()
+	if unlock = <-locked; unlock != nil {
+		t.Error("locked the database after the daemon stopped")
+	}
+}
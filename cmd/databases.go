@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// addDatabaseSelectionFlags sets up the flags selecting the databases to back up.
+func addDatabaseSelectionFlags(cmd *cobra.Command) {
+
+	cmd.Flags().StringSlice("databases", nil, "comma-separated list of databases to back up instead of the database of the MongoDB configuration.")
+	cmd.Flags().Bool("all-databases", false, "back up every database of the instance, except admin, config and local.")
+	cmd.Flags().String("include", "", "only back up the databases of the instance matching the regular expression.")
+	cmd.Flags().String("exclude", "", "do not back up the databases of the instance matching the regular expression.")
+	cmd.Flags().Bool("system-databases", false, "also back up the admin and config databases with all-databases, include or exclude.")
+}
+
+// databaseSelectionFromFlags reads the database selection from the flags of the command.
+func databaseSelectionFromFlags(cmd *cobra.Command) (backup.DatabaseSelection, error) {
+
+	databases, _ := cmd.Flags().GetStringSlice("databases")
+	all, _ := cmd.Flags().GetBool("all-databases")
+	include, _ := cmd.Flags().GetString("include")
+	exclude, _ := cmd.Flags().GetString("exclude")
+	system, _ := cmd.Flags().GetBool("system-databases")
+	return backup.NewDatabaseSelection(databases, all, include, exclude, system)
+}
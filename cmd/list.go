@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Command to list the back-ups stored on storj V3 network.",
+	Long:  `Command to connect to storj network and list the back-ups of every MongoDB database stored under the configured bucket and upload path, along with the size of their collections.`,
+	Run:   mongoList,
+}
+
+func init() {
+
+	// Setup the list command with its flags.
+	rootCmd.AddCommand(listCmd)
+	var defaultStorjFile string
+	var defaultMatchDatabase string
+	var defaultFormat string
+	var defaultFrom string
+	var defaultTo string
+	listCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
+	listCmd.Flags().StringVarP(&defaultStorjFile, "storj", "s", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	listCmd.Flags().StringVarP(&defaultMatchDatabase, "match", "m", "", "pattern to match with the database(s) whose back-ups are listed.")
+	listCmd.Flags().StringVarP(&defaultFormat, "format", "f", "table", "output format: table, json or csv.")
+	listCmd.Flags().StringVar(&defaultFrom, "from", "", "only list back-ups taken at or after the given time, in the format YYYY-MM-DD or YYYY-MM-DD_HH_MM_SS.")
+	listCmd.Flags().StringVar(&defaultTo, "to", "", "only list back-ups taken at or before the given time, in the format YYYY-MM-DD or YYYY-MM-DD_HH_MM_SS.")
+}
+
+// listedBackup is the listing entry of a single back-up.
+type listedBackup struct {
+	Database string         `json:"database"`
+	Path     string         `json:"path"`
+	Time     time.Time      `json:"time"`
+	Size     int64          `json:"size"`
+	Objects  []listedObject `json:"objects"`
+}
+
+// listedObject is the listing entry of a single object of a back-up.
+type listedObject struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// parseBackupTime parses a time given on the command line, either as a date or as a back-up timestamp.
+// A date marks the start of the day, or its end if endOfDay is set.
+func parseBackupTime(value string, endOfDay bool) (time.Time, error) {
+
+	if parsed, err := time.ParseInLocation(backup.BackupTimeFormat, value, time.Local); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or YYYY-MM-DD_HH_MM_SS", value)
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return parsed, nil
+}
+
+func mongoList(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+	matchPattern, _ := cmd.Flags().GetString("match")
+	format, _ := cmd.Flags().GetString("format")
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+
+	if format != "table" && format != "json" && format != "csv" {
+		log.Fatalf("Error: Invalid output format %q! It should be table, json or csv.\n", format)
+	}
+	matcher, err := regexp.Compile(matchPattern)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fromTime, toTime time.Time
+	if from != "" {
+		if fromTime, err = parseBackupTime(from, false); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if to != "" {
+		if toTime, err = parseBackupTime(to, true); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Connection messages are kept out of stdout, which only holds the listing.
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	// Read storj network configurations from and external file and create a storj configuration object.
+	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
+
+	// Connect to storj network using the specified credentials.
+	_, storage := ConnectToStorage(storjConfig, useAccessKey)
+	defer func() { _ = storage.Close() }()
+	os.Stdout = stdout
+
+	ctx := context.Background()
+	databasePrefixes, err := backup.ListDatabases(ctx, storage, storjConfig.Bucket, storjConfig.UploadPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listed := []listedBackup{}
+	for _, databasePrefix := range databasePrefixes {
+		if !matcher.MatchString(path.Base(databasePrefix)) {
+			continue
+		}
+		backups, err := backup.ListBackups(ctx, storage, storjConfig.Bucket, databasePrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, info := range backups {
+			if (!fromTime.IsZero() && info.Time.Before(fromTime)) || (!toTime.IsZero() && info.Time.After(toTime)) {
+				continue
+			}
+			entry := listedBackup{Database: info.Database, Path: info.Bucket + "/" + info.Prefix, Time: info.Time, Size: info.Size, Objects: []listedObject{}}
+			for _, object := range info.Objects {
+				entry.Objects = append(entry.Objects, listedObject{Name: strings.TrimPrefix(object.Key, info.Prefix), Size: object.Size})
+			}
+			listed = append(listed, entry)
+		}
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(listed)
+	case "csv":
+		err = writeBackupsCSV(listed)
+	default:
+		err = writeBackupsTable(listed)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeBackupsTable prints the back-ups as an aligned table, one row per object followed by the back-up total.
+func writeBackupsTable(listed []listedBackup) error {
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "DATABASE\tBACK-UP\tOBJECT\tSIZE")
+	for _, backup := range listed {
+		database, backupTime := backup.Database, backup.Time.Format("2006-01-02 15:04:05")
+		for _, object := range backup.Objects {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\n", database, backupTime, object.Name, object.Size)
+			database, backupTime = "", ""
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\n", database, backupTime, "total", backup.Size)
+	}
+	return writer.Flush()
+}
+
+// writeBackupsCSV prints the back-ups as CSV, one record per object followed by the back-up total with an empty object name.
+func writeBackupsCSV(listed []listedBackup) error {
+
+	writer := csv.NewWriter(os.Stdout)
+	records := [][]string{{"database", "path", "time", "object", "size"}}
+	for _, backup := range listed {
+		backupTime := backup.Time.Format(time.RFC3339)
+		for _, object := range backup.Objects {
+			records = append(records, []string{backup.Database, backup.Path, backupTime, object.Name, strconv.FormatInt(object.Size, 10)})
+		}
+		records = append(records, []string{backup.Database, backup.Path, backupTime, "", strconv.FormatInt(backup.Size, 10)})
+	}
+	return writer.WriteAll(records)
+}
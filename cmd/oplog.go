@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// oplogCmd represents the oplog command
+var oplogCmd = &cobra.Command{
+	Use:   "oplog",
+	Short: "Command to continuously capture the oplog of a MongoDB replica set to storj V3 network.",
+	Long:  `Command to tail the oplog of a MongoDB replica set and upload the entries of the desired database to the given Storj Bucket as segments, next to its back-ups, to allow point-in-time recovery.`,
+	Run:   mongoOplog,
+}
+
+func init() {
+
+	// Setup the oplog command with its flags.
+	rootCmd.AddCommand(oplogCmd)
+	var defaultMongoFile string
+	var defaultStorjFile string
+	oplogCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
+	oplogCmd.Flags().StringVarP(&defaultMongoFile, "mongo", "m", "././config/db_property.json", "full filepath contaning MongoDB configuration.")
+	oplogCmd.Flags().StringVarP(&defaultStorjFile, "storj", "u", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	oplogCmd.Flags().DurationP("interval", "i", 10*time.Minute, "maximum time span of an oplog segment before it is uploaded.")
+	oplogCmd.Flags().Int64P("segment-size", "z", 64*1024*1024, "maximum size in bytes of an oplog segment before it is uploaded.")
+	oplogCmd.Flags().DurationP("duration", "d", 0, "stop capturing after the given time, e.g. until the next full back-up (default: run until interrupted).")
+}
+
+func mongoOplog(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	mongoConfigfilePath, _ := cmd.Flags().GetString("mongo")
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+	captureOptions := backup.CaptureOptions{Progress: printProgress(false)}
+	captureOptions.Interval, _ = cmd.Flags().GetDuration("interval")
+	captureOptions.SegmentSize, _ = cmd.Flags().GetInt64("segment-size")
+	duration, _ := cmd.Flags().GetDuration("duration")
+
+	// Read MongoDB instance's configurations from an external file and create an MongoDB configuration object.
+	configMongoDB := LoadMongoProperty(mongoConfigfilePath)
+
+	// Read storj network configurations from and external file and create a storj configuration object.
+	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
+
+	// Connect to storj network using the specified credentials.
+	_, storage := ConnectToStorage(storjConfig, useAccessKey)
+	defer func() { _ = storage.Close() }()
+
+	// Stop on interruption or once the given duration elapsed, after uploading the pending entries.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if duration > 0 {
+		time.AfterFunc(duration, cancel)
+	}
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		cancel()
+	}()
+
+	fmt.Println("Connecting to MongoDB...")
+	if err := backup.CaptureOplog(ctx, storage, storjConfig, configMongoDB, captureOptions); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("\nOplog capture stopped.\n")
+}
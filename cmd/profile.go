@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// Environment variables selecting the unified configuration file and its profile when the flags are not given.
+const (
+	configFileEnv    = "CONNECTOR_CONFIG"
+	configProfileEnv = "CONNECTOR_PROFILE"
+	// defaultProfile is the profile used when none is selected and the file has several.
+	defaultProfile = "default"
+)
+
+// profileFlagSections maps the keys of the sections of a profile which set flags shared by several commands to the flags.
+var profileFlagSections = map[string]map[string]string{
+	"retention":   {"keepLast": "keep-last", "keepDaily": "keep-daily", "keepWeekly": "keep-weekly", "keepMonthly": "keep-monthly"},
+	"compression": {"codec": "compress", "level": "compression-level"},
+}
+
+// configProfile is the selected profile of the unified configuration file.
+type configProfile struct {
+	file string
+	name string
+	// mongo, storj and schedule replace the configuration files of the commands, when set.
+	mongo    *backup.ConfigMongoDB
+	storj    *backup.ConfigStorj
+	schedule *ConfigSchedule
+	// flags holds, for each section, the values of the flags it sets.
+	flags map[string]map[string]string
+}
+
+// activeProfile is the profile selected by the `config` and `profile` flags, if any.
+var activeProfile *configProfile
+
+// description describes where the configuration of the profile is read from.
+func (profile *configProfile) description() string {
+
+	return fmt.Sprintf("%s profile of the %s", profile.name, profile.file)
+}
+
+// loadProfile reads the named profile of the unified configuration file, a JSON, YAML or TOML file of the form
+//
+//	profiles:
+//	  prod:
+//	    mongo: {...}        # fields of the MongoDB configuration file
+//	    storj: {...}        # fields of the Storj configuration file
+//	    compression: {codec: zstd, level: 3}
+//	    retention: {keepDaily: 7, keepWeekly: 4}
+//	    schedule: {...}     # fields of the schedule file of the daemon command
+//	    store: {oplog: true} # default values of the flags of a command
+//
+// validating every key. If name is empty, the only profile of the file or else the default profile is read.
+func loadProfile(fullFileName string, name string) (*configProfile, error) {
+
+	document, err := readConfigFile(fullFileName)
+	if err != nil {
+		return nil, err
+	}
+	for key := range document {
+		if key != "profiles" {
+			return nil, fmt.Errorf("%s: unknown key %q, expected profiles", fullFileName, key)
+		}
+	}
+	profiles, ok := document["profiles"].(map[string]interface{})
+	if !ok || len(profiles) == 0 {
+		return nil, fmt.Errorf("%s: no profiles", fullFileName)
+	}
+	if name == "" {
+		name = defaultProfile
+		if len(profiles) == 1 {
+			for only := range profiles {
+				name = only
+			}
+		}
+	}
+	sections, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		var names []string
+		for profileName := range profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s: no %s profile, expected one of %s", fullFileName, name, strings.Join(names, ", "))
+	}
+
+	profile := &configProfile{file: fullFileName, name: name, flags: make(map[string]map[string]string)}
+	for key, value := range sections {
+		source := fmt.Sprintf("%s: profile %s: %s", fullFileName, name, key)
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a mapping", source)
+		}
+		switch key {
+		case "mongo":
+			profile.mongo = &backup.ConfigMongoDB{}
+			err = decodeConfig(source, section, mongoEnvPrefix, mongoFlagPrefix, true, true, profile.mongo)
+		case "storj":
+			profile.storj = &backup.ConfigStorj{}
+			err = decodeConfig(source, section, storjEnvPrefix, storjFlagPrefix, true, true, profile.storj)
+		case "schedule":
+			profile.schedule = &ConfigSchedule{}
+			err = decodeConfig(source, section, "", "schedule.", true, true, profile.schedule)
+		default:
+			profile.flags[key], err = profileFlags(source, key, section)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// profileFlags returns the flag values set by a section of a profile, which is either a section of profileFlagSections
+// or named after a command, checking they are flags of the command.
+func profileFlags(source string, key string, section map[string]interface{}) (map[string]string, error) {
+
+	flags := make(map[string]string, len(section))
+	if sectionFlags, ok := profileFlagSections[key]; ok {
+		for name, value := range section {
+			flag, ok := sectionFlags[name]
+			if !ok {
+				var known []string
+				for knownName := range sectionFlags {
+					known = append(known, knownName)
+				}
+				sort.Strings(known)
+				return nil, fmt.Errorf("%s: unknown key %q, expected %s", source, name, strings.Join(known, ", "))
+			}
+			flags[flag] = profileFlagValue(value)
+		}
+		return flags, nil
+	}
+
+	var command *cobra.Command
+	for _, subcommand := range rootCmd.Commands() {
+		if subcommand.Name() == key {
+			command = subcommand
+		}
+	}
+	if command == nil {
+		return nil, fmt.Errorf("%s: unknown section, expected mongo, storj, schedule, compression, retention or the name of a command", source)
+	}
+	for name, value := range section {
+		flag := command.Flags().Lookup(name)
+		if flag == nil {
+			return nil, fmt.Errorf("%s: unknown flag %q of the %s command", source, name, key)
+		}
+		// The single value of a repeatable flag is a list of one value, commas included.
+		if flag.Value.Type() == "stringArray" && (reflect.TypeOf(value) == nil || reflect.TypeOf(value).Kind() != reflect.Slice) {
+			value = []interface{}{value}
+		}
+		flags[name] = profileFlagValue(value)
+	}
+	return flags, nil
+}
+
+// profileFlagValue formats the value of a flag set by a profile, joining lists with commas as comma-separated values,
+// quoting the values holding commas.
+func profileFlagValue(value interface{}) string {
+
+	if reflect.TypeOf(value) != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+		items := reflect.ValueOf(value)
+		values := make([]string, items.Len())
+		for i := range values {
+			values[i] = fmt.Sprint(items.Index(i).Interface())
+		}
+		var line strings.Builder
+		writer := csv.NewWriter(&line)
+		_ = writer.Write(values)
+		writer.Flush()
+		return strings.TrimSuffix(line.String(), "\n")
+	}
+	return fmt.Sprint(value)
+}
+
+// setProfileFlag sets a flag of the command to the value of a profile.
+// Repeatable flags, whose values may hold commas, are set once per value of the list.
+func setProfileFlag(cmd *cobra.Command, name string, value string) error {
+
+	if cmd.Flags().Lookup(name).Value.Type() != "stringArray" {
+		return cmd.Flags().Set(name, value)
+	}
+	values, err := csv.NewReader(strings.NewReader(value)).Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err = cmd.Flags().Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyProfile sets the flags of the command from the active profile, unless they were given on the command line,
+// the section of the command taking precedence over the shared sections.
+// The MongoDB and Storj configurations of the profile are not used when their configuration files are given.
+func applyProfile(cmd *cobra.Command) error {
+
+	given := make(map[string]bool)
+	cmd.Flags().Visit(func(flag *pflag.Flag) { given[flag.Name] = true })
+	if cmd.Flags().Changed("mongo") {
+		activeProfile.mongo = nil
+	}
+	if cmd.Flags().Changed("storj") {
+		activeProfile.storj = nil
+	}
+	if cmd.Flags().Changed("schedule") {
+		activeProfile.schedule = nil
+	}
+
+	var sections []string
+	for section := range profileFlagSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range append(sections, cmd.Name()) {
+		for name, value := range activeProfile.flags[section] {
+			if cmd.Flags().Lookup(name) == nil || given[name] {
+				continue
+			}
+			if err := setProfileFlag(cmd, name, value); err != nil {
+				return fmt.Errorf("%s: %s: invalid value %q of %s: %w", activeProfile.description(), section, value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// selectProfile loads the profile selected by the `config` and `profile` flags, or their environment variables,
+// and applies it to the command.
+func selectProfile(cmd *cobra.Command, args []string) error {
+
+	configFile, _ := cmd.Flags().GetString("config")
+	profileName, _ := cmd.Flags().GetString("profile")
+	if configFile == "" {
+		configFile = os.Getenv(configFileEnv)
+	}
+	if profileName == "" {
+		profileName = os.Getenv(configProfileEnv)
+	}
+	if configFile == "" {
+		if profileName != "" {
+			return fmt.Errorf("profile %s selected without a configuration file", profileName)
+		}
+		return nil
+	}
+
+	var err error
+	if activeProfile, err = loadProfile(configFile, profileName); err != nil {
+		return err
+	}
+	return applyProfile(cmd)
+}
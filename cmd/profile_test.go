@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// writeProfileFile writes a unified configuration file to the directory.
+func writeProfileFile(t *testing.T, directory string, name string, contents string) string {
+
+	fileName := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadProfileFormats(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(directory) }()
+
+	// The same profiles are written in each format.
+	files := map[string]string{
+		"connector.yaml": `profiles:
+  prod:
+    mongo: {hostname: mongo.example.com, port: 27017, database: shop, hosts: [a:1, b:2]}
+    storj: {bucket: backups, virtualHostedStyle: true}
+    compression: {codec: zstd, level: 3}
+    retention: {keepDaily: 7}
+    schedule: {retries: 2, retryDelay: 1m}
+    store: {oplog: true, databases: [shop, users]}
+  test:
+    mongo: {database: test}
+`,
+		"connector.toml": `[profiles.prod.mongo]
+hostname = "mongo.example.com"
+port = 27017
+database = "shop"
+hosts = ["a:1", "b:2"]
+[profiles.prod.storj]
+bucket = "backups"
+virtualHostedStyle = true
+[profiles.prod.compression]
+codec = "zstd"
+level = 3
+[profiles.prod.retention]
+keepDaily = 7
+[profiles.prod.schedule]
+retries = 2
+retryDelay = "1m"
+[profiles.prod.store]
+oplog = true
+databases = ["shop", "users"]
+[profiles.test.mongo]
+database = "test"
+`,
+		"connector.json": `{"profiles": {
+  "prod": {
+    "mongo": {"hostname": "mongo.example.com", "port": "27017", "database": "shop", "hosts": ["a:1", "b:2"]},
+    "storj": {"bucket": "backups", "virtualHostedStyle": true},
+    "compression": {"codec": "zstd", "level": 3},
+    "retention": {"keepDaily": 7},
+    "schedule": {"retries": 2, "retryDelay": "1m"},
+    "store": {"oplog": true, "databases": ["shop", "users"]}
+  },
+  "test": {"mongo": {"database": "test"}}
+}}`,
+	}
+	expectedFlags := map[string]map[string]string{
+		"compression": {"compress": "zstd", "compression-level": "3"},
+		"retention":   {"keep-daily": "7"},
+		"store":       {"oplog": "true", "databases": "shop,users"},
+	}
+
+	for name, contents := range files {
+		fileName := writeProfileFile(t, directory, name, contents)
+		profile, err := loadProfile(fileName, "prod")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if profile.mongo == nil || profile.mongo.Hostname != "mongo.example.com" || profile.mongo.Portnumber != "27017" || profile.mongo.Database != "shop" || !reflect.DeepEqual(profile.mongo.Hosts, []string{"a:1", "b:2"}) {
+			t.Errorf("%s: read the mongo section %+v", name, profile.mongo)
+		}
+		if profile.storj == nil || *profile.storj != (backup.ConfigStorj{Bucket: "backups", VirtualHostedStyle: true}) {
+			t.Errorf("%s: read the storj section %+v", name, profile.storj)
+		}
+		if profile.schedule == nil || profile.schedule.Retries != 2 || profile.schedule.RetryDelay != "1m" {
+			t.Errorf("%s: read the schedule section %+v", name, profile.schedule)
+		}
+		if !reflect.DeepEqual(profile.flags, expectedFlags) {
+			t.Errorf("%s: read the flags %v", name, profile.flags)
+		}
+		if profile, err = loadProfile(fileName, "test"); err != nil || profile.mongo.Database != "test" || profile.storj != nil {
+			t.Errorf("%s: read the test profile %+v: %v", name, profile, err)
+		}
+		// Of several profiles, none named default, one must be selected.
+		if _, err = loadProfile(fileName, ""); err == nil || !strings.Contains(err.Error(), "expected one of prod, test") {
+			t.Errorf("%s: read a profile without selecting any: %v", name, err)
+		}
+	}
+}
+
+func TestLoadProfileErrors(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(directory) }()
+
+	tests := []struct {
+		name     string
+		contents string
+		// fails is part of the error, or empty if the only profile is read.
+		fails string
+	}{
+		{"only profile", `profiles: {prod: {storj: {bucket: backups}}}`, ""},
+		{"unknown top key", `profile: {prod: {}}`, `unknown key "profile"`},
+		{"no profiles", `profiles: {}`, "no profiles"},
+		{"unknown configuration key", `profiles: {prod: {mongo: {hostnam: mongo}}}`, `did you mean "hostname"`},
+		{"unknown section", `profiles: {prod: {restore_all: {}}}`, "unknown section"},
+		{"unknown flag", `profiles: {prod: {store: {opolg: true}}}`, `unknown flag "opolg" of the store command`},
+		{"unknown shared flag", `profiles: {prod: {retention: {keepYearly: 1}}}`, `unknown key "keepYearly"`},
+		{"section not a mapping", `profiles: {prod: {store: true}}`, "expected a mapping"},
+	}
+
+	for _, test := range tests {
+		fileName := writeProfileFile(t, directory, "connector.yml", test.contents)
+		profile, err := loadProfile(fileName, "")
+		switch {
+		case test.fails == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.fails == "" && (profile.name != "prod" || profile.storj.Bucket != "backups"):
+			t.Errorf("%s: read %+v", test.name, profile)
+		case test.fails != "" && (err == nil || !strings.Contains(err.Error(), test.fails)):
+			t.Errorf("%s: read with the error %v instead of %q", test.name, err, test.fails)
+		}
+	}
+}
+
+func TestApplyProfilePrecedence(t *testing.T) {
+
+	defer func(saved *configProfile) { activeProfile = saved }(activeProfile)
+	activeProfile = &configProfile{
+		file:  "connector.yaml",
+		name:  "prod",
+		mongo: &backup.ConfigMongoDB{Database: "shop"},
+		storj: &backup.ConfigStorj{Bucket: "backups"},
+		flags: map[string]map[string]string{
+			"compression": {"compress": "zstd", "compression-level": "3"},
+			"retention":   {"keep-daily": "7"},
+			"store":       {"oplog": "true", "keep-daily": "14"},
+			"restore":     {"latest": "true"},
+		},
+	}
+	command := &cobra.Command{Use: "store"}
+	command.Flags().String("mongo", "", "")
+	command.Flags().String("storj", "", "")
+	command.Flags().Bool("oplog", false, "")
+	command.Flags().Bool("latest", false, "")
+	command.Flags().String("compress", "", "")
+	command.Flags().Int("compression-level", 0, "")
+	command.Flags().Int("keep-daily", 0, "")
+	if err := command.ParseFlags([]string{"--compression-level=9", "--mongo=mongo.json"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyProfile(command); err != nil {
+		t.Fatal(err)
+	}
+	// The flags given on the command line win, then the section of the command over the shared sections.
+	expected := map[string]string{"compress": "zstd", "compression-level": "9", "oplog": "true", "keep-daily": "14", "latest": "false"}
+	for name, value := range expected {
+		if flag := command.Flags().Lookup(name); flag.Value.String() != value {
+			t.Errorf("%s is %s instead of %s", name, flag.Value, value)
+		}
+	}
+	// The configuration file given replaces the section of the profile.
+	if activeProfile.mongo != nil || activeProfile.storj == nil {
+		t.Errorf("kept the configurations %+v and %+v", activeProfile.mongo, activeProfile.storj)
+	}
+
+	command = &cobra.Command{Use: "store"}
+	command.Flags().Bool("oplog", false, "")
+	activeProfile.flags["store"]["oplog"] = "maybe"
+	if err := applyProfile(command); err == nil {
+		t.Error("applied an invalid value")
+	}
+}
+
+func TestCollectionFilterPatterns(t *testing.T) {
+
+	directory, err := ioutil.TempDir("", "connector-mongodb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(directory) }()
+
+	// The regular expressions hold commas, which do not separate the patterns of the command line.
+	command := &cobra.Command{Use: "store"}
+	addCollectionFilterFlags(command)
+	if err = command.ParseFlags([]string{"--include-collection=/^log_[0-9]{1,3}$/", "--include-collection=orders"}); err != nil {
+		t.Fatal(err)
+	}
+	var configMongoDB backup.ConfigMongoDB
+	if err = applyCollectionFilterFlags(command, &configMongoDB); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/^log_[0-9]{1,3}$/", "orders"}; !reflect.DeepEqual(configMongoDB.IncludeCollections, expected) {
+		t.Errorf("included %q instead of %q", configMongoDB.IncludeCollections, expected)
+	}
+
+	// Nor the patterns of a profile, listed or single.
+	defer func(saved *configProfile) { activeProfile = saved }(activeProfile)
+	fileName := writeProfileFile(t, directory, "connector.yaml", `profiles:
+  prod:
+    store:
+      include-collection: ["/^log_[0-9]{1,3}$/", "orders"]
+      exclude-collection: "/^tmp_[a-z]{2,}$/"
+`)
+	if activeProfile, err = loadProfile(fileName, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	command = &cobra.Command{Use: "store"}
+	addCollectionFilterFlags(command)
+	if err = applyProfile(command); err != nil {
+		t.Fatal(err)
+	}
+	configMongoDB = backup.ConfigMongoDB{}
+	if err = applyCollectionFilterFlags(command, &configMongoDB); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/^log_[0-9]{1,3}$/", "orders"}; !reflect.DeepEqual(configMongoDB.IncludeCollections, expected) {
+		t.Errorf("included %q instead of %q", configMongoDB.IncludeCollections, expected)
+	}
+	if expected := []string{"/^tmp_[a-z]{2,}$/"}; !reflect.DeepEqual(configMongoDB.ExcludeCollections, expected) {
+		t.Errorf("excluded %q instead of %q", configMongoDB.ExcludeCollections, expected)
+	}
+}
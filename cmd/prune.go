@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Command to delete old back-ups from storj V3 network.",
+	Long:  `Command to connect to storj network and delete the back-ups of the desired MongoDB database(s) which are not retained by the given retention policy.`,
+	Run:   mongoPrune,
+}
+
+func init() {
+
+	// Setup the prune command with its flags.
+	rootCmd.AddCommand(pruneCmd)
+	var defaultBackupPathStorj string
+	var defaultStorjFile string
+	pruneCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
+	pruneCmd.Flags().StringVarP(&defaultBackupPathStorj, "path", "p", "", "storj path of the database to prune in the format bucket/uploadPath/db (default: all databases under the configured bucket and upload path).")
+	pruneCmd.Flags().StringVarP(&defaultStorjFile, "storj", "s", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	pruneCmd.Flags().BoolP("dry-run", "n", false, "only list the back-ups that would be deleted.")
+	addRetentionFlags(pruneCmd)
+}
+
+// addRetentionFlags registers the flags defining a retention policy on the command.
+func addRetentionFlags(command *cobra.Command) {
+
+	command.Flags().Int("keep-last", 0, "number of most recent back-ups to keep.")
+	command.Flags().Int("keep-daily", 0, "number of days for which the latest back-up of each day is kept.")
+	command.Flags().Int("keep-weekly", 0, "number of weeks for which the latest back-up of each week is kept.")
+	command.Flags().Int("keep-monthly", 0, "number of months for which the latest back-up of each month is kept.")
+}
+
+// retentionPolicyFromFlags reads the retention policy registered by addRetentionFlags.
+func retentionPolicyFromFlags(command *cobra.Command) backup.RetentionPolicy {
+
+	var policy backup.RetentionPolicy
+	policy.KeepLast, _ = command.Flags().GetInt("keep-last")
+	policy.KeepDaily, _ = command.Flags().GetInt("keep-daily")
+	policy.KeepWeekly, _ = command.Flags().GetInt("keep-weekly")
+	policy.KeepMonthly, _ = command.Flags().GetInt("keep-monthly")
+	return policy
+}
+
+// PruneBackups deletes the back-ups of the database stored at backupPath, in the format bucket/uploadPath/db,
+// which are not retained by the policy. The back-ups are only listed if dryRun is set.
+// It returns the number of bytes freed.
+func PruneBackups(storage backup.Storage, backupPath string, policy backup.RetentionPolicy, dryRun bool) int64 {
+
+	freed, err := backup.Prune(context.Background(), storage, backupPath, policy, dryRun, printProgress(false))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return freed
+}
+
+func mongoPrune(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	backupPath, _ := cmd.Flags().GetString("path")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	policy := retentionPolicyFromFlags(cmd)
+	if policy.IsEmpty() {
+		log.Fatal("Error: At least one of the `keep-last`, `keep-daily`, `keep-weekly` or `keep-monthly` flags is required!\n")
+	}
+
+	// Read storj network configurations from and external file and create a storj configuration object.
+	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
+
+	// Connect to storj network using the specified credentials.
+	_, storage := ConnectToStorage(storjConfig, useAccessKey)
+	defer func() { _ = storage.Close() }()
+
+	fmt.Printf("Initiating prune.\n\n")
+	if backupPath != "" {
+		PruneBackups(storage, backupPath, policy, dryRun)
+		return
+	}
+
+	// Prune every database backed up under the configured upload path.
+	databasePrefixes, err := backup.ListDatabases(context.Background(), storage, storjConfig.Bucket, storjConfig.UploadPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var freed int64
+	for _, databasePrefix := range databasePrefixes {
+		freed += PruneBackups(storage, storjConfig.Bucket+"/"+databasePrefix, policy, dryRun)
+	}
+	if dryRun {
+		fmt.Printf("\nPrune dry run complete, %d bytes would be freed in total.\n", freed)
+	} else {
+		fmt.Printf("\nPrune complete, %d bytes freed in total.\n", freed)
+	}
+}
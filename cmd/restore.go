@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
 )
 
 // restoreCmd represents the restore command
@@ -23,11 +27,23 @@ func init() {
 	var defaultBackupPathStorj string
 	var defaultMatchDatabase string
 	var defaultStorjFile string
+	var defaultMongoFile string
+	var defaultTargetDatabase string
 	restoreCmd.Flags().BoolP("progress", "b", true, "if true, show progress.")
 	restoreCmd.Flags().StringVarP(&defaultBackupPathStorj, "path", "p", "", "storj path of the back-up to be restored in the format bucket/uploadPath/db/dbYYYY-MM-DD_HH_MM_SS.")
 	restoreCmd.Flags().BoolP("latest", "l", false, "to restore the latest back-up.")
 	restoreCmd.Flags().StringVarP(&defaultMatchDatabase, "match", "m", "", "pattern to match with the database(s) whose back-up is to be restored.")
 	restoreCmd.Flags().StringVarP(&defaultStorjFile, "storj", "s", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	restoreCmd.Flags().StringVarP(&defaultMongoFile, "mongo", "g", "", "full filepath contaning MongoDB configuration of the instance to restore into, instead of the ./dump folder.")
+	restoreCmd.Flags().BoolP("drop", "d", false, "drop each collection before restoring it into MongoDB.")
+	restoreCmd.Flags().BoolP("upsert", "u", false, "replace documents with a matching _id instead of inserting them into MongoDB.")
+	restoreCmd.Flags().StringVarP(&defaultTargetDatabase, "db", "t", "", "name of the database to restore into, instead of the back-up's own database.")
+	restoreCmd.Flags().BoolP("force", "f", false, "restore the back-up even if its manifest reports it as incomplete.")
+	restoreCmd.Flags().StringSlice("encryption-key", nil, "keyfile of the key which encrypted the back-up, can be repeated to try several keys.")
+	restoreCmd.Flags().String("encryption-identity", "", "age identity file able to decrypt the back-up encrypted for age recipients.")
+	restoreCmd.Flags().Int("parallel", 1, "number of collections downloaded and restored concurrently, progress bars being only shown one at a time.")
+	restoreCmd.Flags().String("bandwidth-limit", "", "limit the total download bandwidth, such as 500KB or 10MB per second.")
+	restoreCmd.Flags().String("until", "", "restore the database as it was at the given time, in the format YYYY-MM-DD_HH_MM_SS, by replaying the captured oplog over the latest prior back-up.")
 }
 
 func mongorestore(cmd *cobra.Command, args []string) {
@@ -39,34 +55,74 @@ func mongorestore(cmd *cobra.Command, args []string) {
 	backupPath, _ := cmd.Flags().GetString("path")
 	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
 	backupLatest, _ := cmd.Flags().GetBool("latest")
+	mongoConfigfilePath, _ := cmd.Flags().GetString("mongo")
+	dropCollections, _ := cmd.Flags().GetBool("drop")
+	upsertDocuments, _ := cmd.Flags().GetBool("upsert")
+	targetDatabase, _ := cmd.Flags().GetString("db")
+	forceRestore, _ := cmd.Flags().GetBool("force")
+	restoreUntil, _ := cmd.Flags().GetString("until")
+	encryptionKeyFiles, _ := cmd.Flags().GetStringSlice("encryption-key")
+	encryptionIdentityFile, _ := cmd.Flags().GetString("encryption-identity")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	bandwidthLimit, _ := cmd.Flags().GetString("bandwidth-limit")
+	if parallel < 1 {
+		log.Fatal("Error: parallel must be at least 1!\n")
+	}
 
 	// Read storj network configurations from and external file and create a storj configuration object.
 	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
 
 	// Connect to storj network using the specified credentials.
-	_, project := ConnectToStorj(storjConfig, useAccessKey)
+	_, storage := ConnectToStorage(storjConfig, useAccessKey)
+	defer func() { _ = storage.Close() }()
+
+	// Establish connection with the target MongoDB instance, if one is specified.
+	// Progress bars are only shown one at a time.
+	restoreOptions := backup.RestoreOptions{Force: forceRestore, Parallel: parallel, Progress: printProgress(showProgress && parallel <= 1)}
+	if bandwidthLimit != "" {
+		var err error
+		if restoreOptions.BandwidthLimit, err = backup.ParseBandwidth(bandwidthLimit); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(encryptionKeyFiles) > 0 || encryptionIdentityFile != "" {
+		decryption, err := backup.LoadDecryption(encryptionKeyFiles, encryptionIdentityFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		restoreOptions.Decryption = decryption
+	}
+	if mongoConfigfilePath != "" {
+		configMongoDB := LoadMongoProperty(mongoConfigfilePath)
+		restoreOptions.MongoWriter = ConnectToDBWriter(configMongoDB, targetDatabase, dropCollections, upsertDocuments)
+		defer func() { _ = restoreOptions.MongoWriter.Close() }()
+	}
 
 	// Restore the backup from specified Storj bucket.
 	fmt.Printf("Initiating restore.\n\n")
-	if matchPattern != "" {
+	ctx := context.Background()
+	var err error
+	if restoreUntil != "" {
+		until, parseErr := time.ParseInLocation(backup.BackupTimeFormat, restoreUntil, time.Local)
+		if parseErr != nil {
+			log.Fatal("Error: Invalid time! It should be in the format YYYY-MM-DD_HH_MM_SS.\n")
+		}
+		err = backup.RestoreUntil(ctx, storage, backupPath, until, restoreOptions)
+	} else if matchPattern != "" {
 		pathTokens := strings.Split(matchPattern, "/")
 		if len(pathTokens) > 1 {
 			log.Fatal("Error: Invalid regular expression! It should only contain the pattern of database name.\n")
 		}
-		checkSlash := backupPath[len(backupPath)-1:]
-		if checkSlash == "/" {
-			backupPath = backupPath[:len(backupPath)-1]
-		}
-		pathTokens = strings.Split(backupPath, "/")
-		if len(pathTokens) > 2 {
-			log.Fatal("Error: Invalid back-up path!\n")
+		if !backupLatest {
+			log.Fatal("Error: match used without `latest` flag!")
 		}
-		MatchAndRestore(project, matchPattern, backupPath, backupLatest, showProgress)
+		err = backup.RestoreMatching(ctx, storage, matchPattern, backupPath, restoreOptions)
 	} else {
-		if backupLatest {
-			RestoreData(project, backupPath, backupLatest, showProgress)
-		} else {
-			RestoreData(project, backupPath, backupLatest, showProgress)
-		}
+		err = backup.Restore(ctx, storage, backupPath, backupLatest, restoreOptions)
+	}
+	if errors.Is(err, backup.ErrIncomplete) {
+		log.Fatalf("Error: %s. Use the `force` flag to restore it anyway.\n", err)
+	} else if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -24,4 +24,10 @@ func Execute() {
 }
 
 func init() {
+
+	// Select the profile of the unified configuration file before running any command.
+	rootCmd.PersistentPreRunE = selectProfile
+	rootCmd.PersistentFlags().String("config", "", "unified JSON, YAML or TOML configuration file holding named profiles, replacing the other configuration files (default: $"+configFileEnv+").")
+	rootCmd.PersistentFlags().String("profile", "", "profile of the unified configuration file (default: $"+configProfileEnv+", or the only profile, or default).")
+	rootCmd.PersistentFlags().StringArrayVar(&configOverrides, "set", nil, "override a field of the configuration files, such as mongo.password=secret or storj.bucket=backups, can be repeated.")
 }
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros maps the predefined schedules to their cron expression.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField describes the allowed values of a field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// Sunday is both 0 and 7.
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// CronSchedule is a parsed cron expression made of five fields: minute, hour, day of month, month and day of week.
+type CronSchedule struct {
+	// fields holds the allowed values of each field as a bit set.
+	fields [5]uint64
+	// restrictedDayOfMonth and restrictedDayOfWeek report whether the day fields are not `*` or a step of it.
+	// If both are restricted, a day matching either of them matches, as in cron.
+	restrictedDayOfMonth, restrictedDayOfWeek bool
+}
+
+// ParseCronSchedule parses a cron expression, such as `30 2 * * 1-5`, or one of the @yearly, @monthly, @weekly, @daily and @hourly macros.
+// Fields accept `*`, values, ranges, lists, steps and the names of months and days of week.
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expression))]; ok {
+		expression = macro
+	}
+	values := strings.Fields(expression)
+	if len(values) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", expression)
+	}
+
+	var schedule CronSchedule
+	for i, field := range cronFields {
+		bits, err := parseCronField(values[i], field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+		}
+		schedule.fields[i] = bits
+	}
+	// Sunday may be given as 7.
+	if schedule.fields[4]&(1<<7) != 0 {
+		schedule.fields[4] |= 1
+	}
+	schedule.restrictedDayOfMonth = !strings.HasPrefix(values[2], "*")
+	schedule.restrictedDayOfWeek = !strings.HasPrefix(values[4], "*")
+	return &schedule, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps into a bit set of the allowed values.
+func parseCronField(value string, field cronField) (uint64, error) {
+
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", field.name, value)
+			}
+			part = part[:slash]
+		}
+
+		low, high := field.min, field.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], field); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = parseCronValue(bounds[1], field); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// A single value with a step, like 5/15, runs up to the maximum.
+				high = field.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range in %s field %q", field.name, value)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number or a name of the field, checking it is within the allowed values.
+func parseCronValue(value string, field cronField) (int, error) {
+
+	for i, name := range field.names {
+		if strings.EqualFold(value, name) {
+			return i + field.min, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < field.min || number > field.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d to %d", field.name, value, field.min, field.max)
+	}
+	return number, nil
+}
+
+// Next returns the first time after t matching the schedule, in the location of t.
+// It returns the zero time if the schedule never matches, like on February 30th.
+func (schedule *CronSchedule) Next(t time.Time) time.Time {
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// A matching day is found within a few years, unless the schedule never matches.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !schedule.matches(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !schedule.matches(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !schedule.matches(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matches reports whether the value is allowed by the field.
+func (schedule *CronSchedule) matches(field int, value int) bool {
+
+	return schedule.fields[field]&(1<<uint(value)) != 0
+}
+
+// matchesDay reports whether the day of t is allowed by the day of month and day of week fields.
+func (schedule *CronSchedule) matchesDay(t time.Time) bool {
+
+	dayOfMonth := schedule.matches(2, t.Day())
+	dayOfWeek := schedule.matches(4, int(t.Weekday()))
+	if schedule.restrictedDayOfMonth && schedule.restrictedDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
@@ -0,0 +1,45 @@
+package cmd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/storj-thirdparty/connector-mongodb/cmd"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+
+	from := time.Date(2020, time.June, 30, 12, 34, 56, 0, time.UTC) // a Tuesday
+	tests := []struct {
+		expression string
+		next       time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.June, 30, 12, 35, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2020, time.July, 1, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.June, 30, 12, 45, 0, 0, time.UTC)},
+		{"30 1 * * mon-fri", time.Date(2020, time.July, 1, 1, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, time.July, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2020, time.July, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := cmd.ParseCronSchedule(test.expression)
+		if err != nil {
+			t.Errorf("%s: %s", test.expression, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.next) {
+			t.Errorf("%s: next run at %s, expected %s", test.expression, next, test.next)
+		}
+	}
+
+	for _, expression := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := cmd.ParseCronSchedule(expression); err == nil {
+			t.Errorf("%s: parsed an invalid expression", expression)
+		}
+	}
+}
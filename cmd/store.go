@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"path"
-	"time"
+	"log"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
 )
 
 // storeCmd represents the store command
@@ -22,10 +24,26 @@ func init() {
 	rootCmd.AddCommand(storeCmd)
 	var defaultMongoFile string
 	var defaultStorjFile string
+	var defaultCompression string
 	storeCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
 	storeCmd.Flags().BoolP("share", "s", false, "For generating share access of the uploaded backup file.")
 	storeCmd.Flags().StringVarP(&defaultMongoFile, "mongo", "m", "././config/db_property.json", "full filepath contaning MongoDB configuration.")
 	storeCmd.Flags().StringVarP(&defaultStorjFile, "storj", "u", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	storeCmd.Flags().BoolP("oplog", "o", false, "Capture the oplog written during the back-up to make it consistent as of its end, like `mongodump --oplog`. Requires a replica set.")
+	storeCmd.Flags().BoolP("incremental", "i", false, "Only upload the changes made since the latest back-up of the database, read from a change stream. Requires a replica set.")
+	storeCmd.Flags().StringVarP(&defaultCompression, "compress", "c", "", "compress the collections' objects with gzip, zstd or snappy.")
+	storeCmd.Flags().Int("compression-level", 0, "compression level of the codec (default: the codec's default level).")
+	storeCmd.Flags().String("encryption-key", "", "encrypt the objects with data keys wrapped by the 32-byte key of the given keyfile, in hex, base64 or raw form.")
+	storeCmd.Flags().String("encryption-recipients", "", "encrypt the objects with data keys wrapped for the age recipients listed in the given file.")
+	storeCmd.Flags().Int("parallel", 1, "number of collections dumped and uploaded concurrently, sharing a 10 MB buffer.")
+	storeCmd.Flags().String("checkpoint", "", "file recording the progress of the back-ups, uploading the collections in parts so that an interrupted back-up can be resumed. It is only readable by its owner, as it may hold documents not encrypted yet.")
+	storeCmd.Flags().BoolP("resume", "r", false, "Resume the interrupted back-ups recorded in the checkpoint file instead of starting them over.")
+	storeCmd.Flags().StringSlice("mirror", nil, "storj configuration file of a further destination receiving the same back-ups, read from the database once, can be repeated.")
+	storeCmd.Flags().String("mirror-policy", "all", "whether every destination must succeed (all), or the back-up succeeds as long as one destination does (any).")
+	storeCmd.Flags().BoolP("prune", "p", false, "After the back-up, delete the database's back-ups which are not retained by the `keep-*` flags.")
+	addRetentionFlags(storeCmd)
+	addDatabaseSelectionFlags(storeCmd)
+	addCollectionFilterFlags(storeCmd)
 }
 
 func mongoStore(cmd *cobra.Command, args []string) {
@@ -34,26 +52,144 @@ func mongoStore(cmd *cobra.Command, args []string) {
 	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
 	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
 	useAccessShare, _ := cmd.Flags().GetBool("share")
+	pruneAfterStore, _ := cmd.Flags().GetBool("prune")
+	var backupOptions backup.BackupOptions
+	backupOptions.UploadOptions.Progress = printProgress(false)
+	backupOptions.Oplog, _ = cmd.Flags().GetBool("oplog")
+	backupOptions.Incremental, _ = cmd.Flags().GetBool("incremental")
+	backupOptions.UploadOptions.Compression.Codec, _ = cmd.Flags().GetString("compress")
+	backupOptions.UploadOptions.Compression.Level, _ = cmd.Flags().GetInt("compression-level")
+	if err := backupOptions.UploadOptions.Compression.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	backupOptions.UploadOptions.Parallel, _ = cmd.Flags().GetInt("parallel")
+	if backupOptions.UploadOptions.Parallel < 1 {
+		log.Fatal("Error: parallel must be at least 1!\n")
+	}
+	encryptionKeyFile, _ := cmd.Flags().GetString("encryption-key")
+	encryptionRecipientsFile, _ := cmd.Flags().GetString("encryption-recipients")
+	encryption, err := backup.LoadEncryption(encryptionKeyFile, encryptionRecipientsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	backupOptions.UploadOptions.Encryption = encryption
+	if backupOptions.Oplog && backupOptions.Incremental {
+		log.Fatal("Error: oplog cannot be used with incremental back-ups!\n")
+	}
+	backupOptions.Checkpoint, _ = cmd.Flags().GetString("checkpoint")
+	backupOptions.Resume, _ = cmd.Flags().GetBool("resume")
+	if backupOptions.Resume && backupOptions.Checkpoint == "" {
+		log.Fatal("Error: resume used without a checkpoint file!\n")
+	}
+	if backupOptions.Resume && backupOptions.Incremental {
+		log.Fatal("Error: incremental back-ups cannot be resumed!\n")
+	}
+	retentionPolicy := retentionPolicyFromFlags(cmd)
+	if pruneAfterStore && retentionPolicy.IsEmpty() {
+		log.Fatal("Error: prune used without any `keep-last`, `keep-daily`, `keep-weekly` or `keep-monthly` flag!\n")
+	}
+	databaseSelection, err := databaseSelectionFromFlags(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mirrorFiles, _ := cmd.Flags().GetStringSlice("mirror")
+	mirrorPolicyName, _ := cmd.Flags().GetString("mirror-policy")
+	mirrorPolicy, err := backup.ParseDestinationPolicy(mirrorPolicyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(mirrorFiles) > 0 {
+		if backupOptions.Resume {
+			log.Fatal("Error: back-ups to several destinations cannot be resumed!\n")
+		}
+		if backupOptions.Checkpoint != "" {
+			fmt.Println("The checkpoint file is not used for back-ups to several destinations.")
+			backupOptions.Checkpoint = ""
+		}
+	}
 
 	// Read MongoDB instance's configurations from an external file and create an MongoDB configuration object.
 	configMongoDB := LoadMongoProperty(mongoConfigfilePath)
+	if err = applyCollectionFilterFlags(cmd, &configMongoDB); err != nil {
+		log.Fatal(err)
+	}
 
 	// Read storj network configurations from and external file and create a storj configuration object.
 	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
+	if useAccessShare && storjConfig.Backend != "" && storjConfig.Backend != backup.BackendStorj {
+		log.Fatal("Error: the `share` flag is only supported by the storj backend!\n")
+	}
 
 	// Connect to storj network using the specified credentials.
-	access, project := ConnectToStorj(storjConfig, useAccessKey)
+	access, storage := ConnectToStorage(storjConfig, useAccessKey)
+	var mirrors *backup.TeeStorage
+	if len(mirrorFiles) > 0 {
+		mirrors = connectToMirrors(storjConfig, storage, mirrorFiles, mirrorPolicy, useAccessKey)
+		storage = mirrors
+	}
+	defer func() { _ = storage.Close() }()
 
-	// Establish connection with MongoDB and create the customized reader to implement streaming
-	reader := ConnectToDB(configMongoDB)
-	// Fetch all backup files from MongoDB instance and simultaneously store them into desired Storj bucket.
-	fmt.Printf("Initiating back-up.\n\n")
-	uploadFileName := path.Join(configMongoDB.Database, configMongoDB.Database+time.Now().Format("2006-01-02_15_04_05"))
-	UploadData(project, storjConfig, uploadFileName, reader, reader.collectionNames[0])
-	fmt.Printf("\nBack-up complete.\n\n")
+	// Select the databases to back up, listing those of the instance if needed.
+	ctx := context.Background()
+	databases, err := backup.SelectDatabases(ctx, configMongoDB, databaseSelection)
+	if err != nil {
+		log.Fatal("Could not list the databases : ", err)
+	}
+	if len(databases) == 0 {
+		log.Fatal("Error: no database selected for back-up!\n")
+	}
+
+	// Fetch all backup files from each MongoDB database and simultaneously store them into desired Storj bucket, each under its own prefix.
+	var failed []string
+	for _, database := range databases {
+		fmt.Printf("Initiating back-up of %s.\n\n", database)
+		if _, err = backup.Store(ctx, storage, storjConfig, backup.DatabaseConfig(configMongoDB, database), backupOptions); err != nil {
+			log.Printf("Back-up of %s failed : %s\n", database, err)
+			failed = append(failed, database)
+			continue
+		}
+		fmt.Printf("\nBack-up of %s complete.\n\n", database)
+
+		// Apply the retention policy to the back-ups of the database.
+		if pruneAfterStore {
+			PruneBackups(storage, storjConfig.Bucket+"/"+storjConfig.UploadPath+database, retentionPolicy, false)
+		}
+	}
+
+	// Report the outcome of the back-ups on each destination.
+	if mirrors != nil {
+		fmt.Println("\nDestinations:")
+		for _, status := range mirrors.Status() {
+			if status.Err != nil {
+				fmt.Printf("  %s\tFAILED: %s\n", status.Name, status.Err)
+			} else {
+				fmt.Printf("  %s\tOK\n", status.Name)
+			}
+		}
+	}
 
 	// Create restricted shareable serialized access if share is provided as argument.
 	if useAccessShare {
 		ShareAccess(access, storjConfig)
 	}
+
+	if len(failed) > 0 {
+		if backupOptions.Checkpoint != "" && !backupOptions.Incremental {
+			fmt.Println("Run the store command again with the resume flag to continue the interrupted back-ups.")
+		}
+		log.Fatal("Back-up failed for ", len(failed), " of ", len(databases), " databases : ", strings.Join(failed, ", "))
+	}
+}
+
+// connectToMirrors connects to the storage of each mirror configuration file and returns the storage
+// writing the back-ups to the storage of the Storj configuration along with every mirror.
+func connectToMirrors(storjConfig backup.ConfigStorj, storage backup.Storage, mirrorFiles []string, policy backup.DestinationPolicy, useAccessKey bool) *backup.TeeStorage {
+
+	destinations := []backup.Destination{{Name: describeStorage(storjConfig), Storage: storage, Bucket: storjConfig.Bucket, UploadPath: storjConfig.UploadPath}}
+	for _, mirrorFile := range mirrorFiles {
+		mirrorConfig := loadStorjConfigurationFile(mirrorFile, false)
+		_, mirrorStorage := ConnectToStorage(mirrorConfig, useAccessKey)
+		destinations = append(destinations, backup.Destination{Name: describeStorage(mirrorConfig), Storage: mirrorStorage, Bucket: mirrorConfig.Bucket, UploadPath: mirrorConfig.UploadPath})
+	}
+	return backup.NewTeeStorage(destinations, policy, printProgress(false))
 }
@@ -5,62 +5,57 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
-	"strconv"
 	"strings"
-	"time"
 
 	progressbar "github.com/cheggaaa/pb/v3"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
 	"storj.io/uplink"
 )
 
-// ConfigStorj depicts keys to search for within the stroj_config.json file.
-type ConfigStorj struct {
-	APIKey               string `json:"apikey"`
-	Satellite            string `json:"satellite"`
-	Bucket               string `json:"bucket"`
-	UploadPath           string `json:"uploadPath"`
-	EncryptionPassphrase string `json:"encryptionpassphrase"`
-	SerializedAccess     string `json:"serializedAccess"`
-	AllowDownload        string `json:"allowDownload"`
-	AllowUpload          string `json:"allowUpload"`
-	AllowList            string `json:"allowList"`
-	AllowDelete          string `json:"allowDelete"`
-	NotBefore            string `json:"notBefore"`
-	NotAfter             string `json:"notAfter"`
+// LoadStorjConfiguration reads and parses the JSON file that contain Storj configuration information,
+// unless the active profile of the unified configuration file sets it.
+// Its fields may reference environment variables, be read from secret files and be overridden
+// by STORJ_* environment variables and the `set` flag.
+func LoadStorjConfiguration(fullFileName string) backup.ConfigStorj {
+
+	if activeProfile != nil && activeProfile.storj != nil {
+		return showStorjConfiguration(*activeProfile.storj, activeProfile.description())
+	}
+	return loadStorjConfigurationFile(fullFileName, true)
 }
 
-// LoadStorjConfiguration reads and parses the JSON file that contain Storj configuration information.
-func LoadStorjConfiguration(fullFileName string) ConfigStorj {
+// loadStorjConfigurationFile reads the Storj configuration from the file even if the active profile sets one,
+// for the further storages a command writes to, such as mirrors and copy destinations.
+// The STORJ_* environment variables and the `set` flag only override it if overrides is set, as they target the main storage.
+func loadStorjConfigurationFile(fullFileName string, overrides bool) backup.ConfigStorj {
 
-	var configStorj ConfigStorj
-	fileHandle, err := os.Open(filepath.Clean(fullFileName))
-	if err != nil {
+	var configStorj backup.ConfigStorj
+	if err := loadConfigFile(fullFileName, storjEnvPrefix, storjFlagPrefix, overrides, &configStorj); err != nil {
 		log.Fatal("Could not load storj config file: ", err)
 	}
+	return showStorjConfiguration(configStorj, fullFileName)
+}
 
-	jsonParser := json.NewDecoder(fileHandle)
-	if err = jsonParser.Decode(&configStorj); err != nil {
-		log.Fatal(err)
-	}
-
-	// Close the file handle after reading from it.
-	if err = fileHandle.Close(); err != nil {
-		log.Fatal(err)
-	}
+// showStorjConfiguration displays the Storj configuration read from the file, returning it with its upload path in standard form.
+func showStorjConfiguration(configStorj backup.ConfigStorj, fullFileName string) backup.ConfigStorj {
 
 	// Display storj configuration read from file.
 	fmt.Println("\nRead Storj configuration from the ", fullFileName, " file")
-	fmt.Println("\nAPI Key\t\t: ", configStorj.APIKey)
-	fmt.Println("Satellite	: ", configStorj.Satellite)
+	switch configStorj.Backend {
+	case "", backup.BackendStorj:
+		fmt.Println("\nAPI Key\t\t: ", redact(configStorj.APIKey))
+		fmt.Println("Satellite	: ", configStorj.Satellite)
+	case backup.BackendLocal:
+		fmt.Println("\nBackend\t\t: ", configStorj.Backend)
+		fmt.Println("Directory	: ", configStorj.Directory)
+	default:
+		fmt.Println("\nBackend\t\t: ", configStorj.Backend)
+		fmt.Println("Endpoint	: ", configStorj.Endpoint)
+		fmt.Println("Access Key ID\t: ", redact(configStorj.AccessKeyID))
+	}
 	fmt.Println("Bucket		: ", configStorj.Bucket)
 
 	// Convert the upload path to standard form.
@@ -76,260 +71,107 @@ func LoadStorjConfiguration(fullFileName string) ConfigStorj {
 	}
 
 	fmt.Println("Upload Path\t: ", configStorj.UploadPath)
-	fmt.Println("Serialized Access Key\t: ", configStorj.SerializedAccess)
+	if configStorj.Backend == "" || configStorj.Backend == backup.BackendStorj {
+		fmt.Println("Serialized Access Key\t: ", redact(configStorj.SerializedAccess))
+	}
 	return configStorj
 }
 
 // ShareAccess generates and prints the shareable serialized access
 // as per the restrictions provided by the user.
-func ShareAccess(access *uplink.Access, configStorj ConfigStorj) {
-
-	allowDownload, _ := strconv.ParseBool(configStorj.AllowDownload)
-	allowUpload, _ := strconv.ParseBool(configStorj.AllowUpload)
-	allowList, _ := strconv.ParseBool(configStorj.AllowList)
-	allowDelete, _ := strconv.ParseBool(configStorj.AllowDelete)
-	notBefore, _ := time.Parse("2006-01-02_15:04:05", configStorj.NotBefore)
-	notAfter, _ := time.Parse("2006-01-02_15:04:05", configStorj.NotAfter)
+func ShareAccess(access *uplink.Access, configStorj backup.ConfigStorj) {
 
-	permission := uplink.Permission{
-		AllowDownload: allowDownload,
-		AllowUpload:   allowUpload,
-		AllowList:     allowList,
-		AllowDelete:   allowDelete,
-		NotBefore:     notBefore,
-		NotAfter:      notAfter,
-	}
-
-	// Create shared access.
-	sharedAccess, err := access.Share(permission)
+	serializedAccess, err := backup.Share(access, configStorj)
 	if err != nil {
 		log.Fatal("Could not generate shared access: ", err)
 	}
-
-	// Generate restricted serialized access.
-	serializedAccess, err := sharedAccess.Serialize()
-	if err != nil {
-		log.Fatal("Could not serialize shared access: ", err)
-	}
 	fmt.Println("Shareable serialized access: ", serializedAccess)
 }
 
-// ConnectToStorj reads Storj configuration from given file
-// and connects to the desired Storj network.
-// It then reads data property from an external file.
-func ConnectToStorj(configStorj ConfigStorj, accesskey bool) (*uplink.Access, *uplink.Project) {
-
-	var access *uplink.Access
-	var cfg uplink.Config
+// ConnectToStorage opens the storage of the back-ups selected by the backend of the configuration, ensuring its bucket exists.
+// The access is only returned for the Storj network, being nil for the other backends.
+func ConnectToStorage(configStorj backup.ConfigStorj, accesskey bool) (*uplink.Access, backup.Storage) {
 
-	// Configure the UserAgent
-	cfg.UserAgent = "MongoDB"
-	ctx := context.Background()
-	var err error
-
-	if accesskey {
-		fmt.Println("\nConnecting to Storj network using Serialized access.")
-		// Generate access handle using serialized access.
-		access, err = uplink.ParseAccess(configStorj.SerializedAccess)
+	switch configStorj.Backend {
+	case "", backup.BackendStorj:
+	case backup.BackendLocal:
+		fmt.Println("\nOpening the local directory ", configStorj.Directory)
+		storage, err := backup.Open(context.Background(), configStorj, accesskey)
 		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		fmt.Println("\nConnecting to Storj network.")
-		// Generate access handle using API key, satellite url and encryption passphrase.
-		access, err = cfg.RequestAccessWithPassphrase(ctx, configStorj.Satellite, configStorj.APIKey, configStorj.EncryptionPassphrase)
+		fmt.Println("Successfully opened the local directory.")
+		return nil, storage
+	default:
+		fmt.Println("\nConnecting to the S3-compatible service at ", configStorj.Endpoint)
+		storage, err := backup.Open(context.Background(), configStorj, accesskey)
 		if err != nil {
 			log.Fatal(err)
 		}
+		fmt.Println("Successfully connected to the S3-compatible service.")
+		return nil, storage
 	}
 
-	// Open a new porject.
-	project, err := cfg.OpenProject(ctx, access)
-	if err != nil {
-		log.Fatal(err)
+	if accesskey {
+		fmt.Println("\nConnecting to Storj network using Serialized access.")
+	} else {
+		fmt.Println("\nConnecting to Storj network.")
 	}
-	defer project.Close()
-
-	// Ensure the desired Bucket within the Project
-	_, err = project.EnsureBucket(ctx, configStorj.Bucket)
+	access, project, err := backup.Connect(context.Background(), configStorj, accesskey)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Println("Successfully connected to Storj network.")
-	return access, project
+	return access, backup.NewStorjStorage(project)
 }
 
-// UploadData uploads the backup file to storj network.
-func UploadData(project *uplink.Project, configStorj ConfigStorj, uploadFileName string, dbReader io.Reader, firstCollection string) {
+// describeStorage describes the storage and bucket of the configuration, to tell the destinations of a back-up apart.
+func describeStorage(configStorj backup.ConfigStorj) string {
 
-	ctx := context.Background()
-
-	// Create an upload handle for the first collection.
-	upload, err := project.UploadObject(ctx, configStorj.Bucket, configStorj.UploadPath+uploadFileName+"/"+firstCollection+".bson", nil)
-	if err != nil {
-		log.Fatal("Could not initiate upload : ", err)
-	}
-	fmt.Printf("Uploading %s to %s...\n", configStorj.UploadPath+uploadFileName+"/"+firstCollection+".bson", configStorj.Bucket)
-
-	buf := make([]byte, 10485760)
-	var err1 = io.ErrShortBuffer
-	// Loop to upload and commit each collection one by one.
-	for err1 != nil {
-		_, err1 = io.CopyBuffer(upload, dbReader, buf)
-		if err1 != nil && err1 != io.ErrShortBuffer {
-			// Commit the current copied collection.
-			err = upload.Commit()
-			if err != nil {
-				log.Fatal("Could not commit object upload : ", err)
-			}
-			// Create upload handle for the next collection to be uploaded.
-			upload, err = project.UploadObject(ctx, configStorj.Bucket, configStorj.UploadPath+uploadFileName+"/"+currentCollection+".bson", nil)
-			if err != nil {
-				log.Fatal("Could not initiate upload : ", err)
-			}
-			fmt.Printf("Uploading %s to %s...\n", configStorj.UploadPath+uploadFileName+"/"+currentCollection+".bson", configStorj.Bucket)
+	switch configStorj.Backend {
+	case "", backup.BackendStorj:
+		if configStorj.Satellite == "" {
+			return "storj:" + configStorj.Bucket
 		}
-	}
-
-	// Commit the upload after copying the last collection.
-	err = upload.Commit()
-	if err != nil {
-		log.Fatal("Could not commit object upload : ", err)
+		return "storj:" + configStorj.Satellite + "/" + configStorj.Bucket
+	case backup.BackendLocal:
+		return "local:" + filepath.Join(configStorj.Directory, configStorj.Bucket)
+	default:
+		return configStorj.Backend + ":" + strings.TrimSuffix(configStorj.Endpoint, "/") + "/" + configStorj.Bucket
 	}
 }
 
-func findLatestBackup(project *uplink.Project, backupPath string) string {
+// printProgress returns the callback printing the events of an operation,
+// along with a progress bar for every downloaded object if showBars is set.
+func printProgress(showBars bool) backup.Progress {
 
-	ctx := context.Background()
-	keys := strings.Split(backupPath, "/")
-	// Object iterator to traverse all the back-ups of the specified database.
-	objects := project.ListObjects(ctx, keys[0], &uplink.ListObjectsOptions{Prefix: backupPath[len(keys[0])+1:] + "/"})
-	var backups []string
-	// Loop to find the latest back-up of all the back-ups.
-	for objects.Next() {
-		item := objects.Item()
-		backups = append(backups, item.Key)
-	}
-	sort.Strings(backups)
-	if len(backups) == 0 {
-		log.Fatal("Error: No back-up to restore!")
-	}
-	return backups[len(backups)-1]
-}
-
-// RestoreData restores the latest backup correspoinding to the path provided
-func RestoreData(project *uplink.Project, backupPath string, latest bool, showProgress bool) {
-
-	ctx := context.Background()
-	var collections *uplink.ObjectIterator
-	keys := strings.Split(backupPath, "/")
-	if latest {
-		fmt.Printf("Restoring the latest backup of %s...\n", backupPath)
-		checkSlash := backupPath[len(backupPath)-1:]
-		if checkSlash == "/" {
-			backupPath = backupPath[:len(backupPath)-1]
-		}
-		pathTokens := strings.Split(backupPath, "/")
-		if len(pathTokens) > 3 {
-			log.Fatal("Error: Invalid regular expression! It should only contain the pattern of database name.\n")
-		}
-		latestBackup := findLatestBackup(project, backupPath)
-		collections = project.ListObjects(ctx, keys[0], &uplink.ListObjectsOptions{Prefix: latestBackup})
-	} else {
-		fmt.Printf("Restoring the backup of %s...\n", backupPath)
-		// Convert the backup path to standard form
-		checkSlash := backupPath[len(backupPath)-1:]
-		if checkSlash != "/" {
-			backupPath = backupPath + "/"
-		}
-		collections = project.ListObjects(ctx, keys[0], &uplink.ListObjectsOptions{Prefix: backupPath[len(keys[0])+1:]})
-	}
-
-	var restored []*uplink.Object
-	// Download all the collection back-up files corresponding to the back-up inside the ./dump folder.
-	for collections.Next() {
-		item := collections.Item()
-		download, err := project.DownloadObject(ctx, keys[0], item.Key, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Printf("\n")
-		var bar *progressbar.ProgressBar
-		var reader io.ReadCloser
-		if showProgress {
-			info := download.Info()
-			bar = progressbar.New64(info.System.ContentLength)
-			reader = bar.NewProxyReader(download)
-			bar.Start()
-		} else {
-			reader = download
-		}
-
-		// Read everything from the download stream
-		receivedContents, err := ioutil.ReadAll(reader)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		downloadFileName := filepath.Join("dump", filepath.Base(filepath.Dir(item.Key)), filepath.Base(item.Key))
-		_ = os.MkdirAll(filepath.Dir(downloadFileName), 0750)
-		err = ioutil.WriteFile(downloadFileName, receivedContents, 0600)
-		if err != nil {
-			log.Fatal(err)
-		}
-		restored = append(restored, item)
-	}
-	if len(restored) == 0 {
-		log.Fatal("Error: Nothing to restore as the given path.")
-	}
-	if latest {
-		fmt.Printf("\nLatest back-up restored.\n")
-	} else {
-		fmt.Printf("\nBack-up restored.\n")
-	}
-}
-
-// MatchAndRestore finds the databases corresponding the pattern entered by the user
-// and restores the latest backup of each matching database.
-func MatchAndRestore(project *uplink.Project, matchPattern string, backupPath string, latest bool, showProgress bool) {
-
-	keys := strings.Split(backupPath, "/")
-	if !latest {
-		log.Fatal("Error: match used without `latest` flag!")
-	}
-	ctx := context.Background()
-	if len(keys) == 1 {
-		if _, err := project.StatBucket(ctx, backupPath); err != nil {
-			log.Fatal(err)
-		}
-		databases := project.ListObjects(ctx, backupPath, nil)
-		for databases.Next() {
-			item := databases.Item()
-			matched, err := regexp.MatchString(matchPattern, filepath.Base(item.Key))
-			if err != nil {
-				log.Fatal(err)
+	var bar *progressbar.ProgressBar
+	return func(event backup.Event) {
+		switch {
+		case event.Kind == backup.EventDownloading:
+			if !showBars {
+				return
 			}
-			if matched {
-				fmt.Println("Matching database: ", backupPath+"/"+item.Key)
-				RestoreData(project, backupPath+"/"+item.Key, latest, showProgress)
+			if bar == nil {
+				fmt.Println()
+				bar = progressbar.New64(event.Total)
+				bar.Start()
 			}
-		}
-	} else {
-		if _, err := project.StatBucket(ctx, keys[0]); err != nil {
-			log.Fatal(err)
-		}
-		databases := project.ListObjects(ctx, keys[0], &uplink.ListObjectsOptions{Prefix: backupPath[len(keys[0])+1:] + "/"})
-		for databases.Next() {
-			item := databases.Item()
-			matched, err := regexp.MatchString(matchPattern, filepath.Base(item.Key))
-			if err != nil {
-				log.Fatal(err)
-			}
-			if matched {
-				fmt.Println("Matching database: ", keys[0]+"/"+item.Key)
-				RestoreData(project, keys[0]+"/"+item.Key, latest, showProgress)
+			bar.SetCurrent(event.Bytes)
+			return
+		case event.Kind == backup.EventRestored || event.Kind == backup.EventFailed:
+			if bar != nil {
+				bar.Finish()
+				bar = nil
 			}
 		}
+		switch {
+		case event.Message == "":
+		case event.Kind == backup.EventWarning:
+			fmt.Println("Warning: " + event.Message)
+		default:
+			fmt.Println(event.Message)
+		}
 	}
 }
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/storj-thirdparty/connector-mongodb/backup"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Command to verify the integrity of a back-up stored on storj V3 network.",
+	Long:  `Command to connect to storj network and stream every object of a back-up, checking its checksum and document count against the manifest and that every BSON document is well-formed, without writing anything to disk.`,
+	Run:   mongoVerify,
+}
+
+func init() {
+
+	// Setup the verify command with its flags.
+	rootCmd.AddCommand(verifyCmd)
+	var defaultBackupPathStorj string
+	var defaultStorjFile string
+	verifyCmd.Flags().BoolP("accesskey", "a", false, "Connect to storj using access key(default connection method is by using API Key).")
+	verifyCmd.Flags().StringVarP(&defaultBackupPathStorj, "path", "p", "", "storj path of the back-up to be verified in the format bucket/uploadPath/db/dbYYYY-MM-DD_HH_MM_SS.")
+	verifyCmd.Flags().BoolP("latest", "l", false, "to verify the latest back-up of the database at the given path.")
+	verifyCmd.Flags().StringVarP(&defaultStorjFile, "storj", "s", "././config/storj_config.json", "full filepath contaning storj V3 configuration.")
+	verifyCmd.Flags().StringSlice("encryption-key", nil, "keyfile of the key which encrypted the back-up, can be repeated to try several keys.")
+	verifyCmd.Flags().String("encryption-identity", "", "age identity file able to decrypt the back-up encrypted for age recipients.")
+}
+
+func mongoVerify(cmd *cobra.Command, args []string) {
+
+	// Process arguments from the CLI.
+	fullFileNameStorj, _ := cmd.Flags().GetString("storj")
+	backupPath, _ := cmd.Flags().GetString("path")
+	useAccessKey, _ := cmd.Flags().GetBool("accesskey")
+	backupLatest, _ := cmd.Flags().GetBool("latest")
+	encryptionKeyFiles, _ := cmd.Flags().GetStringSlice("encryption-key")
+	encryptionIdentityFile, _ := cmd.Flags().GetString("encryption-identity")
+	if backupPath == "" {
+		log.Fatal("Error: path is required!\n")
+	}
+
+	var decryption *backup.Decryption
+	if len(encryptionKeyFiles) > 0 || encryptionIdentityFile != "" {
+		var err error
+		if decryption, err = backup.LoadDecryption(encryptionKeyFiles, encryptionIdentityFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Read storj network configurations from and external file and create a storj configuration object.
+	storjConfig := LoadStorjConfiguration(fullFileNameStorj)
+
+	// Connect to storj network using the specified credentials.
+	_, storage := ConnectToStorage(storjConfig, useAccessKey)
+	defer func() { _ = storage.Close() }()
+
+	verification, err := backup.Verify(context.Background(), storage, backupPath, backupLatest, decryption, printProgress(false))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !verification.Passed() {
+		os.Exit(1)
+	}
+}
@@ -3,8 +3,18 @@ module github.com/storj-thirdparty/connector-mongodb
 go 1.13
 
 require (
+	filippo.io/age v1.0.0
+	github.com/BurntSushi/toml v0.3.1
 	github.com/cheggaaa/pb/v3 v3.0.5
+	github.com/golang/snappy v0.0.1
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/klauspost/compress v1.9.5
+	github.com/minio/minio-go/v6 v6.0.55
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.3
 	go.mongodb.org/mongo-driver v1.3.3
-	storj.io/uplink v1.0.5
+	gopkg.in/ini.v1 v1.51.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
+	storj.io/uplink v1.6.0
 )